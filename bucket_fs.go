@@ -0,0 +1,301 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type (
+	// BucketFS is a read-only fs.FS over the files under a prefix in the S3 bucket.
+	// Directories are derived from the "/"-separated object keys.
+	// It implements fs.ReadDirFS and fs.StatFS, so it can be used with fs.WalkDir, template.ParseFS
+	// and http.FileServer (see FileManager.HTTPFileSystem).
+	//
+	// The objects the file manager keeps for itself, e.g. quarantined files, originals of watermarked images
+	// and the tus upload state, are neither listed nor opened, like with ServeHandler.
+	BucketFS struct {
+		ctx    context.Context
+		fm     *FileManager
+		prefix string
+	}
+
+	// bucketFileInfo implements fs.FileInfo and fs.DirEntry for files and directories in the bucket.
+	bucketFileInfo struct {
+		name string
+		info *ObjectInfo
+	}
+
+	// bucketFile is an fs.File reading a file from the bucket.
+	bucketFile struct {
+		*objectReader
+		stat bucketFileInfo
+	}
+
+	// bucketDir is an fs.ReadDirFile representing a directory in the bucket.
+	bucketDir struct {
+		fsys    *BucketFS
+		name    string
+		stat    bucketFileInfo
+		entries []fs.DirEntry
+		loaded  bool
+	}
+)
+
+// FS returns a read-only fs.FS over the files under the prefix in the S3 bucket.
+// The context is used for all requests made by the file system.
+func (fm *FileManager) FS(ctx context.Context, prefix string) *BucketFS {
+	return &BucketFS{ctx: ctx, fm: fm, prefix: strings.Trim(prefix, "/")}
+}
+
+// HTTPFileSystem returns an http.FileSystem over the files under the prefix in the S3 bucket,
+// e.g. to be used with http.FileServer.
+func (fm *FileManager) HTTPFileSystem(ctx context.Context, prefix string) http.FileSystem {
+	return http.FS(fm.FS(ctx, prefix))
+}
+
+// Open implements the fs.FS interface.
+func (fsys *BucketFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	stat, err := fsys.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if stat.IsDir() {
+		return &bucketDir{fsys: fsys, name: name, stat: stat}, nil
+	}
+
+	return &bucketFile{objectReader: fsys.fm.newObjectReader(fsys.ctx, stat.info), stat: stat}, nil
+}
+
+// Stat implements the fs.StatFS interface.
+func (fsys *BucketFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	stat, err := fsys.stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return stat, nil
+}
+
+// ReadDir implements the fs.ReadDirFS interface.
+// The entries are sorted by file name.
+func (fsys *BucketFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries, err := fsys.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if len(entries) == 0 && name != "." {
+		if stat, err := fsys.stat(name); err == nil && !stat.IsDir() {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+// stat returns the file info of a file or a directory.
+func (fsys *BucketFS) stat(name string) (bucketFileInfo, error) {
+	if name == "." {
+		return bucketFileInfo{name: "."}, nil
+	}
+	if fsys.fm.isInternalKey(fsys.key(name)) {
+		return bucketFileInfo{}, fs.ErrNotExist
+	}
+
+	info, err := fsys.fm.Stat(fsys.ctx, fsys.key(name))
+	if err == nil {
+		return bucketFileInfo{name: path.Base(name), info: info}, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return bucketFileInfo{}, err
+	}
+
+	// there are no directories in S3, so check if there are files with the directory prefix
+	resp, err := fsys.fm.s3.ListObjectsV2WithContext(fsys.ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(fsys.fm.bucket),
+		Prefix:  aws.String(fsys.key(name) + "/"),
+		MaxKeys: aws.Int64(1),
+	})
+	if err := handleS3Error(err); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return bucketFileInfo{}, fs.ErrNotExist
+		}
+		return bucketFileInfo{}, errors.Join(ErrFailedToListFiles, err)
+	}
+	if len(resp.Contents) == 0 {
+		return bucketFileInfo{}, fs.ErrNotExist
+	}
+
+	return bucketFileInfo{name: path.Base(name)}, nil
+}
+
+// readDir lists the files and directories directly under the directory.
+func (fsys *BucketFS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := fsys.key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(fsys.fm.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+
+	var entries []fs.DirEntry
+	for {
+		resp, err := fsys.fm.s3.ListObjectsV2WithContext(fsys.ctx, input)
+		if err := handleS3Error(err); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, nil
+			}
+			return nil, errors.Join(ErrFailedToListFiles, err)
+		}
+
+		for _, p := range resp.CommonPrefixes {
+			dirName := strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(p.Prefix), prefix), "/")
+			if dirName != "" && !fsys.fm.isInternalKey(prefix+dirName) {
+				entries = append(entries, bucketFileInfo{name: dirName})
+			}
+		}
+		for _, obj := range resp.Contents {
+			key := aws.StringValue(obj.Key)
+			if key == prefix || fsys.fm.isInternalKey(key) {
+				continue // directory marker or internal object
+			}
+			entries = append(entries, bucketFileInfo{
+				name: strings.TrimPrefix(key, prefix),
+				info: &ObjectInfo{
+					Key:          key,
+					Size:         aws.Int64Value(obj.Size),
+					ETag:         aws.StringValue(obj.ETag),
+					LastModified: aws.TimeValue(obj.LastModified),
+				},
+			})
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			break
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// key returns the object key of the file.
+func (fsys *BucketFS) key(name string) string {
+	if name == "." {
+		return fsys.prefix
+	}
+	return strings.TrimLeft(path.Join(fsys.prefix, name), "/")
+}
+
+// Name implements the fs.FileInfo interface.
+func (fi bucketFileInfo) Name() string { return fi.name }
+
+// Size implements the fs.FileInfo interface.
+func (fi bucketFileInfo) Size() int64 {
+	if fi.info == nil {
+		return 0
+	}
+	return fi.info.Size
+}
+
+// Mode implements the fs.FileInfo interface.
+func (fi bucketFileInfo) Mode() fs.FileMode {
+	if fi.IsDir() {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// ModTime implements the fs.FileInfo interface.
+func (fi bucketFileInfo) ModTime() time.Time {
+	if fi.info == nil {
+		return time.Time{}
+	}
+	return fi.info.LastModified
+}
+
+// IsDir implements the fs.FileInfo interface.
+func (fi bucketFileInfo) IsDir() bool { return fi.info == nil }
+
+// Sys implements the fs.FileInfo interface.
+// It returns *ObjectInfo for files and nil for directories.
+func (fi bucketFileInfo) Sys() any {
+	if fi.info == nil {
+		return nil
+	}
+	return fi.info
+}
+
+// Type implements the fs.DirEntry interface.
+func (fi bucketFileInfo) Type() fs.FileMode { return fi.Mode().Type() }
+
+// Info implements the fs.DirEntry interface.
+func (fi bucketFileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// Stat implements the fs.File interface.
+func (f *bucketFile) Stat() (fs.FileInfo, error) { return f.stat, nil }
+
+// Stat implements the fs.File interface.
+func (d *bucketDir) Stat() (fs.FileInfo, error) { return d.stat, nil }
+
+// Read implements the fs.File interface.
+func (d *bucketDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// Close implements the fs.File interface.
+func (d *bucketDir) Close() error { return nil }
+
+// ReadDir implements the fs.ReadDirFile interface.
+func (d *bucketDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.readDir(d.name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
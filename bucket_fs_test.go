@@ -0,0 +1,115 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestBucketFS(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for key, content := range map[string]string{
+		"site/index.html":          "<h1>index</h1>",
+		"site/css/style.css":       "body{}",
+		"site/templates/page.tmpl": "{{.Title}}",
+		"other/secret.txt":         "secret",
+	} {
+		_, err := fm.Upload(ctx, bytes.NewReader([]byte(content)), key, "text/plain")
+		require.NoError(t, err)
+	}
+
+	fsys := fm.FS(ctx, "site")
+
+	t.Run("fstest", func(t *testing.T) {
+		require.NoError(t, fstest.TestFS(fsys, "index.html", "css/style.css", "templates/page.tmpl"))
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		var files []string
+		err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"css/style.css", "index.html", "templates/page.tmpl"}, files)
+	})
+
+	t.Run("not exist", func(t *testing.T) {
+		_, err := fsys.Open("secret.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = fsys.Open("../other/secret.txt")
+		require.ErrorIs(t, err, fs.ErrInvalid)
+	})
+
+	t.Run("http file server", func(t *testing.T) {
+		srv := http.FileServer(fm.HTTPFileSystem(ctx, "site"))
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/css/style.css", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "body{}", rec.Body.String())
+	})
+
+	t.Run("internal objects", func(t *testing.T) {
+		_, err := fm.Upload(ctx, bytes.NewReader([]byte("state")), ".tus/upload.info", "application/json")
+		require.NoError(t, err)
+		_, err = fm.Upload(ctx, bytes.NewReader([]byte("pending")), "quarantine/site/new.html", "text/html")
+		require.NoError(t, err)
+
+		root := fm.FS(ctx, "")
+		entries, err := root.ReadDir(".")
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		require.Equal(t, []string{"other", "site"}, names)
+		_, err = root.Open(".tus/upload.info")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = root.Open("quarantine")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		rec := httptest.NewRecorder()
+		http.FileServer(fm.HTTPFileSystem(ctx, "")).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/quarantine/site/new.html", nil))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("open", func(t *testing.T) {
+		r, err := fm.Open(ctx, "site/index.html")
+		require.NoError(t, err)
+		defer r.Close()
+		_, err = r.Seek(4, io.SeekStart)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "index</h1>", string(data))
+	})
+
+	t.Run("list", func(t *testing.T) {
+		objects, err := fm.List(ctx, "site/")
+		require.NoError(t, err)
+		require.Len(t, objects, 3)
+		require.Equal(t, "site/css/style.css", objects[0].Key)
+	})
+}
@@ -0,0 +1,147 @@
+package filemanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMinCompressSize is the min size of a file to be compressed, smaller files rarely benefit from compression.
+const DefaultMinCompressSize = 1024
+
+// encodingsMetadataKey is the metadata key listing the content encodings of the stored compressed variants of a file.
+const encodingsMetadataKey = "encodings"
+
+// DefaultCompressibleTypes is the list of text content types compressed by default.
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+type (
+	// Compressor compresses files with a content encoding, e.g. gzip or brotli.
+	Compressor interface {
+		// Encoding returns the Content-Encoding token of the compressed content, e.g. "gzip" or "br".
+		Encoding() string
+		// NewWriter returns a writer compressing the data written to w.
+		// The writer is closed after all data is written.
+		NewWriter(w io.Writer) io.WriteCloser
+	}
+
+	// GzipCompressor compresses files with gzip.
+	GzipCompressor struct {
+		// Level is the gzip compression level. Defaults to gzip.BestCompression.
+		Level int
+	}
+
+	// compressorFunc is a Compressor defined by a function.
+	compressorFunc struct {
+		encoding  string
+		newWriter func(w io.Writer) io.WriteCloser
+	}
+)
+
+// NewCompressor returns a compressor for the content encoding, creating writers with the function.
+// It may be used to plug in compression libraries, e.g. brotli:
+//
+//	filemanager.NewCompressor("br", func(w io.Writer) io.WriteCloser {
+//		return brotli.NewWriterLevel(w, brotli.BestCompression)
+//	})
+func NewCompressor(encoding string, newWriter func(w io.Writer) io.WriteCloser) Compressor {
+	return compressorFunc{encoding: encoding, newWriter: newWriter}
+}
+
+// Encoding implements the Compressor interface.
+func (c compressorFunc) Encoding() string { return c.encoding }
+
+// NewWriter implements the Compressor interface.
+func (c compressorFunc) NewWriter(w io.Writer) io.WriteCloser { return c.newWriter(w) }
+
+// Encoding implements the Compressor interface.
+func (c GzipCompressor) Encoding() string { return "gzip" }
+
+// NewWriter implements the Compressor interface.
+func (c GzipCompressor) NewWriter(w io.Writer) io.WriteCloser {
+	level := c.Level
+	if level == 0 {
+		level = gzip.BestCompression
+	}
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		// invalid compression level
+		return gzip.NewWriter(w)
+	}
+	return zw
+}
+
+// compress compresses the data with the compressor.
+func compress(c Compressor, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := c.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressedVariantKey returns the object key of the compressed variant of a file,
+// e.g. "app.js.gz" for gzip and "app.js.br" for brotli.
+func compressedVariantKey(key, encoding string) string {
+	switch encoding {
+	case "gzip":
+		return key + ".gz"
+	case "zstd":
+		return key + ".zst"
+	default:
+		return key + "." + encoding
+	}
+}
+
+// negotiateEncoding returns the content encoding to respond with, according to the Accept-Encoding header,
+// or an empty string if none of the available encodings is acceptable.
+// The encodings with the highest quality value are preferred, ties are resolved by the order of the available encodings.
+func negotiateEncoding(acceptEncoding string, available []string) string {
+	if acceptEncoding == "" || len(available) == 0 {
+		return ""
+	}
+
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		token, params, _ := strings.Cut(part, ";")
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+		accepted[token] = q
+	}
+
+	var (
+		best  string
+		bestQ float64
+	)
+	for _, encoding := range available {
+		q, ok := accepted[encoding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,113 @@
+package filemanager
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// Content safety actions, see ContentSafetyPolicy.
+const (
+	// ContentAllow stores the file as is.
+	ContentAllow ContentSafetyAction = iota
+	// ContentForceAttachment stores the file with Content-Disposition: attachment,
+	// so browsers download it instead of rendering it.
+	ContentForceAttachment
+	// ContentNeutralType stores the file with the NeutralContentType, so browsers don't render or execute it.
+	ContentNeutralType
+	// ContentReject rejects the upload with ErrUnsafeContentType.
+	ContentReject
+)
+
+// NeutralContentType is the content type of files stored with ContentNeutralType.
+const NeutralContentType = "application/octet-stream"
+
+type (
+	// ContentSafetyAction defines how an uploaded file of a risky content type is stored.
+	ContentSafetyAction int
+
+	// ContentSafetyPolicy maps risky media types, e.g. "text/html", to the action applied to uploaded files.
+	// Media types with a structured syntax suffix, e.g. "application/rss+xml", which aren't listed
+	// fall back to the entry of the suffix, e.g. "+xml". Files of other types are stored as is,
+	// and files of invalid types are rejected, since they can't be matched against the policy.
+	//
+	// Since the uploaded files are public by default, an HTML page uploaded by a user
+	// would be served from the CDN domain, e.g. as a phishing page. The policy prevents that.
+	ContentSafetyPolicy map[string]ContentSafetyAction
+)
+
+// DefaultContentSafetyPolicy returns the recommended content safety policy:
+// HTML, XHTML and other XML documents are downloaded as attachments, since an XML document
+// in the XHTML namespace is rendered as a page, and JavaScript files are rejected,
+// since a script is executed by browsers regardless of the Content-Disposition and Content-Type.
+// SVG images are allowed, since they're sanitized on upload.
+func DefaultContentSafetyPolicy() ContentSafetyPolicy {
+	return ContentSafetyPolicy{
+		"text/html":                ContentForceAttachment,
+		"application/xhtml+xml":    ContentForceAttachment,
+		"text/xml":                 ContentForceAttachment,
+		"application/xml":          ContentForceAttachment,
+		"+xml":                     ContentForceAttachment,
+		"image/svg+xml":            ContentAllow,
+		"text/javascript":          ContentReject,
+		"application/javascript":   ContentReject,
+		"application/x-javascript": ContentReject,
+		"text/ecmascript":          ContentReject,
+		"application/ecmascript":   ContentReject,
+	}
+}
+
+// Action returns the action applied to files of the content type.
+func (p ContentSafetyPolicy) Action(contentType string) ContentSafetyAction {
+	if len(p) == 0 {
+		return ContentAllow
+	}
+	// the media type is returned along with invalid parameters
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil && !errors.Is(err, mime.ErrInvalidMediaParameter) {
+		return ContentReject
+	}
+	if action, ok := p[mediaType]; ok {
+		return action
+	}
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		return p[mediaType[i:]]
+	}
+	return ContentAllow
+}
+
+// apply applies the policy to an upload of the content type.
+// It returns the content type the file is stored with and the upload options.
+func (p ContentSafetyPolicy) apply(contentType string, opts []UploadOption) (string, []UploadOption, error) {
+	switch p.Action(contentType) {
+	case ContentForceAttachment:
+		return contentType, append(opts[:len(opts):len(opts)], WithObjectContentDisposition("attachment")), nil
+	case ContentNeutralType:
+		return NeutralContentType, opts, nil
+	case ContentReject:
+		return "", nil, fmt.Errorf("%w: %s", ErrUnsafeContentType, contentType)
+	default:
+		return contentType, opts, nil
+	}
+}
+
+// streamedContent applies the content safety policy to a file stored without being read by the file manager first,
+// e.g. streamed or uploaded directly by the client. Such SVG images can't be sanitized, so they're downloaded instead,
+// unless the policy defines how they're stored. Trusted files are stored as is.
+// It returns the content type and the upload options the file is stored with.
+func (fm *FileManager) streamedContent(contentType string, opts []UploadOption) (string, []UploadOption, error) {
+	if newUploadOptions(opts).trusted {
+		return contentType, opts, nil
+	}
+	if fm.contentSafety != nil {
+		var err error
+		if contentType, opts, err = fm.contentSafety.apply(contentType, opts); err != nil {
+			return "", nil, err
+		}
+	}
+	if isSVGImage(contentType) && fm.contentSafety.Action(contentType) == ContentAllow {
+		opts = append(opts[:len(opts):len(opts)], WithObjectContentDisposition("attachment"))
+	}
+	return contentType, opts, nil
+}
@@ -0,0 +1,192 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestContentSafetyPolicy(t *testing.T) {
+	policy := filemanager.DefaultContentSafetyPolicy()
+	policy["text/plain"] = filemanager.ContentNeutralType
+
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+		filemanager.WithContentSafetyPolicy(policy),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("action", func(t *testing.T) {
+		require.Equal(t, filemanager.ContentForceAttachment, policy.Action("text/html; charset=utf-8"))
+		require.Equal(t, filemanager.ContentReject, policy.Action("Application/JavaScript"))
+		require.Equal(t, filemanager.ContentAllow, policy.Action("image/png"))
+
+		// invalid parameters don't hide the media type, other invalid types are rejected
+		require.Equal(t, filemanager.ContentForceAttachment, policy.Action("text/html; x"))
+		require.Equal(t, filemanager.ContentForceAttachment, policy.Action("text/html;charset"))
+		require.Equal(t, filemanager.ContentReject, policy.Action("text/html,"))
+		require.Equal(t, filemanager.ContentReject, policy.Action(""))
+
+		// XML documents may be rendered as XHTML pages
+		require.Equal(t, filemanager.ContentForceAttachment, policy.Action("text/xml"))
+		require.Equal(t, filemanager.ContentForceAttachment, policy.Action("application/xml; charset=utf-8"))
+		require.Equal(t, filemanager.ContentForceAttachment, policy.Action("application/rss+xml"))
+		require.Equal(t, filemanager.ContentAllow, policy.Action("image/svg+xml"))
+	})
+
+	t.Run("attachment", func(t *testing.T) {
+		_, err := fm.Upload(ctx, strings.NewReader("<html></html>"), "page.html", "text/html; charset=utf-8")
+		require.NoError(t, err)
+		obj := s3Client.object("page.html")
+		require.Equal(t, "attachment", obj.contentDisposition)
+		require.Equal(t, "text/html; charset=utf-8", obj.contentType)
+	})
+
+	t.Run("xml", func(t *testing.T) {
+		page := `<html xmlns="http://www.w3.org/1999/xhtml"><script>alert(1)</script></html>`
+		_, err := fm.Upload(ctx, strings.NewReader(page), "page.xml", "application/xml")
+		require.NoError(t, err)
+		require.Equal(t, "attachment", s3Client.object("page.xml").contentDisposition)
+
+		_, err = fm.Upload(ctx, strings.NewReader(page), "invalid.html", "text/html,")
+		require.ErrorIs(t, err, filemanager.ErrUnsafeContentType)
+		require.Nil(t, s3Client.object("invalid.html"))
+	})
+
+	t.Run("neutral type", func(t *testing.T) {
+		result, err := fm.UploadImage(ctx, strings.NewReader("hello"), "notes.txt", "text/plain")
+		require.NoError(t, err)
+		require.Equal(t, filemanager.NeutralContentType, result.ContentType)
+		require.Equal(t, filemanager.NeutralContentType, s3Client.object("notes.txt").contentType)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		_, err := fm.Upload(ctx, strings.NewReader("alert(1)"), "app.js", "text/javascript")
+		require.ErrorIs(t, err, filemanager.ErrFailedToUploadFile)
+		require.ErrorIs(t, err, filemanager.ErrUnsafeContentType)
+		require.Nil(t, s3Client.object("app.js"))
+
+		_, err = fm.UploadStream(ctx, strings.NewReader("alert(1)"), "stream.js", "application/javascript")
+		require.ErrorIs(t, err, filemanager.ErrUnsafeContentType)
+		require.Nil(t, s3Client.object("stream.js"))
+	})
+
+	t.Run("svg", func(t *testing.T) {
+		policy := filemanager.ContentSafetyPolicy{"image/svg+xml": filemanager.ContentForceAttachment}
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithContentSafetyPolicy(policy),
+		)
+		require.NoError(t, err)
+
+		// the policy replaces the SVG sanitizing
+		_, err = fm.Upload(ctx, strings.NewReader(maliciousSVG), "logo.svg", "image/svg+xml")
+		require.NoError(t, err)
+		require.Equal(t, maliciousSVG, string(s3Client.object("logo.svg").data))
+		require.Equal(t, "attachment", s3Client.object("logo.svg").contentDisposition)
+	})
+
+	t.Run("trusted", func(t *testing.T) {
+		_, err := fm.Upload(ctx, strings.NewReader("alert(1)"), "trusted.js", "text/javascript", filemanager.WithTrustedContent())
+		require.NoError(t, err)
+		require.NotNil(t, s3Client.object("trusted.js"))
+
+		// published assets are trusted, compressed SVG variants are stored as is
+		icon := `<svg xmlns="http://www.w3.org/2000/svg">` + strings.Repeat(`<rect width="1" height="1"/>`, 100) + `</svg>`
+		_, err = fm.Publish(ctx, fstest.MapFS{
+			"index.html": {Data: []byte("<html></html>")},
+			"icon.svg":   {Data: []byte(icon)},
+		}, "site", filemanager.PublishOptions{
+			NoHash:   []string{"*"},
+			Compress: []filemanager.Compressor{filemanager.GzipCompressor{}},
+		})
+		require.NoError(t, err)
+		require.Empty(t, s3Client.object("site/index.html").contentDisposition)
+		require.Equal(t, icon, string(s3Client.object("site/icon.svg").data))
+		require.Equal(t, "gzip", s3Client.object("site/icon.svg.gz").contentEncoding)
+	})
+
+	t.Run("upload handler", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="file"; filename="app.js"`)
+		header.Set("Content-Type", "text/javascript")
+		part, err := writer.CreatePart(header)
+		require.NoError(t, err)
+		_, err = part.Write([]byte("alert(1)"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		filemanager.NewUploadHandler(fm).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+		require.Contains(t, rec.Body.String(), "unsafe_content_type")
+	})
+
+	t.Run("direct uploads", func(t *testing.T) {
+		tus := filemanager.NewTusHandler(fm, "/files/")
+		create := func(filetype string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/files/", nil)
+			req.Header.Set("Tus-Resumable", filemanager.TusVersion)
+			req.Header.Set("Upload-Length", "0")
+			req.Header.Set("Upload-Metadata", "filetype "+base64.StdEncoding.EncodeToString([]byte(filetype)))
+			rec := httptest.NewRecorder()
+			tus.ServeHTTP(rec, req)
+			return rec
+		}
+		require.Equal(t, http.StatusUnsupportedMediaType, create("text/javascript").Code)
+		for filetype, disposition := range map[string]string{"text/html": "attachment", "image/svg+xml": "attachment", "image/png": ""} {
+			rec := create(filetype)
+			require.Equal(t, http.StatusCreated, rec.Code)
+			key := strings.TrimPrefix(rec.Header().Get(filemanager.TusFileURLHeader), "https://cdn.example.com/uploads/")
+			require.Equal(t, disposition, s3Client.object(key).contentDisposition, filetype)
+		}
+
+		s3Multipart := filemanager.NewS3MultipartHandler(fm, "/s3/multipart", []byte("secret"))
+		req := httptest.NewRequest(http.MethodPost, "/s3/multipart", strings.NewReader(`{"filename":"app.js","type":"text/javascript"}`))
+		rec := httptest.NewRecorder()
+		s3Multipart.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+		req = httptest.NewRequest(http.MethodPost, "/s3/multipart", strings.NewReader(`{"filename":"notes.txt","type":"text/plain"}`))
+		rec = httptest.NewRecorder()
+		s3Multipart.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		uploads, err := fm.ListIncompleteUploads(ctx, "")
+		require.NoError(t, err)
+		require.Len(t, uploads, 1)
+		require.True(t, strings.HasSuffix(uploads[0].Key, ".txt"))
+
+		// the neutral type is stored once the upload is completed
+		_, err = s3Client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String("test-bucket"),
+			Key:             aws.String(uploads[0].Key),
+			UploadId:        aws.String(uploads[0].UploadID),
+			MultipartUpload: &s3.CompletedMultipartUpload{},
+		})
+		require.NoError(t, err)
+		require.Equal(t, filemanager.NeutralContentType, s3Client.object(uploads[0].Key).contentType)
+	})
+}
@@ -18,4 +18,29 @@ var (
 	ErrNotFound                            = errors.New("not found")
 	ErrUnexpected                          = errors.New("unexpected error")
 	ErrMissedHTTPClient                    = errors.New("missed HTTP client")
+	ErrInvalidPartSize                     = errors.New("invalid part size")
+	ErrFailedToListUploads                 = errors.New("failed to list incomplete uploads")
+	ErrFailedToAbortUploads                = errors.New("failed to abort incomplete uploads")
+	ErrFailedToListFiles                   = errors.New("failed to list files")
+	ErrFailedToGetFile                     = errors.New("failed to get file")
+	ErrFileTooLarge                        = errors.New("file is too large")
+	ErrUnsupportedContentType              = errors.New("unsupported content type")
+	ErrMissedFormField                     = errors.New("missed form field")
+	ErrUnauthorized                        = errors.New("unauthorized")
+	ErrForbidden                           = errors.New("forbidden")
+	ErrTooManyParts                        = errors.New("too many parts in multipart upload")
+	ErrInvalidSyncPath                     = errors.New("invalid sync path")
+	ErrFailedToSync                        = errors.New("failed to sync files")
+	ErrFailedToProcessImage                = errors.New("failed to process image")
+	ErrInvalidImageVariant                 = errors.New("invalid image variant")
+	ErrInvalidWatermark                    = errors.New("invalid watermark")
+	ErrImageTooLarge                       = errors.New("image is too large")
+	ErrInvalidSVG                          = errors.New("invalid SVG image")
+	ErrUnsafeContentType                   = errors.New("unsafe content type")
+	ErrInfected                            = errors.New("file is infected")
+	ErrFailedToScanFile                    = errors.New("failed to scan file")
+	ErrFailedToCopyFile                    = errors.New("failed to copy file")
+	ErrMissedScanner                       = errors.New("missed scanner")
+	ErrScanInProgress                      = errors.New("file is being scanned")
+	ErrRejectedContent                     = errors.New("file is rejected by the content checks")
 )
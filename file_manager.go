@@ -9,9 +9,12 @@ import (
 	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -26,6 +29,20 @@ const (
 	DefaultACL = "public-read"
 	// DefaultMaxFileSize - default max file size for multipart form upload 64MB
 	DefaultMaxFileSize = 64 << 20 // 64MB
+	// DefaultUploadConcurrency - default number of files uploaded in parallel
+	DefaultUploadConcurrency = 5
+	// DefaultPartSize - default part size for multipart uploads 16MB
+	DefaultPartSize = 16 << 20 // 16MB
+	// MinPartSize - min part size allowed by S3 for multipart uploads, except the last part
+	MinPartSize = 5 << 20 // 5MB
+	// MaxUploadParts - max number of parts allowed by S3 in a multipart upload
+	MaxUploadParts = 10000
+	// DefaultPartConcurrency - default number of parts uploaded in parallel
+	DefaultPartConcurrency = 4
+	// maxCopyObjectSize - max size of an object copied with a single request, larger objects are copied in parts
+	maxCopyObjectSize = 5 << 30 // 5GB
+	// copyPartSize - part size for multipart copies, 10000 parts cover the max object size of 5TB
+	copyPartSize = 512 << 20 // 512MB
 )
 
 type (
@@ -37,6 +54,27 @@ type (
 		bucket      string
 		basePath    string
 		maxFileSize int64
+		concurrency int
+		partSize    int64
+		partWorkers int
+
+		imageVariants     []ImageVariant
+		imageSanitizer    *ImageSanitizer
+		imageInfo         bool
+		imagePlaceholders bool
+		imageHashes       bool
+		imageHashIndex    ImageHashIndex
+		watermark         *Watermark
+		imageLimits       *ImageLimits
+		svgAttachment     bool
+		contentSafety     ContentSafetyPolicy
+		scanner           Scanner
+		scanFailOpen      bool
+
+		quarantinePrefix    string
+		onQuarantineScanned func(ctx context.Context, result *QuarantineResult)
+		quarantineScans     chan struct{} // limits the concurrent scans of quarantined files
+		quarantineInFlight  sync.Map      // filenames of the quarantined files being scanned
 	}
 
 	// Config represents a storage client config
@@ -64,6 +102,58 @@ type (
 
 		// MaxFileSize is the maximum allowed file size for the S3 client.
 		MaxFileSize int64
+
+		// UploadConcurrency is the maximum number of files uploaded in parallel.
+		UploadConcurrency int
+
+		// PartSize is the part size for multipart uploads.
+		PartSize int64
+
+		// PartConcurrency is the maximum number of parts uploaded in parallel.
+		PartConcurrency int
+
+		// ImageVariants are the resized copies generated for uploaded images, e.g. thumbnails.
+		ImageVariants []ImageVariant
+
+		// ImageSanitizer cleans uploaded images before they are stored, if set.
+		ImageSanitizer *ImageSanitizer
+
+		// ImageInfo enables storing the dimensions, format, frame count and color model of uploaded images.
+		ImageInfo bool
+
+		// ImagePlaceholders enables generating a BlurHash, a tiny preview and the dominant color of uploaded images.
+		ImagePlaceholders bool
+
+		// ImageHashes enables computing the perceptual hashes of uploaded images.
+		ImageHashes bool
+
+		// ImageHashIndex stores the perceptual hashes of uploaded images for similarity lookups, if set.
+		ImageHashIndex ImageHashIndex
+
+		// Watermark is overlaid onto uploaded JPEG and PNG images, if set.
+		Watermark *Watermark
+
+		// ImageLimits rejects uploaded images exceeding the limits before they're decoded, if set.
+		ImageLimits *ImageLimits
+
+		// SVGAttachment stores uploaded SVG images with Content-Disposition: attachment instead of sanitizing them.
+		SVGAttachment bool
+
+		// ContentSafety is the policy applied to uploaded files of risky content types, if set.
+		ContentSafety ContentSafetyPolicy
+
+		// Scanner scans uploaded files for malware before they're stored, if set.
+		Scanner Scanner
+
+		// ScanFailOpen accepts uploaded files unscanned if the scanner fails. By default, such uploads fail.
+		ScanFailOpen bool
+
+		// QuarantinePrefix is the prefix of the keys files uploaded with Quarantine are stored under until they're scanned.
+		// Defaults to DefaultQuarantinePrefix.
+		QuarantinePrefix string
+
+		// OnQuarantineScanned is called with the result of a quarantined file scan, if set.
+		OnQuarantineScanned func(ctx context.Context, result *QuarantineResult)
 	}
 
 	// S3Client S3-compatible storage client interface
@@ -84,6 +174,78 @@ type (
 			input *s3.DeleteObjectInput,
 			opts ...request.Option,
 		) (*s3.DeleteObjectOutput, error)
+		CreateMultipartUploadWithContext(
+			ctx aws.Context,
+			input *s3.CreateMultipartUploadInput,
+			opts ...request.Option,
+		) (*s3.CreateMultipartUploadOutput, error)
+		UploadPartWithContext(ctx aws.Context, input *s3.UploadPartInput, opts ...request.Option) (
+			*s3.UploadPartOutput, error,
+		)
+		CompleteMultipartUploadWithContext(
+			ctx aws.Context,
+			input *s3.CompleteMultipartUploadInput,
+			opts ...request.Option,
+		) (*s3.CompleteMultipartUploadOutput, error)
+		AbortMultipartUploadWithContext(
+			ctx aws.Context,
+			input *s3.AbortMultipartUploadInput,
+			opts ...request.Option,
+		) (*s3.AbortMultipartUploadOutput, error)
+		ListMultipartUploadsWithContext(
+			ctx aws.Context,
+			input *s3.ListMultipartUploadsInput,
+			opts ...request.Option,
+		) (*s3.ListMultipartUploadsOutput, error)
+		ListPartsWithContext(ctx aws.Context, input *s3.ListPartsInput, opts ...request.Option) (
+			*s3.ListPartsOutput, error,
+		)
+		GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (
+			*s3.GetObjectOutput, error,
+		)
+		UploadPartRequest(input *s3.UploadPartInput) (req *request.Request, output *s3.UploadPartOutput)
+		CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (
+			*s3.CopyObjectOutput, error,
+		)
+		UploadPartCopyWithContext(ctx aws.Context, input *s3.UploadPartCopyInput, opts ...request.Option) (
+			*s3.UploadPartCopyOutput, error,
+		)
+	}
+
+	// UploadResult represents the result of a single file upload from a multipart form.
+	UploadResult struct {
+		// FieldName is the name of the form field the file was sent in.
+		FieldName string
+		// OriginalName is the file name provided by the client.
+		OriginalName string
+		// Key is the object key the file was stored under.
+		Key string
+		// URL is the public URL of the uploaded file.
+		URL string
+		// Size is the file size in bytes.
+		Size int64
+		// ContentType is the content type of the file.
+		ContentType string
+		// OriginalKey is the key the original image was kept under with the private ACL, if it was watermarked.
+		OriginalKey string
+		// Variants contains the URLs of the generated image variants by variant names, if any.
+		Variants map[string]string
+		// Image contains the attributes of the uploaded image, if the image info is enabled.
+		Image *ImageInfo
+		// Placeholder contains the placeholders of the uploaded image, if the placeholders are enabled.
+		Placeholder *ImagePlaceholder
+		// Hash contains the perceptual hashes of the uploaded image, if the image hashes are enabled.
+		Hash *ImageHash
+		// Error is set if the file could not be uploaded.
+		Error error
+	}
+
+	// MultipartFormResult represents the result of uploading all files from a multipart form.
+	MultipartFormResult struct {
+		// Files contains the upload results, ordered by field name and then by position in the form.
+		Files []UploadResult
+		// Values contains the non-file form values.
+		Values map[string][]string
 	}
 
 	// httpClient interface
@@ -117,6 +279,23 @@ func New(cnf Config) (*FileManager, error) {
 		WithCDNURL(cnf.CDNURL),
 		WithBasePath(cnf.BasePath),
 		WithMaxFileSize(cnf.MaxFileSize),
+		WithUploadConcurrency(cnf.UploadConcurrency),
+		WithPartSize(cnf.PartSize),
+		WithPartConcurrency(cnf.PartConcurrency),
+		WithImageVariants(cnf.ImageVariants...),
+		WithImageSanitizer(cnf.ImageSanitizer),
+		WithImageInfo(cnf.ImageInfo),
+		WithImagePlaceholders(cnf.ImagePlaceholders),
+		WithImageHashes(cnf.ImageHashes),
+		WithImageHashIndex(cnf.ImageHashIndex),
+		WithWatermark(cnf.Watermark),
+		WithImageLimits(cnf.ImageLimits),
+		WithSVGAttachment(cnf.SVGAttachment),
+		WithContentSafetyPolicy(cnf.ContentSafety),
+		WithScanner(cnf.Scanner),
+		WithScanFailOpen(cnf.ScanFailOpen),
+		WithQuarantinePrefix(cnf.QuarantinePrefix),
+		WithQuarantineCallback(cnf.OnQuarantineScanned),
 	)
 }
 
@@ -127,9 +306,13 @@ func New(cnf Config) (*FileManager, error) {
 func NewWithOptions(opt ...Option) (*FileManager, error) {
 	// create new file manager
 	fm := &FileManager{
-		httpClient:  http.DefaultClient,
-		maxFileSize: DefaultMaxFileSize, // 64MB
-		basePath:    "uploads",
+		httpClient:       http.DefaultClient,
+		maxFileSize:      DefaultMaxFileSize, // 64MB
+		basePath:         "uploads",
+		concurrency:      DefaultUploadConcurrency,
+		partSize:         DefaultPartSize,
+		partWorkers:      DefaultPartConcurrency,
+		quarantinePrefix: DefaultQuarantinePrefix,
 	}
 
 	// apply options
@@ -149,20 +332,197 @@ func NewWithOptions(opt ...Option) (*FileManager, error) {
 	if fm.cdnURL == "" {
 		return nil, errors.Join(ErrInvalidS3ClientConfig, ErrMissedCDNURL)
 	}
+	fm.quarantineScans = make(chan struct{}, fm.concurrency)
 
 	return fm, nil
 }
 
 // Upload uploads a file to the S3 bucket.
 // It takes the file content as a byte slice, the filename, and the content type as input parameters.
+// Upload options may be used to set additional attributes of the uploaded file.
+// If image processing is configured, JPEG, PNG and GIF images are sanitized and their variants are generated,
+// see UploadImage.
 // It returns the URL of the uploaded file and any error encountered during the upload process.
-func (fm *FileManager) Upload(ctx context.Context, file io.ReadSeeker, filename, contentType string) (string, error) {
+func (fm *FileManager) Upload(ctx context.Context, file io.ReadSeeker, filename, contentType string, opts ...UploadOption) (string, error) {
+	result, err := fm.upload(ctx, file, filename, contentType, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return result.URL, nil
+}
+
+// UploadImage uploads a file to the S3 bucket like Upload, but returns the detailed upload result.
+// JPEG and PNG images are cleaned by the image sanitizer, if it's configured.
+// The configured image variants of JPEG, PNG and GIF images are generated and stored next to the original image,
+// see ImageVariantKey, and their URLs are returned in the result.
+// Other files are uploaded as is.
+func (fm *FileManager) UploadImage(ctx context.Context, file io.ReadSeeker, filename, contentType string, opts ...UploadOption) (*UploadResult, error) {
+	return fm.upload(ctx, file, filename, contentType, opts)
+}
+
+// upload uploads a file to the S3 bucket, processing images.
+func (fm *FileManager) upload(ctx context.Context, file io.ReadSeeker, filename, contentType string, opts []UploadOption) (*UploadResult, error) {
+	o := newUploadOptions(opts)
+	if !o.trusted && fm.contentSafety != nil {
+		var err error
+		if contentType, opts, err = fm.contentSafety.apply(contentType, opts); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+	}
+	// files are scanned as uploaded, before they're processed
+	if fm.scanner != nil && !o.trusted && !o.noScan {
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+		if err := fm.scan(ctx, file, filename); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+	}
+	result := &UploadResult{Key: filename, ContentType: contentType}
+
+	// SVG images can run scripts when opened from the CDN, so they're sanitized or downloaded instead,
+	// unless the content safety policy defines how they're stored
+	sanitizeSVG := !o.trusted && isSVGImage(contentType) && fm.contentSafety.Action(contentType) == ContentAllow
+	if sanitizeSVG && fm.svgAttachment {
+		opts = append(opts[:len(opts):len(opts)], WithObjectContentDisposition("attachment"))
+		sanitizeSVG = false
+	}
+
+	// encoded files, e.g. compressed, can't be processed, and trusted files are stored byte for byte,
+	// so their checksums and content-hashed keys match the stored objects
+	if o.trusted || o.contentEncoding != "" || !sanitizeSVG && !fm.processesImage(contentType) {
+		size, err := readSeekerSize(file)
+		if err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+		result.Size = size
+		if result.URL, err = fm.putObject(ctx, file, filename, contentType, opts...); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToUploadFile, err)
+	}
+
+	if sanitizeSVG {
+		if data, err = SanitizeSVG(data); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+	}
+
+	// check the image header before the image is decoded by any processing step
+	if fm.imageLimits != nil && isDecodableImage(contentType) {
+		if err := fm.imageLimits.Check(data); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+	}
+
+	// sanitize the image before it's stored anywhere
+	if fm.imageSanitizer != nil && isProcessableImage(contentType) {
+		if data, err = fm.imageSanitizer.Sanitize(data, contentType); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+	}
+	// watermark the image before the variants are generated, so they're watermarked as well
+	if fm.watermark != nil && isWatermarkableImage(contentType) {
+		original := data
+		if data, err = fm.watermark.Apply(data, contentType); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+		if key := fm.watermark.originalKey(filename); key != "" {
+			originalOpts := append(opts[:len(opts):len(opts)], WithObjectACL("private"))
+			if _, err := fm.putObject(ctx, bytes.NewReader(original), key, contentType, originalOpts...); err != nil {
+				return nil, err
+			}
+			result.OriginalKey = key
+		}
+	}
+	result.Size = int64(len(data))
+
+	if fm.decodesImage(contentType) {
+		img, format, err := decodeOrientedImage(data)
+		if err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+
+		// variants are uploaded before the original image, so it never references missing variants
+		if len(fm.imageVariants) > 0 && isProcessableImage(contentType) {
+			if result.Variants, err = fm.uploadImageVariants(ctx, img, format, filename, contentType, opts); err != nil {
+				return nil, errors.Join(ErrFailedToUploadFile, err)
+			}
+		}
+		if fm.imagePlaceholders {
+			if result.Placeholder, err = newImagePlaceholder(img); err != nil {
+				return nil, errors.Join(ErrFailedToUploadFile, err)
+			}
+			opts = append(opts[:len(opts):len(opts)], WithObjectMetadata(result.Placeholder.metadata()))
+		}
+		if fm.imageHashes {
+			result.Hash = newImageHash(img)
+			opts = append(opts[:len(opts):len(opts)], WithObjectMetadata(result.Hash.metadata()))
+		}
+	}
+	if fm.imageInfo && isDecodableImage(contentType) {
+		if result.Image, err = readImageInfo(data); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+		opts = append(opts[:len(opts):len(opts)], WithObjectMetadata(result.Image.metadata()))
+	}
+	if result.URL, err = fm.putObject(ctx, bytes.NewReader(data), filename, contentType, opts...); err != nil {
+		return nil, err
+	}
+
+	// the hash is indexed after the image is stored, so the index never references missing images
+	if result.Hash != nil && fm.imageHashIndex != nil {
+		if err := fm.imageHashIndex.Add(ctx, filename, *result.Hash); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+	}
+
+	return result, nil
+}
+
+// processesImage checks if uploaded files of the content type are processed before they are stored.
+func (fm *FileManager) processesImage(contentType string) bool {
+	if (fm.imageInfo || fm.imageLimits != nil) && isDecodableImage(contentType) {
+		return true
+	}
+	if fm.watermark != nil && isWatermarkableImage(contentType) {
+		return true
+	}
+	return fm.decodesImage(contentType) || (fm.imageSanitizer != nil && isProcessableImage(contentType))
+}
+
+// decodesImage checks if uploaded images of the content type are decoded for processing.
+func (fm *FileManager) decodesImage(contentType string) bool {
+	if (fm.imagePlaceholders || fm.imageHashes) && isDecodableImage(contentType) {
+		return true
+	}
+	return len(fm.imageVariants) > 0 && isProcessableImage(contentType)
+}
+
+// putObject uploads the file content to the S3 bucket as is.
+// It returns the URL of the uploaded file.
+func (fm *FileManager) putObject(ctx context.Context, file io.ReadSeeker, filename, contentType string, opts ...UploadOption) (string, error) {
+	o := newUploadOptions(opts)
 	_, err := fm.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
-		ACL:         aws.String(DefaultACL),
-		Body:        file,
-		ContentType: aws.String(contentType),
-		Bucket:      aws.String(fm.bucket),
-		Key:         aws.String(filename),
+		ACL:                aws.String(o.acl),
+		Body:               file,
+		CacheControl:       stringOrNil(o.cacheControl),
+		ContentDisposition: stringOrNil(o.contentDisposition),
+		ContentEncoding:    stringOrNil(o.contentEncoding),
+		ContentType:        aws.String(contentType),
+		Bucket:             aws.String(fm.bucket),
+		Key:                aws.String(filename),
+		Metadata:           metadataOrNil(o.metadata),
 	})
 	if err != nil {
 		return "", errors.Join(ErrFailedToUploadFile, err)
@@ -214,6 +574,117 @@ func (fm *FileManager) UploadFromMultipartForm(r *http.Request, fieldName string
 	return result, nil
 }
 
+// UploadAllFromMultipartForm uploads all files from a multipart form to the S3 bucket.
+// It parses the multipart form and uploads every file of the given fields in parallel,
+// limited by the configured upload concurrency.
+// If no field names are provided, files from all fields are uploaded.
+//
+// Parameters:
+// - r: The HTTP request containing the multipart form data.
+// - fieldNames: The names of the fields in the multipart form that contain files.
+//
+// Returns:
+// - *MultipartFormResult: The per-file upload results and the non-file form values.
+// - error: An error if the form could not be parsed or the request contains no files.
+// Errors of individual uploads are reported in the UploadResult.Error field.
+func (fm *FileManager) UploadAllFromMultipartForm(r *http.Request, fieldNames ...string) (*MultipartFormResult, error) {
+	// Parse the multipart form
+	if err := r.ParseMultipartForm(fm.maxFileSize); err != nil {
+		return nil, errors.Join(ErrFailedToUploadFileFromMultipartForm, err)
+	}
+	if r.MultipartForm == nil || len(r.MultipartForm.File) == 0 {
+		return nil, errors.Join(ErrFailedToUploadFileFromMultipartForm, http.ErrMissingFile)
+	}
+
+	// Use all file fields if none are specified
+	if len(fieldNames) == 0 {
+		for name := range r.MultipartForm.File {
+			fieldNames = append(fieldNames, name)
+		}
+		sort.Strings(fieldNames)
+	}
+
+	// Collect the files to upload
+	var headers []*multipart.FileHeader
+	result := &MultipartFormResult{Values: r.MultipartForm.Value}
+	for _, name := range fieldNames {
+		for _, header := range r.MultipartForm.File[name] {
+			headers = append(headers, header)
+			result.Files = append(result.Files, UploadResult{
+				FieldName:    name,
+				OriginalName: header.Filename,
+				Key:          filepath.Base(header.Filename),
+				Size:         header.Size,
+				ContentType:  header.Header.Get("Content-Type"),
+			})
+		}
+	}
+	if len(headers) == 0 {
+		return nil, errors.Join(ErrFailedToUploadFileFromMultipartForm, http.ErrMissingFile)
+	}
+
+	// Upload files in parallel
+	eg := errgroup.Group{}
+	eg.SetLimit(fm.concurrency)
+	for i, header := range headers {
+		eg.Go(func() error {
+			res := &result.Files[i]
+			upload, err := fm.uploadMultipartFile(r.Context(), header, res.Key, res.ContentType)
+			if err != nil {
+				res.Error = err
+				return nil
+			}
+			res.URL, res.OriginalKey, res.Variants = upload.URL, upload.OriginalKey, upload.Variants
+			res.ContentType = upload.ContentType
+			res.Image, res.Placeholder, res.Hash = upload.Image, upload.Placeholder, upload.Hash
+			return nil
+		})
+	}
+	_ = eg.Wait() // errors are reported per file
+
+	return result, nil
+}
+
+// uploadMultipartFile opens a file from the multipart form and uploads it to the S3 bucket.
+func (fm *FileManager) uploadMultipartFile(ctx context.Context, header *multipart.FileHeader, key, contentType string) (*UploadResult, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, errors.Join(ErrFailedToUploadFileFromMultipartForm, err)
+	}
+	defer func(file multipart.File) {
+		if err := file.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close file", "error", err)
+		}
+	}(file)
+
+	result, err := fm.upload(ctx, file, key, contentType, nil)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToUploadFileFromMultipartForm, err)
+	}
+
+	return result, nil
+}
+
+// quarantineMultipartFile opens a file from the multipart form and uploads it to the quarantine.
+func (fm *FileManager) quarantineMultipartFile(ctx context.Context, header *multipart.FileHeader, key, contentType string) (*QuarantineResult, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, errors.Join(ErrFailedToUploadFileFromMultipartForm, err)
+	}
+	defer func(file multipart.File) {
+		if err := file.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close file", "error", err)
+		}
+	}(file)
+
+	result, err := fm.Quarantine(ctx, file, key, contentType)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToUploadFileFromMultipartForm, err)
+	}
+
+	return result, nil
+}
+
 // UploadFromURL uploads a file from a URL to the S3 bucket.
 // It takes the URL of the file as input and returns the URL of the uploaded file and any error encountered during the upload process.
 func (fm *FileManager) UploadFromURL(ctx context.Context, fileURL string) (string, error) {
@@ -252,7 +723,31 @@ func (fm *FileManager) UploadFromURL(ctx context.Context, fileURL string) (strin
 // The fileURL is the URL of the file to be removed.
 func (fm *FileManager) Remove(ctx context.Context, fileURL string) error {
 	// remove file from storage
-	return fm.remove(ctx, filenameFromURL(fm.cdnURL, fileURL))
+	return fm.remove(ctx, filenameFromURL(fm.fileAbsolutePath(""), fileURL))
+}
+
+// Copy copies a file within the S3 bucket and returns the URL of the copy.
+// The copy keeps the content type, metadata and other attributes of the source file.
+// The ACL is not copied: it's set with the WithObjectACL upload option and defaults to DefaultACL,
+// other upload options are ignored. Files larger than 5GB can't be copied.
+// It returns ErrNotFound if the source file does not exist.
+func (fm *FileManager) Copy(ctx context.Context, srcKey, dstKey string, opts ...UploadOption) (string, error) {
+	o := newUploadOptions(opts)
+	source := &url.URL{Path: fm.bucket + "/" + srcKey}
+	_, err := fm.s3.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		ACL:               aws.String(o.acl),
+		Bucket:            aws.String(fm.bucket),
+		CopySource:        aws.String(source.EscapedPath()),
+		Key:               aws.String(dstKey),
+		MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+	})
+	if err := handleS3Error(err); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", ErrNotFound
+		}
+		return "", errors.Join(ErrFailedToCopyFile, err)
+	}
+	return fm.fileAbsolutePath(dstKey), nil
 }
 
 // RemoveFilesFromDirectory removes all files from the specified directory in the storage.
@@ -310,6 +805,13 @@ func (fm *FileManager) remove(ctx context.Context, key string) error {
 		return errors.Join(ErrFailedToRemoveFile, err)
 	}
 
+	// remove the perceptual hash of the image, so similarity lookups don't return removed files
+	if fm.imageHashIndex != nil {
+		if err := fm.imageHashIndex.Remove(ctx, key); err != nil {
+			return errors.Join(ErrFailedToRemoveFile, err)
+		}
+	}
+
 	return nil
 }
 
@@ -330,6 +832,62 @@ func (fm *FileManager) fileExists(ctx context.Context, filepath string) (bool, e
 	return true, nil
 }
 
+// getObject reads a whole file from the S3 bucket.
+// It returns ErrNotFound if the file does not exist.
+func (fm *FileManager) getObject(ctx context.Context, key string) ([]byte, error) {
+	resp, err := fm.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(fm.bucket),
+		Key:    aws.String(key),
+	})
+	if err := handleS3Error(err); err != nil {
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		if err := Body.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close object body", "error", err)
+		}
+	}(resp.Body)
+
+	return io.ReadAll(resp.Body)
+}
+
+// putPrivateObject stores a file in the S3 bucket that is not publicly accessible.
+// It's used for internal files, such as upload state.
+func (fm *FileManager) putPrivateObject(ctx context.Context, key string, data []byte, contentType string) error {
+	if _, err := fm.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		ACL:         aws.String(s3.ObjectCannedACLPrivate),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+		Bucket:      aws.String(fm.bucket),
+		Key:         aws.String(key),
+	}); err != nil {
+		return errors.Join(ErrFailedToUploadFile, err)
+	}
+	return nil
+}
+
+// objectKey returns the object key of a file.
+// Files are stored under their names, the base path is the prefix of their URLs only, see fileAbsolutePath.
+func (fm *FileManager) objectKey(filename string) string {
+	return strings.Trim(filename, "/")
+}
+
+// isInternalKey checks if the key is an object the file manager keeps for itself:
+// a quarantined file, an original of a watermarked image or a tus upload state.
+func (fm *FileManager) isInternalKey(key string) bool {
+	prefixes := []string{fm.quarantinePrefix, tusStatePrefix}
+	if fm.watermark != nil && fm.watermark.OriginalPrefix != "" {
+		prefixes = append(prefixes, strings.Trim(fm.watermark.OriginalPrefix, "/"))
+	}
+	key = strings.TrimLeft(key, "/")
+	for _, prefix := range prefixes {
+		if key == prefix || strings.HasPrefix(key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // fileAbsolutePath returns the absolute path of a file in the S3 bucket.
 // It takes the filename as input and returns the absolute path of the file.
 func (fm *FileManager) fileAbsolutePath(filename string) string {
@@ -1,6 +1,9 @@
 package filemanager
 
-import "strings"
+import (
+	"context"
+	"strings"
+)
 
 // WithS3Client sets the S3 client.
 func WithS3Client(client S3Client) Option {
@@ -67,3 +70,184 @@ func WithMaxFileSize(maxFileSize int64) Option {
 		return nil
 	}
 }
+
+// WithUploadConcurrency sets the max number of files uploaded in parallel.
+func WithUploadConcurrency(n int) Option {
+	return func(f *FileManager) error {
+		if n <= 0 {
+			n = DefaultUploadConcurrency
+		}
+		f.concurrency = n
+		return nil
+	}
+}
+
+// WithPartSize sets the part size for multipart uploads.
+// The part size can't be less than MinPartSize.
+func WithPartSize(partSize int64) Option {
+	return func(f *FileManager) error {
+		if partSize <= 0 {
+			partSize = DefaultPartSize
+		}
+		if partSize < MinPartSize {
+			return ErrInvalidPartSize
+		}
+		f.partSize = partSize
+		return nil
+	}
+}
+
+// WithPartConcurrency sets the max number of parts uploaded in parallel.
+func WithPartConcurrency(n int) Option {
+	return func(f *FileManager) error {
+		if n <= 0 {
+			n = DefaultPartConcurrency
+		}
+		f.partWorkers = n
+		return nil
+	}
+}
+
+// WithImageVariants sets the image variants generated for uploaded images, e.g. thumbnails.
+func WithImageVariants(variants ...ImageVariant) Option {
+	return func(f *FileManager) error {
+		for _, v := range variants {
+			if err := v.validate(); err != nil {
+				return err
+			}
+		}
+		f.imageVariants = variants
+		return nil
+	}
+}
+
+// WithImageSanitizer sets the sanitizer cleaning uploaded images before they are stored.
+// A nil sanitizer disables sanitizing.
+func WithImageSanitizer(sanitizer *ImageSanitizer) Option {
+	return func(f *FileManager) error {
+		f.imageSanitizer = sanitizer
+		return nil
+	}
+}
+
+// WithImageInfo enables reading the dimensions, format, frame count and color model of uploaded images.
+// The image info is stored as object metadata and returned in the upload result and by Stat.
+func WithImageInfo(enabled bool) Option {
+	return func(f *FileManager) error {
+		f.imageInfo = enabled
+		return nil
+	}
+}
+
+// WithImagePlaceholders enables generating a BlurHash, a tiny base64 preview and the dominant color of uploaded images.
+// The placeholders are stored as object metadata and returned in the upload result and by Stat.
+func WithImagePlaceholders(enabled bool) Option {
+	return func(f *FileManager) error {
+		f.imagePlaceholders = enabled
+		return nil
+	}
+}
+
+// WithImageHashes enables computing the aHash, dHash and pHash perceptual hashes of uploaded images.
+// The hashes are stored as object metadata and returned in the upload result and by Stat.
+func WithImageHashes(enabled bool) Option {
+	return func(f *FileManager) error {
+		f.imageHashes = enabled
+		return nil
+	}
+}
+
+// WithImageHashIndex sets the index the perceptual hashes of uploaded images are added to,
+// and enables computing the hashes. Removed files are removed from the index.
+// A nil index disables indexing.
+func WithImageHashIndex(index ImageHashIndex) Option {
+	return func(f *FileManager) error {
+		f.imageHashIndex = index
+		if index != nil {
+			f.imageHashes = true
+		}
+		return nil
+	}
+}
+
+// WithWatermark sets the watermark overlaid onto uploaded JPEG and PNG images.
+// A nil watermark disables watermarking.
+func WithWatermark(watermark *Watermark) Option {
+	return func(f *FileManager) error {
+		if watermark != nil {
+			if err := watermark.validate(); err != nil {
+				return err
+			}
+		}
+		f.watermark = watermark
+		return nil
+	}
+}
+
+// WithImageLimits sets the limits uploaded images are checked against before they're decoded,
+// e.g. DefaultImageLimits. Images exceeding the limits are rejected with an *ImageLimitError.
+// A nil value disables the check.
+func WithImageLimits(limits *ImageLimits) Option {
+	return func(f *FileManager) error {
+		f.imageLimits = limits
+		return nil
+	}
+}
+
+// WithSVGAttachment makes uploaded SVG images be stored with Content-Disposition: attachment, as they are,
+// so browsers download them instead of rendering them. By default, SVG images are sanitized with SanitizeSVG.
+func WithSVGAttachment(enabled bool) Option {
+	return func(f *FileManager) error {
+		f.svgAttachment = enabled
+		return nil
+	}
+}
+
+// WithContentSafetyPolicy sets the policy applied to uploaded files of risky content types,
+// e.g. DefaultContentSafetyPolicy(). Files uploaded with WithTrustedContent are not affected.
+// A nil policy disables it.
+func WithContentSafetyPolicy(policy ContentSafetyPolicy) Option {
+	return func(f *FileManager) error {
+		f.contentSafety = policy
+		return nil
+	}
+}
+
+// WithScanner sets the scanner uploaded files are scanned for malware with before they're stored,
+// e.g. ClamAVScanner. Infected files are rejected with an error wrapping ErrInfected.
+// Files uploaded with WithTrustedContent are not scanned.
+func WithScanner(scanner Scanner) Option {
+	return func(f *FileManager) error {
+		f.scanner = scanner
+		return nil
+	}
+}
+
+// WithScanFailOpen sets whether uploaded files are accepted unscanned if the scanner fails, e.g. clamd is unavailable.
+// By default, the scanner fails closed: such uploads fail with an error wrapping ErrFailedToScanFile.
+func WithScanFailOpen(failOpen bool) Option {
+	return func(f *FileManager) error {
+		f.scanFailOpen = failOpen
+		return nil
+	}
+}
+
+// WithQuarantinePrefix sets the prefix of the keys files uploaded with Quarantine are stored under until they're scanned.
+// Defaults to DefaultQuarantinePrefix.
+func WithQuarantinePrefix(prefix string) Option {
+	return func(f *FileManager) error {
+		if prefix = strings.Trim(prefix, "/"); prefix != "" {
+			f.quarantinePrefix = prefix
+		}
+		return nil
+	}
+}
+
+// WithQuarantineCallback sets the function called with the result of a quarantined file scan,
+// e.g. to notify the user whether the file is published.
+func WithQuarantineCallback(fn func(ctx context.Context, result *QuarantineResult)) Option {
+	return func(f *FileManager) error {
+		f.onQuarantineScanned = fn
+		return nil
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/stretchr/testify/mock"
@@ -82,6 +83,119 @@ func (m *mockS3Client) DeleteObjectWithContext(
 	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
 }
 
+func (m *mockS3Client) CreateMultipartUploadWithContext(
+	ctx aws.Context,
+	input *s3.CreateMultipartUploadInput,
+	opts ...request.Option,
+) (*s3.CreateMultipartUploadOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.CreateMultipartUploadOutput), args.Error(1)
+}
+
+func (m *mockS3Client) UploadPartWithContext(
+	ctx aws.Context,
+	input *s3.UploadPartInput,
+	opts ...request.Option,
+) (*s3.UploadPartOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.UploadPartOutput), args.Error(1)
+}
+
+func (m *mockS3Client) CompleteMultipartUploadWithContext(
+	ctx aws.Context,
+	input *s3.CompleteMultipartUploadInput,
+	opts ...request.Option,
+) (*s3.CompleteMultipartUploadOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.CompleteMultipartUploadOutput), args.Error(1)
+}
+
+func (m *mockS3Client) AbortMultipartUploadWithContext(
+	ctx aws.Context,
+	input *s3.AbortMultipartUploadInput,
+	opts ...request.Option,
+) (*s3.AbortMultipartUploadOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.AbortMultipartUploadOutput), args.Error(1)
+}
+
+func (m *mockS3Client) ListMultipartUploadsWithContext(
+	ctx aws.Context,
+	input *s3.ListMultipartUploadsInput,
+	opts ...request.Option,
+) (*s3.ListMultipartUploadsOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.ListMultipartUploadsOutput), args.Error(1)
+}
+
+func (m *mockS3Client) ListPartsWithContext(
+	ctx aws.Context,
+	input *s3.ListPartsInput,
+	opts ...request.Option,
+) (*s3.ListPartsOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.ListPartsOutput), args.Error(1)
+}
+
+func (m *mockS3Client) GetObjectWithContext(
+	ctx aws.Context,
+	input *s3.GetObjectInput,
+	opts ...request.Option,
+) (*s3.GetObjectOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
+}
+
+func (m *mockS3Client) CopyObjectWithContext(
+	ctx aws.Context,
+	input *s3.CopyObjectInput,
+	opts ...request.Option,
+) (*s3.CopyObjectOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.CopyObjectOutput), args.Error(1)
+}
+
+func (m *mockS3Client) UploadPartCopyWithContext(
+	ctx aws.Context,
+	input *s3.UploadPartCopyInput,
+	opts ...request.Option,
+) (*s3.UploadPartCopyOutput, error) {
+	args := m.Called(ctx, input, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*s3.UploadPartCopyOutput), args.Error(1)
+}
+
+func (m *mockS3Client) UploadPartRequest(input *s3.UploadPartInput) (*request.Request, *s3.UploadPartOutput) {
+	args := m.Called(input)
+	return args.Get(0).(*request.Request), args.Get(1).(*s3.UploadPartOutput)
+}
+
 func TestUpload(t *testing.T) {
 	fileContent := bytes.NewReader([]byte("test content"))
 	filename := "testfile.txt"
@@ -119,6 +233,63 @@ func TestUpload(t *testing.T) {
 	mockS3.AssertExpectations(t)
 }
 
+func TestCopy(t *testing.T) {
+	bucket := "test-bucket"
+
+	mockS3 := new(mockS3Client)
+	mockS3.On("CopyObjectWithContext", mock.Anything, &s3.CopyObjectInput{
+		ACL:               aws.String("private"),
+		Bucket:            aws.String(bucket),
+		CopySource:        aws.String("test-bucket/drafts/my%20file.txt"),
+		Key:               aws.String("files/my file.txt"),
+		MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+	}, mock.Anything).Return(&s3.CopyObjectOutput{}, nil)
+	mockS3.On("CopyObjectWithContext", mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil))
+
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(mockS3),
+		filemanager.WithBucketName(bucket),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	url, err := fm.Copy(context.Background(), "drafts/my file.txt", "files/my file.txt", filemanager.WithObjectACL("private"))
+	require.NoError(t, err)
+	require.Equal(t, "https://cdn.example.com/uploads/files/my file.txt", url)
+
+	_, err = fm.Copy(context.Background(), "missing.txt", "copy.txt")
+	require.ErrorIs(t, err, filemanager.ErrNotFound)
+
+	mockS3.AssertExpectations(t)
+}
+
+func TestRemove(t *testing.T) {
+	bucket := "test-bucket"
+
+	mockS3 := new(mockS3Client)
+	mockS3.On("HeadObjectWithContext", mock.Anything, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("docs/file.txt"),
+	}, mock.Anything).Return(&s3.HeadObjectOutput{}, nil)
+	mockS3.On("DeleteObjectWithContext", mock.Anything, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("docs/file.txt"),
+	}, mock.Anything).Return(&s3.DeleteObjectOutput{}, nil)
+
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(mockS3),
+		filemanager.WithBucketName(bucket),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	// the URL returned by Upload maps back to the uploaded key
+	require.NoError(t, fm.Remove(context.Background(), "https://cdn.example.com/uploads/docs/file.txt"))
+
+	mockS3.AssertExpectations(t)
+}
+
 // ... More test cases ...
 func TestUploadFromMultipartForm(t *testing.T) {
 	fileContent := []byte("test content")
@@ -215,3 +386,67 @@ func TestUploadFromURL(t *testing.T) {
 	// Assert that the expectations were met
 	mockS3.AssertExpectations(t)
 }
+
+func TestUploadAllFromMultipartForm(t *testing.T) {
+	bucket := "test-bucket"
+	cdn := "https://cdn.example.com"
+	baseURL := "/uploads"
+
+	// Create a mock HTTP request with a multipart form containing several files
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, f := range []struct{ field, name string }{
+		{"photos", "a.jpg"},
+		{"photos", "b.jpg"},
+		{"document", "c.pdf"},
+	} {
+		file, err := writer.CreateFormFile(f.field, f.name)
+		require.NoError(t, err)
+		_, err = file.Write([]byte("content of " + f.name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.WriteField("title", "holiday"))
+	require.NoError(t, writer.Close())
+
+	req, err := http.NewRequest("POST", "/upload", body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	mockS3 := new(mockS3Client)
+	mockS3.On("PutObjectWithContext", mock.Anything, mock.MatchedBy(func(in *s3.PutObjectInput) bool {
+		return aws.StringValue(in.Key) != "b.jpg"
+	}), mock.Anything).Return(&s3.PutObjectOutput{}, nil)
+	mockS3.On("PutObjectWithContext", mock.Anything, mock.MatchedBy(func(in *s3.PutObjectInput) bool {
+		return aws.StringValue(in.Key) == "b.jpg"
+	}), mock.Anything).Return(nil, errors.New("upload failed"))
+
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(mockS3),
+		filemanager.WithBucketName(bucket),
+		filemanager.WithCDNURL(cdn),
+		filemanager.WithBasePath(baseURL),
+		filemanager.WithUploadConcurrency(2),
+	)
+	require.NoError(t, err)
+
+	result, err := fm.UploadAllFromMultipartForm(req)
+	require.NoError(t, err)
+	require.Equal(t, []string{"holiday"}, result.Values["title"])
+	require.Len(t, result.Files, 3)
+
+	require.Equal(t, "document", result.Files[0].FieldName)
+	require.Equal(t, "https://cdn.example.com/uploads/c.pdf", result.Files[0].URL)
+	require.Equal(t, int64(len("content of c.pdf")), result.Files[0].Size)
+	require.NoError(t, result.Files[0].Error)
+
+	require.Equal(t, "photos", result.Files[1].FieldName)
+	require.Equal(t, "a.jpg", result.Files[1].OriginalName)
+	require.Equal(t, "https://cdn.example.com/uploads/a.jpg", result.Files[1].URL)
+	require.NoError(t, result.Files[1].Error)
+
+	require.Equal(t, "b.jpg", result.Files[2].Key)
+	require.Empty(t, result.Files[2].URL)
+	require.ErrorIs(t, result.Files[2].Error, filemanager.ErrFailedToUploadFileFromMultipartForm)
+
+	mockS3.AssertExpectations(t)
+}
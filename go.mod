@@ -5,6 +5,7 @@ go 1.22
 require (
 	github.com/aws/aws-sdk-go v1.55.5
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/image v0.18.0
 	golang.org/x/sync v0.7.0
 )
 
@@ -0,0 +1,122 @@
+package filemanager
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+
+	_ "golang.org/x/image/bmp" // register the BMP format
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff" // register the TIFF format
+	_ "golang.org/x/image/webp" // register the WebP format
+)
+
+// DefaultJPEGQuality is the quality of JPEG images encoded after processing.
+const DefaultJPEGQuality = 85
+
+// processableImageTypes is the list of image content types that can be decoded and encoded back.
+var processableImageTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// decodableImageTypes is the list of image content types that can be decoded, but not necessarily encoded back.
+var decodableImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/bmp", "image/tiff"}
+
+// isProcessableImage checks if the content type is an image that can be processed.
+func isProcessableImage(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range processableImageTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// isDecodableImage checks if the content type is an image that can be decoded.
+func isDecodableImage(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range decodableImageTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeImage decodes a JPEG, PNG, GIF, WebP, BMP or TIFF image.
+// Only the first frame of animated GIF images is decoded.
+func decodeImage(data []byte) (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errors.Join(ErrFailedToProcessImage, err)
+	}
+	return img, format, nil
+}
+
+// decodeOrientedImage decodes the image and applies its EXIF orientation to the pixels,
+// so images encoded from it without the metadata, e.g. variants, are displayed upright.
+func decodeOrientedImage(data []byte) (image.Image, string, error) {
+	img, format, err := decodeImage(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if orientation := imageOrientation(data, format); orientation > 1 && orientation <= 8 {
+		img = orientImage(img, orientation)
+	}
+	return img, format, nil
+}
+
+// encodeImage encodes the image in the format, one of "jpeg", "png" or "gif".
+// The quality is used for JPEG images, DefaultJPEGQuality is used if it's not set.
+func encodeImage(img image.Image, format string, quality int) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		err error
+	)
+	switch format {
+	case "jpeg":
+		if quality <= 0 || quality > 100 {
+			quality = DefaultJPEGQuality
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = errors.New("unsupported image format: " + format)
+	}
+	if err != nil {
+		return nil, errors.Join(ErrFailedToProcessImage, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleImage scales the image to the given size with a high quality interpolation.
+func scaleImage(img image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// cropImage crops the centered region of the given size from the image.
+// The region is limited by the image bounds.
+func cropImage(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	width, height = min(width, b.Dx()), min(height, b.Dy())
+	x0 := b.Min.X + (b.Dx()-width)/2
+	y0 := b.Min.Y + (b.Dy()-height)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x0, y0), draw.Src)
+	return dst
+}
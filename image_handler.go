@@ -0,0 +1,259 @@
+package filemanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Default settings of the image handler.
+const (
+	DefaultImageCachePrefix = "cache/images"
+	DefaultImageMaxSize     = 4096
+)
+
+type (
+	// ImageHandler is an http.Handler resizing images stored in the S3 bucket on the fly.
+	// It serves GET {urlPath}/{width}x{height}/{key}?mode={fit|fill|crop}&s={signature} requests,
+	// where the signature is an HMAC of the parameters, see ImageHandler.URL.
+	//
+	// The resized image is written back to the bucket under the cache prefix,
+	// and the following requests are served from the cached object without resizing.
+	ImageHandler struct {
+		fm           *FileManager
+		mux          *http.ServeMux
+		urlPath      string
+		secret       []byte
+		cachePrefix  string
+		redirect     bool
+		maxSize      int
+		quality      int
+		cacheControl string
+		group        singleflight.Group
+	}
+
+	// ImageHandlerOption represents an image handler option function.
+	ImageHandlerOption func(*ImageHandler)
+)
+
+// WithImageCachePrefix sets the prefix the resized images are stored under.
+// Defaults to DefaultImageCachePrefix.
+func WithImageCachePrefix(prefix string) ImageHandlerOption {
+	return func(h *ImageHandler) {
+		if prefix = strings.Trim(prefix, "/"); prefix != "" {
+			h.cachePrefix = prefix
+		}
+	}
+}
+
+// WithImageRedirect makes the handler redirect to the CDN URL of the resized image instead of serving it.
+// The resized images are stored with the default ACL then, so they must be publicly readable.
+func WithImageRedirect() ImageHandlerOption {
+	return func(h *ImageHandler) {
+		h.redirect = true
+	}
+}
+
+// WithImageMaxSize sets the max width and height of resized images. Defaults to DefaultImageMaxSize.
+func WithImageMaxSize(maxSize int) ImageHandlerOption {
+	return func(h *ImageHandler) {
+		if maxSize > 0 {
+			h.maxSize = maxSize
+		}
+	}
+}
+
+// WithImageQuality sets the quality of resized JPEG images. Defaults to DefaultJPEGQuality.
+func WithImageQuality(quality int) ImageHandlerOption {
+	return func(h *ImageHandler) {
+		h.quality = quality
+	}
+}
+
+// WithImageCacheControl sets the Cache-Control header of resized images.
+// Defaults to DefaultAssetCacheControl, since a resized image never changes.
+func WithImageCacheControl(cacheControl string) ImageHandlerOption {
+	return func(h *ImageHandler) {
+		if cacheControl != "" {
+			h.cacheControl = cacheControl
+		}
+	}
+}
+
+// NewImageHandler creates a new image resizing handler backed by the file manager.
+// The urlPath is the URL path the handler is mounted at, e.g. "/img".
+// The secret is used to sign the image URLs, so clients can't request arbitrary sizes.
+func NewImageHandler(fm *FileManager, urlPath string, secret []byte, opts ...ImageHandlerOption) *ImageHandler {
+	h := &ImageHandler{
+		fm:           fm,
+		mux:          http.NewServeMux(),
+		urlPath:      strings.TrimRight("/"+strings.Trim(urlPath, "/"), "/"),
+		secret:       secret,
+		cachePrefix:  DefaultImageCachePrefix,
+		maxSize:      DefaultImageMaxSize,
+		cacheControl: DefaultAssetCacheControl,
+	}
+	for _, o := range opts {
+		o(h)
+	}
+
+	h.mux.HandleFunc("GET "+h.urlPath+"/{size}/{key...}", h.serve)
+
+	return h
+}
+
+// URL returns the signed URL path of the image resized to the size with the mode.
+// The path segments of the key are escaped, so keys with spaces, "?", "#" or "%" are served as well.
+func (h *ImageHandler) URL(width, height int, mode ResizeMode, key string) string {
+	query := url.Values{}
+	if mode != ResizeFit {
+		query.Set("mode", mode.String())
+	}
+	query.Set("s", h.sign(width, height, mode, key))
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf("%s/%dx%d/%s?%s", h.urlPath, width, height, strings.Join(segments, "/"), query.Encode())
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *ImageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// serve handles requests of resized images.
+func (h *ImageHandler) serve(w http.ResponseWriter, r *http.Request) {
+	width, height, err := parseImageSize(r.PathValue("size"))
+	if err != nil || width > h.maxSize || height > h.maxSize {
+		http.Error(w, "invalid image size", http.StatusBadRequest)
+		return
+	}
+	mode, err := parseResizeMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := r.PathValue("key")
+	if key == "" || path.Clean("/"+key) != "/"+key {
+		http.NotFound(w, r)
+		return
+	}
+
+	signature := h.sign(width, height, mode, key)
+	if !hmac.Equal([]byte(signature), []byte(r.URL.Query().Get("s"))) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	cacheKey := path.Join(h.cachePrefix, fmt.Sprintf("%dx%d_%s", width, height, mode), key)
+	if _, err := h.fm.Stat(r.Context(), cacheKey); err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			h.serverError(w, r, err)
+			return
+		}
+
+		// resize the image once, even if it's requested concurrently,
+		// and finish it even if the client which started it has gone
+		_, err, _ := h.group.Do(cacheKey, func() (any, error) {
+			return nil, h.resize(context.WithoutCancel(r.Context()), key, cacheKey, width, height, mode)
+		})
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			if errors.Is(err, ErrFailedToProcessImage) || errors.Is(err, ErrImageTooLarge) {
+				http.Error(w, "unsupported image", http.StatusUnprocessableEntity)
+				return
+			}
+			h.serverError(w, r, err)
+			return
+		}
+	}
+
+	if h.redirect {
+		http.Redirect(w, r, h.fm.fileAbsolutePath(cacheKey), http.StatusFound)
+		return
+	}
+	h.fm.ServeFile(w, r, cacheKey)
+}
+
+// resize resizes the original image and stores it under the cache key.
+func (h *ImageHandler) resize(ctx context.Context, key, cacheKey string, width, height int, mode ResizeMode) error {
+	info, err := h.fm.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !isProcessableImage(info.ContentType) {
+		return errors.Join(ErrFailedToProcessImage, fmt.Errorf("unsupported content type %q", info.ContentType))
+	}
+
+	data, err := h.fm.getObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	if h.fm.imageLimits != nil {
+		if err := h.fm.imageLimits.Check(data); err != nil {
+			return err
+		}
+	}
+	img, format, err := decodeOrientedImage(data)
+	if err != nil {
+		return err
+	}
+	resized, err := encodeImage(resizeImage(img, width, height, mode), format, h.quality)
+	if err != nil {
+		return err
+	}
+
+	opts := []UploadOption{WithObjectCacheControl(h.cacheControl)}
+	if !h.redirect {
+		opts = append(opts, WithObjectACL("private"))
+	}
+	_, err = h.fm.putObject(ctx, bytes.NewReader(resized), cacheKey, info.ContentType, opts...)
+	return err
+}
+
+// sign returns the signature of the image parameters.
+func (h *ImageHandler) sign(width, height int, mode ResizeMode, key string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	_, _ = fmt.Fprintf(mac, "%dx%d/%s/%s", width, height, mode, key)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// serverError logs the error and responds with 500 Internal Server Error.
+func (h *ImageHandler) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "failed to serve image", "path", r.URL.Path, "error", err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// parseImageSize parses the image size in the "{width}x{height}" format.
+func parseImageSize(size string) (int, int, error) {
+	ws, hs, ok := strings.Cut(size, "x")
+	if !ok {
+		return 0, 0, errors.New("invalid image size")
+	}
+	width, err := strconv.Atoi(ws)
+	if err != nil || width <= 0 {
+		return 0, 0, errors.New("invalid image width")
+	}
+	height, err := strconv.Atoi(hs)
+	if err != nil || height <= 0 {
+		return 0, 0, errors.New("invalid image height")
+	}
+	return width, height, nil
+}
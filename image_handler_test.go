@@ -0,0 +1,130 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestImageHandler(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	_, err = fm.Upload(context.Background(), bytes.NewReader(encodePNG(t, testImage(400, 200))), "photos/cat.png", "image/png")
+	require.NoError(t, err)
+	_, err = fm.Upload(context.Background(), bytes.NewReader([]byte("hello")), "notes.txt", "text/plain")
+	require.NoError(t, err)
+
+	secret := []byte("secret")
+	h := filemanager.NewImageHandler(fm, "/img", secret, filemanager.WithImageMaxSize(1000))
+
+	do := func(h http.Handler, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	t.Run("resize and cache", func(t *testing.T) {
+		target := h.URL(100, 100, filemanager.ResizeFill, "photos/cat.png")
+		require.True(t, strings.HasPrefix(target, "/img/100x100/photos/cat.png?mode=fill&s="))
+
+		rec := do(h, target)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+		require.Equal(t, filemanager.DefaultAssetCacheControl, rec.Header().Get("Cache-Control"))
+		cfg := decodeConfig(t, rec.Body.Bytes())
+		require.Equal(t, 100, cfg.Width)
+		require.Equal(t, 100, cfg.Height)
+
+		cached := s3Client.object("cache/images/100x100_fill/photos/cat.png")
+		require.NotNil(t, cached)
+		require.Equal(t, "private", cached.acl)
+
+		// the cached image is served even if the original is gone
+		s3Client.mu.Lock()
+		delete(s3Client.objects, "photos/cat.png")
+		s3Client.mu.Unlock()
+
+		rec = do(h, target)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, cached.data, rec.Body.Bytes())
+	})
+
+	t.Run("escaped key", func(t *testing.T) {
+		_, err := fm.Upload(context.Background(), bytes.NewReader(encodePNG(t, testImage(400, 200))), "photos/my cat #1?%.png", "image/png")
+		require.NoError(t, err)
+
+		target := h.URL(100, 100, filemanager.ResizeFit, "photos/my cat #1?%.png")
+		require.True(t, strings.HasPrefix(target, "/img/100x100/photos/my%20cat%20%231%3F%25.png?s="))
+		rec := do(h, target)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, s3Client.object("cache/images/100x100_fit/photos/my cat #1?%.png"))
+	})
+
+	t.Run("exif orientation", func(t *testing.T) {
+		_, err := fm.Upload(context.Background(), bytes.NewReader(jpegWithMetadata(t, 6)), "photos/rotated.jpg", "image/jpeg")
+		require.NoError(t, err)
+
+		rec := do(h, h.URL(100, 100, filemanager.ResizeFit, "photos/rotated.jpg"))
+		require.Equal(t, http.StatusOK, rec.Code)
+		img, err := jpeg.Decode(rec.Body)
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+		// rotated 90 degrees clockwise: the left half becomes the top half
+		r, _, b, _ := img.At(10, 5).RGBA()
+		require.Greater(t, r, b)
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		_, err = fm.Upload(context.Background(), bytes.NewReader(encodePNG(t, testImage(400, 200))), "photos/dog.png", "image/png")
+		require.NoError(t, err)
+		h := filemanager.NewImageHandler(fm, "/img/", secret, filemanager.WithImageRedirect(), filemanager.WithImageCachePrefix("thumbs"))
+
+		rec := do(h, h.URL(200, 200, filemanager.ResizeFit, "photos/dog.png"))
+		require.Equal(t, http.StatusFound, rec.Code)
+		require.Equal(t, "https://cdn.example.com/uploads/thumbs/200x200_fit/photos/dog.png", rec.Header().Get("Location"))
+
+		cached := s3Client.object("thumbs/200x200_fit/photos/dog.png")
+		require.NotNil(t, cached)
+		require.Equal(t, filemanager.DefaultACL, cached.acl)
+		cfg := decodeConfig(t, cached.data)
+		require.Equal(t, 200, cfg.Width)
+		require.Equal(t, 100, cfg.Height)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		target := h.URL(100, 100, filemanager.ResizeFit, "photos/cat.png")
+		require.Equal(t, http.StatusForbidden, do(h, strings.Replace(target, "100x100", "101x100", 1)).Code)
+		require.Equal(t, http.StatusForbidden, do(h, target+"&mode=crop").Code)
+
+		other := filemanager.NewImageHandler(fm, "/img", []byte("other"))
+		require.Equal(t, http.StatusForbidden, do(h, other.URL(100, 100, filemanager.ResizeFit, "photos/cat.png")).Code)
+	})
+
+	t.Run("invalid size", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, do(h, h.URL(2000, 100, filemanager.ResizeFit, "photos/cat.png")).Code)
+		require.Equal(t, http.StatusBadRequest, do(h, "/img/100/photos/cat.png").Code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, do(h, h.URL(100, 100, filemanager.ResizeFit, "photos/missing.png")).Code)
+	})
+
+	t.Run("not an image", func(t *testing.T) {
+		require.Equal(t, http.StatusUnprocessableEntity, do(h, h.URL(100, 100, filemanager.ResizeFit, "notes.txt")).Code)
+	})
+}
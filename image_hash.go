@@ -0,0 +1,240 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Metadata keys of the perceptual hashes, stored as x-amz-meta-* headers.
+const (
+	aHashMetadataKey = "ahash"
+	dHashMetadataKey = "dhash"
+	pHashMetadataKey = "phash"
+)
+
+type (
+	// ImageHash represents the perceptual hashes of an image.
+	// Unlike cryptographic hashes, similar images have similar hashes,
+	// so resized or recompressed copies of an image can be found by the Hamming distance of the hashes.
+	ImageHash struct {
+		// AHash is the average hash: the pixels brighter than the average of an 8x8 grayscale thumbnail.
+		AHash uint64 `json:"ahash"`
+		// DHash is the difference hash: the horizontal gradients of a 9x8 grayscale thumbnail.
+		DHash uint64 `json:"dhash"`
+		// PHash is the DCT based hash: the low frequencies of a 32x32 grayscale thumbnail above their median.
+		PHash uint64 `json:"phash"`
+	}
+
+	// ImageHashMatch represents an image found by its perceptual hash.
+	ImageHashMatch struct {
+		// Key is the object key of the image.
+		Key string
+		// Hash is the perceptual hash of the image.
+		Hash ImageHash
+		// Distance is the distance between the hash of the image and the looked up hash, see ImageHash.Distance.
+		Distance int
+	}
+
+	// ImageHashIndex stores the perceptual hashes of images and looks up similar images.
+	// Implementations must be safe for concurrent use.
+	ImageHashIndex interface {
+		// Add stores the hash of the image with the key, replacing the stored hash if any.
+		Add(ctx context.Context, key string, hash ImageHash) error
+		// Remove removes the hash of the image with the key, if it's stored.
+		Remove(ctx context.Context, key string) error
+		// FindSimilar returns the images with hashes within the max distance of the hash, closest first.
+		FindSimilar(ctx context.Context, hash ImageHash, maxDistance int) ([]ImageHashMatch, error)
+	}
+
+	// MemoryImageHashIndex is an in-memory ImageHashIndex with a linear lookup,
+	// suitable for small sets of images, e.g. a list of banned images.
+	MemoryImageHashIndex struct {
+		mu     sync.RWMutex
+		hashes map[string]ImageHash
+	}
+)
+
+// NewMemoryImageHashIndex creates a new in-memory image hash index.
+func NewMemoryImageHashIndex() *MemoryImageHashIndex {
+	return &MemoryImageHashIndex{hashes: make(map[string]ImageHash)}
+}
+
+// Add stores the hash of the image with the key, replacing the stored hash if any.
+func (idx *MemoryImageHashIndex) Add(_ context.Context, key string, hash ImageHash) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.hashes[key] = hash
+	return nil
+}
+
+// Remove removes the hash of the image with the key, if it's stored.
+func (idx *MemoryImageHashIndex) Remove(_ context.Context, key string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.hashes, key)
+	return nil
+}
+
+// FindSimilar returns the images with hashes within the max distance of the hash, closest first.
+func (idx *MemoryImageHashIndex) FindSimilar(_ context.Context, hash ImageHash, maxDistance int) ([]ImageHashMatch, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var matches []ImageHashMatch
+	for key, h := range idx.hashes {
+		if d := hash.Distance(h); d <= maxDistance {
+			matches = append(matches, ImageHashMatch{Key: key, Hash: h, Distance: d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Key < matches[j].Key
+	})
+	return matches, nil
+}
+
+// ComputeImageHash computes the perceptual hashes of an image read from the reader.
+// It's used to hash images which aren't uploaded, e.g. to add banned images to an index.
+func ComputeImageHash(r io.Reader) (*ImageHash, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToProcessImage, err)
+	}
+	img, _, err := decodeOrientedImage(data)
+	if err != nil {
+		return nil, err
+	}
+	return newImageHash(img), nil
+}
+
+// Distance returns the Hamming distance between the pHashes, the number of differing bits from 0 to 64.
+// Copies of an image usually have a distance below 10.
+func (h ImageHash) Distance(other ImageHash) int {
+	return bits.OnesCount64(h.PHash ^ other.PHash)
+}
+
+// newImageHash computes the perceptual hashes of the image.
+func newImageHash(img image.Image) *ImageHash {
+	return &ImageHash{
+		AHash: averageHash(img),
+		DHash: differenceHash(img),
+		PHash: dctHash(img),
+	}
+}
+
+// metadata returns the perceptual hashes as object metadata.
+func (h *ImageHash) metadata() map[string]string {
+	return map[string]string{
+		aHashMetadataKey: fmt.Sprintf("%016x", h.AHash),
+		dHashMetadataKey: fmt.Sprintf("%016x", h.DHash),
+		pHashMetadataKey: fmt.Sprintf("%016x", h.PHash),
+	}
+}
+
+// imageHashFromMetadata returns the perceptual hashes stored in the object metadata, or nil if they're not stored.
+func imageHashFromMetadata(metadata map[string]string) *ImageHash {
+	var (
+		h   ImageHash
+		err error
+	)
+	for key, v := range map[string]*uint64{aHashMetadataKey: &h.AHash, dHashMetadataKey: &h.DHash, pHashMetadataKey: &h.PHash} {
+		if *v, err = strconv.ParseUint(metadata[key], 16, 64); err != nil {
+			return nil
+		}
+	}
+	return &h
+}
+
+// averageHash sets the bits of the pixels of an 8x8 grayscale thumbnail brighter than their average.
+func averageHash(img image.Image) uint64 {
+	pixels := grayscalePixels(img, 8, 8)
+	var sum float64
+	for _, p := range pixels {
+		sum += p
+	}
+	return hashBits(pixels, sum/float64(len(pixels)))
+}
+
+// differenceHash sets the bits of the pixels of a 9x8 grayscale thumbnail darker than their right neighbour.
+func differenceHash(img image.Image) uint64 {
+	pixels := grayscalePixels(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if pixels[y*9+x] < pixels[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// dctHash sets the bits of the 8x8 lowest frequencies of the DCT of a 32x32 grayscale thumbnail above their median.
+// The DC coefficient is excluded from the median, since it's much larger than the others.
+func dctHash(img image.Image) uint64 {
+	const size, hashSize = 32, 8
+	pixels := grayscalePixels(img, size, size)
+
+	// the 2D DCT is separable: transform the rows, then the columns of the low frequencies
+	rows := make([]float64, size*hashSize)
+	for y := 0; y < size; y++ {
+		for u := 0; u < hashSize; u++ {
+			rows[y*hashSize+u] = dctCoefficient(func(x int) float64 { return pixels[y*size+x] }, u, size)
+		}
+	}
+	freqs := make([]float64, hashSize*hashSize)
+	for v := 0; v < hashSize; v++ {
+		for u := 0; u < hashSize; u++ {
+			freqs[v*hashSize+u] = dctCoefficient(func(y int) float64 { return rows[y*hashSize+u] }, v, size)
+		}
+	}
+
+	sorted := append([]float64(nil), freqs[1:]...)
+	sort.Float64s(sorted)
+	return hashBits(freqs, sorted[len(sorted)/2])
+}
+
+// dctCoefficient returns the k-th DCT-II coefficient of the n values.
+func dctCoefficient(value func(i int) float64, k, n int) float64 {
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += value(i) * math.Cos(math.Pi*float64(k)*(2*float64(i)+1)/(2*float64(n)))
+	}
+	return sum
+}
+
+// hashBits sets the bits of the values above the threshold, the first value being the most significant bit.
+func hashBits(values []float64, threshold float64) uint64 {
+	var hash uint64
+	for _, v := range values {
+		hash <<= 1
+		if v > threshold {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// grayscalePixels scales the image to the size and returns the luminance of its pixels, row by row.
+func grayscalePixels(img image.Image, width, height int) []float64 {
+	scaled := scaleImage(img, width, height)
+	pixels := make([]float64, 0, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixels = append(pixels, float64(color.GrayModel.Convert(scaled.At(x, y)).(color.Gray).Y))
+		}
+	}
+	return pixels
+}
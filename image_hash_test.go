@@ -0,0 +1,99 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+// patternImage returns an image with a banded gradient pattern, so it has some structure for perceptual hashing.
+func patternImage(width, height int, seed int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			v := uint8(int((fx*fx+fy*float64(seed))*255*3) % 256)
+			img.Set(x, y, color.RGBA{R: v, G: 255 - v, B: uint8(x * 255 / width), A: 255})
+		}
+	}
+	return img
+}
+
+func TestImageHashes(t *testing.T) {
+	original := encodePNG(t, patternImage(400, 300, 1))
+
+	// a resized and recompressed copy of the original image
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, patternImage(200, 150, 1), &jpeg.Options{Quality: 60}))
+	resized := buf.Bytes()
+
+	other := encodePNG(t, patternImage(400, 300, 3))
+
+	t.Run("distance", func(t *testing.T) {
+		h1, err := filemanager.ComputeImageHash(bytes.NewReader(original))
+		require.NoError(t, err)
+		h2, err := filemanager.ComputeImageHash(bytes.NewReader(resized))
+		require.NoError(t, err)
+		h3, err := filemanager.ComputeImageHash(bytes.NewReader(other))
+		require.NoError(t, err)
+
+		require.Zero(t, h1.Distance(*h1))
+		require.Less(t, h1.Distance(*h2), 10)
+		require.Greater(t, h1.Distance(*h3), 10)
+
+		_, err = filemanager.ComputeImageHash(bytes.NewReader([]byte("not an image")))
+		require.ErrorIs(t, err, filemanager.ErrFailedToProcessImage)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		index := filemanager.NewMemoryImageHashIndex()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithImageHashIndex(index),
+		)
+		require.NoError(t, err)
+
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader(original), "photos/original.png", "image/png")
+		require.NoError(t, err)
+		require.NotNil(t, result.Hash)
+		require.Len(t, aws.StringValue(s3Client.object("photos/original.png").metadata["phash"]), 16)
+
+		info, err := fm.Stat(context.Background(), "photos/original.png")
+		require.NoError(t, err)
+		require.Equal(t, result.Hash, info.Hash)
+
+		_, err = fm.UploadImage(context.Background(), bytes.NewReader(other), "photos/other.png", "image/png")
+		require.NoError(t, err)
+
+		// a re-upload of the resized image is found in the index
+		hash, err := filemanager.ComputeImageHash(bytes.NewReader(resized))
+		require.NoError(t, err)
+		matches, err := index.FindSimilar(context.Background(), *hash, 10)
+		require.NoError(t, err)
+		require.Len(t, matches, 1)
+		require.Equal(t, "photos/original.png", matches[0].Key)
+		require.Equal(t, *result.Hash, matches[0].Hash)
+
+		matches, err = index.FindSimilar(context.Background(), *hash, 64)
+		require.NoError(t, err)
+		require.Len(t, matches, 2)
+		require.Equal(t, "photos/original.png", matches[0].Key)
+
+		// removed images are removed from the index
+		require.NoError(t, fm.RemoveFilesFromDirectory(context.Background(), "photos"))
+		matches, err = index.FindSimilar(context.Background(), *hash, 10)
+		require.NoError(t, err)
+		require.Empty(t, matches)
+	})
+}
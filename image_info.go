@@ -0,0 +1,207 @@
+package filemanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"strconv"
+)
+
+// Metadata keys of the image info, stored as x-amz-meta-* headers.
+const (
+	imageWidthMetadataKey      = "image-width"
+	imageHeightMetadataKey     = "image-height"
+	imageFormatMetadataKey     = "image-format"
+	imageFramesMetadataKey     = "image-frames"
+	imageColorModelMetadataKey = "image-color-model"
+)
+
+// ImageInfo represents the attributes of an image, read from its header.
+type ImageInfo struct {
+	// Width is the image width in pixels.
+	Width int `json:"width"`
+	// Height is the image height in pixels.
+	Height int `json:"height"`
+	// Format is the image format: "jpeg", "png", "gif", "webp", "bmp" or "tiff".
+	Format string `json:"format"`
+	// Frames is the number of frames, greater than 1 for animated images.
+	Frames int `json:"frames"`
+	// ColorModel is the color model of the image, e.g. "ycbcr", "rgba", "gray", "cmyk" or "paletted".
+	ColorModel string `json:"color_model"`
+}
+
+// readImageInfo reads the image info from the image header, without decoding the image.
+func readImageInfo(data []byte) (*ImageInfo, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Join(ErrFailedToProcessImage, err)
+	}
+
+	return &ImageInfo{
+		Width:      cfg.Width,
+		Height:     cfg.Height,
+		Format:     format,
+		Frames:     countImageFrames(data, format),
+		ColorModel: colorModelName(cfg.ColorModel),
+	}, nil
+}
+
+// metadata returns the image info as object metadata.
+func (i *ImageInfo) metadata() map[string]string {
+	return map[string]string{
+		imageWidthMetadataKey:      strconv.Itoa(i.Width),
+		imageHeightMetadataKey:     strconv.Itoa(i.Height),
+		imageFormatMetadataKey:     i.Format,
+		imageFramesMetadataKey:     strconv.Itoa(i.Frames),
+		imageColorModelMetadataKey: i.ColorModel,
+	}
+}
+
+// imageInfoFromMetadata returns the image info stored in the object metadata, or nil if it's not stored.
+func imageInfoFromMetadata(metadata map[string]string) *ImageInfo {
+	width, err := strconv.Atoi(metadata[imageWidthMetadataKey])
+	if err != nil {
+		return nil
+	}
+	height, err := strconv.Atoi(metadata[imageHeightMetadataKey])
+	if err != nil {
+		return nil
+	}
+	frames, err := strconv.Atoi(metadata[imageFramesMetadataKey])
+	if err != nil {
+		frames = 1
+	}
+
+	return &ImageInfo{
+		Width:      width,
+		Height:     height,
+		Format:     metadata[imageFormatMetadataKey],
+		Frames:     frames,
+		ColorModel: metadata[imageColorModelMetadataKey],
+	}
+}
+
+// colorModelName returns the name of the color model.
+func colorModelName(m color.Model) string {
+	switch m {
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	case color.AlphaModel:
+		return "alpha"
+	case color.Alpha16Model:
+		return "alpha16"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.CMYKModel:
+		return "cmyk"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.NYCbCrAModel:
+		return "nycbcra"
+	}
+	if _, ok := m.(color.Palette); ok {
+		return "paletted"
+	}
+	return "unknown"
+}
+
+// countImageFrames returns the number of frames of animated GIF, PNG and WebP images, or 1 for still images.
+// The frames are counted by walking the file structure, without decoding the image data.
+func countImageFrames(data []byte, format string) int {
+	var frames int
+	switch format {
+	case "gif":
+		frames = countGIFFrames(data)
+	case "png":
+		frames = countAPNGFrames(data)
+	case "webp":
+		frames = countWebPFrames(data)
+	}
+	return max(frames, 1)
+}
+
+// countGIFFrames counts the image descriptors of a GIF image.
+func countGIFFrames(data []byte) int {
+	// header and logical screen descriptor
+	if len(data) < 13 {
+		return 0
+	}
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << ((flags & 0x07) + 1) // global color table
+	}
+
+	// skipSubBlocks skips a sequence of data sub-blocks terminated by an empty block
+	skipSubBlocks := func(i int) int {
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		return i + 1
+	}
+
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension
+			i = skipSubBlocks(i + 2)
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return frames
+			}
+			frames++
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << ((flags & 0x07) + 1) // local color table
+			}
+			i = skipSubBlocks(i + 1) // LZW minimum code size and image data
+		default: // trailer or invalid data
+			return frames
+		}
+	}
+	return frames
+}
+
+// countAPNGFrames returns the number of frames of an animated PNG image, stored in the acTL chunk.
+func countAPNGFrames(data []byte) int {
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		switch string(data[i+4 : i+8]) {
+		case "acTL":
+			if length >= 4 && i+12 <= len(data) {
+				return int(binary.BigEndian.Uint32(data[i+8:]))
+			}
+			return 0
+		case "IDAT":
+			return 0 // the animation control chunk precedes the image data
+		}
+		i += length + 12
+	}
+	return 0
+}
+
+// countWebPFrames counts the animation frame chunks of a WebP image.
+func countWebPFrames(data []byte) int {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0
+	}
+	frames := 0
+	for i := 12; i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if string(data[i:i+4]) == "ANMF" {
+			frames++
+		}
+		i += 8 + size + size%2 // chunks are padded to an even size
+	}
+	return frames
+}
@@ -0,0 +1,116 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestImageInfo(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+		filemanager.WithImageInfo(true),
+	)
+	require.NoError(t, err)
+
+	upload := func(t *testing.T, data []byte, filename, contentType string) *filemanager.UploadResult {
+		t.Helper()
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader(data), filename, contentType)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("jpeg", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, jpeg.Encode(&buf, testImage(40, 30), nil))
+
+		result := upload(t, buf.Bytes(), "photo.jpg", "image/jpeg")
+		want := &filemanager.ImageInfo{Width: 40, Height: 30, Format: "jpeg", Frames: 1, ColorModel: "ycbcr"}
+		require.Equal(t, want, result.Image)
+
+		obj := s3Client.object("photo.jpg")
+		require.Equal(t, "40", aws.StringValue(obj.metadata["image-width"]))
+		require.Equal(t, "30", aws.StringValue(obj.metadata["image-height"]))
+		require.Equal(t, "jpeg", aws.StringValue(obj.metadata["image-format"]))
+
+		info, err := fm.Stat(context.Background(), "photo.jpg")
+		require.NoError(t, err)
+		require.Equal(t, want, info.Image)
+	})
+
+	t.Run("animated gif", func(t *testing.T) {
+		palette := color.Palette{color.Black, color.White}
+		anim := &gif.GIF{}
+		for i := 0; i < 3; i++ {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 16, 8), palette))
+			anim.Delay = append(anim.Delay, 10)
+		}
+		var buf bytes.Buffer
+		require.NoError(t, gif.EncodeAll(&buf, anim))
+
+		result := upload(t, buf.Bytes(), "anim.gif", "image/gif")
+		require.Equal(t, &filemanager.ImageInfo{Width: 16, Height: 8, Format: "gif", Frames: 3, ColorModel: "paletted"}, result.Image)
+	})
+
+	t.Run("animated png", func(t *testing.T) {
+		encoded := encodePNG(t, testImage(10, 10))
+		actl := binary.BigEndian.AppendUint32(nil, 4) // frames
+		actl = binary.BigEndian.AppendUint32(actl, 0) // plays
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(len(actl)))
+		chunk = append(chunk, "acTL"...)
+		chunk = append(chunk, actl...)
+		chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(append([]byte("acTL"), actl...)))
+
+		// insert the animation control chunk after the IHDR chunk
+		data := append(append(append([]byte{}, encoded[:33]...), chunk...), encoded[33:]...)
+		result := upload(t, data, "anim.png", "image/png")
+		require.Equal(t, 4, result.Image.Frames)
+		require.Equal(t, "png", result.Image.Format)
+	})
+
+	t.Run("variants", func(t *testing.T) {
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithImageInfo(true),
+			filemanager.WithImageVariants(filemanager.ImageVariant{Name: "thumb", Width: 20, Height: 20}),
+		)
+		require.NoError(t, err)
+
+		_, err = fm.UploadImage(context.Background(), bytes.NewReader(encodePNG(t, testImage(80, 40))), "wide.png", "image/png")
+		require.NoError(t, err)
+		require.Equal(t, "80", aws.StringValue(s3Client.object("wide.png").metadata["image-width"]))
+		require.Equal(t, "20", aws.StringValue(s3Client.object("wide_thumb.png").metadata["image-width"]))
+		require.Equal(t, "10", aws.StringValue(s3Client.object("wide_thumb.png").metadata["image-height"]))
+	})
+
+	t.Run("invalid image", func(t *testing.T) {
+		_, err := fm.UploadImage(context.Background(), bytes.NewReader([]byte("not an image")), "broken.png", "image/png")
+		require.ErrorIs(t, err, filemanager.ErrFailedToProcessImage)
+		require.Nil(t, s3Client.object("broken.png"))
+	})
+
+	t.Run("other types", func(t *testing.T) {
+		result := upload(t, []byte("hello"), "notes.txt", "text/plain")
+		require.Nil(t, result.Image)
+
+		info, err := fm.Stat(context.Background(), "notes.txt")
+		require.NoError(t, err)
+		require.Nil(t, info.Image)
+	})
+}
@@ -0,0 +1,86 @@
+package filemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+)
+
+// Limits checked by ImageLimits, see ImageLimitError.Limit.
+const (
+	ImageLimitPixels           = "pixels"
+	ImageLimitFrames           = "frames"
+	ImageLimitCompressionRatio = "compression ratio"
+)
+
+// DefaultImageLimits are the recommended limits of uploaded images:
+// up to 50 megapixels, 1000 frames, and 10000 decoded bytes per byte of the file.
+var DefaultImageLimits = ImageLimits{
+	MaxPixels:           50_000_000,
+	MaxFrames:           1000,
+	MaxCompressionRatio: 10000,
+}
+
+type (
+	// ImageLimits protects the image processing from decompression bombs:
+	// tiny files declaring huge dimensions or thousands of frames, which exhaust the memory once decoded.
+	// The limits are checked against the image header, before the image is decoded.
+	// A zero limit is not checked.
+	ImageLimits struct {
+		// MaxPixels is the max number of pixels of a frame, width multiplied by height.
+		MaxPixels int64
+		// MaxFrames is the max number of frames of animated images.
+		MaxFrames int
+		// MaxCompressionRatio is the max ratio of the decoded size of all frames, 4 bytes per pixel, to the file size.
+		MaxCompressionRatio float64
+	}
+
+	// ImageLimitError is returned when an image exceeds one of the ImageLimits.
+	// It wraps ErrImageTooLarge.
+	ImageLimitError struct {
+		// Limit is the exceeded limit: ImageLimitPixels, ImageLimitFrames or ImageLimitCompressionRatio.
+		Limit string
+		// Value is the value of the image.
+		Value float64
+		// Max is the max allowed value.
+		Max float64
+	}
+)
+
+// Error implements the error interface.
+func (e *ImageLimitError) Error() string {
+	return fmt.Sprintf("%s: %s %s exceeds the limit of %s", ErrImageTooLarge, e.Limit,
+		strconv.FormatFloat(e.Value, 'f', -1, 64), strconv.FormatFloat(e.Max, 'f', -1, 64))
+}
+
+// Unwrap returns ErrImageTooLarge, so the error matches it with errors.Is.
+func (e *ImageLimitError) Unwrap() error {
+	return ErrImageTooLarge
+}
+
+// Check reads the image header and returns an *ImageLimitError if the image exceeds the limits.
+// It returns an error wrapping ErrFailedToProcessImage if the header can't be read.
+func (l ImageLimits) Check(data []byte) error {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return errors.Join(ErrFailedToProcessImage, err)
+	}
+
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	if l.MaxPixels > 0 && pixels > l.MaxPixels {
+		return &ImageLimitError{Limit: ImageLimitPixels, Value: float64(pixels), Max: float64(l.MaxPixels)}
+	}
+	frames := countImageFrames(data, format)
+	if l.MaxFrames > 0 && frames > l.MaxFrames {
+		return &ImageLimitError{Limit: ImageLimitFrames, Value: float64(frames), Max: float64(l.MaxFrames)}
+	}
+	if l.MaxCompressionRatio > 0 && len(data) > 0 {
+		ratio := float64(pixels) * 4 * float64(frames) / float64(len(data))
+		if ratio > l.MaxCompressionRatio {
+			return &ImageLimitError{Limit: ImageLimitCompressionRatio, Value: ratio, Max: l.MaxCompressionRatio}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+// pngBomb returns a tiny PNG file declaring the size in its header.
+func pngBomb(width, height uint32) []byte {
+	chunk := func(chunkType string, data []byte) []byte {
+		c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+		c = append(c, chunkType...)
+		c = append(c, data...)
+		return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+	}
+	ihdr := binary.BigEndian.AppendUint32(nil, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0) // 8-bit RGBA
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = append(data, chunk("IHDR", ihdr)...)
+	data = append(data, chunk("IDAT", []byte{0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01})...)
+	return append(data, chunk("IEND", nil)...)
+}
+
+func TestImageLimits(t *testing.T) {
+	t.Run("pixels", func(t *testing.T) {
+		err := filemanager.DefaultImageLimits.Check(pngBomb(50000, 50000))
+		require.ErrorIs(t, err, filemanager.ErrImageTooLarge)
+
+		var limitErr *filemanager.ImageLimitError
+		require.ErrorAs(t, err, &limitErr)
+		require.Equal(t, filemanager.ImageLimitPixels, limitErr.Limit)
+		require.Equal(t, float64(2_500_000_000), limitErr.Value)
+		require.Equal(t, "image is too large: pixels 2500000000 exceeds the limit of 50000000", err.Error())
+	})
+
+	t.Run("frames", func(t *testing.T) {
+		palette := color.Palette{color.Black, color.White}
+		anim := &gif.GIF{}
+		for i := 0; i < 3; i++ {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 16, 8), palette))
+			anim.Delay = append(anim.Delay, 10)
+		}
+		var buf bytes.Buffer
+		require.NoError(t, gif.EncodeAll(&buf, anim))
+
+		require.NoError(t, filemanager.ImageLimits{MaxFrames: 3}.Check(buf.Bytes()))
+
+		var limitErr *filemanager.ImageLimitError
+		require.ErrorAs(t, filemanager.ImageLimits{MaxFrames: 2}.Check(buf.Bytes()), &limitErr)
+		require.Equal(t, filemanager.ImageLimitFrames, limitErr.Limit)
+		require.Equal(t, float64(3), limitErr.Value)
+	})
+
+	t.Run("compression ratio", func(t *testing.T) {
+		data := pngBomb(2000, 2000)
+		require.NoError(t, filemanager.ImageLimits{MaxPixels: 5_000_000}.Check(data))
+
+		var limitErr *filemanager.ImageLimitError
+		require.ErrorAs(t, filemanager.DefaultImageLimits.Check(data), &limitErr)
+		require.Equal(t, filemanager.ImageLimitCompressionRatio, limitErr.Limit)
+
+		// regular images are accepted
+		require.NoError(t, filemanager.DefaultImageLimits.Check(encodePNG(t, testImage(400, 300))))
+	})
+
+	t.Run("invalid image", func(t *testing.T) {
+		require.ErrorIs(t, filemanager.DefaultImageLimits.Check([]byte("not an image")), filemanager.ErrFailedToProcessImage)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		limits := filemanager.DefaultImageLimits
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithImageLimits(&limits),
+		)
+		require.NoError(t, err)
+
+		_, err = fm.Upload(context.Background(), bytes.NewReader(pngBomb(50000, 50000)), "bomb.png", "image/png")
+		require.ErrorIs(t, err, filemanager.ErrFailedToUploadFile)
+		require.ErrorIs(t, err, filemanager.ErrImageTooLarge)
+		require.Nil(t, s3Client.object("bomb.png"))
+
+		_, err = fm.Upload(context.Background(), bytes.NewReader(encodePNG(t, testImage(40, 30))), "ok.png", "image/png")
+		require.NoError(t, err)
+		require.NotNil(t, s3Client.object("ok.png"))
+	})
+}
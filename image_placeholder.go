@@ -0,0 +1,222 @@
+package filemanager
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Metadata keys of the image placeholders, stored as x-amz-meta-* headers.
+const (
+	blurHashMetadataKey      = "blurhash"
+	lqipMetadataKey          = "lqip"
+	dominantColorMetadataKey = "dominant-color"
+)
+
+// Settings of the image placeholders.
+const (
+	// blurHashComponentsX and blurHashComponentsY are the number of BlurHash components on each axis.
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+	// placeholderSampleSize is the max size of the image the BlurHash and the dominant color are computed from.
+	placeholderSampleSize = 32
+	// lqipSize is the max width and height of the preview image.
+	// The preview is stored in the object metadata, which is limited to 2KB by S3.
+	lqipSize = 16
+	// lqipQuality is the quality of JPEG preview images.
+	lqipQuality = 40
+)
+
+// ImagePlaceholder represents the placeholders rendered while an image is loading.
+type ImagePlaceholder struct {
+	// BlurHash is the BlurHash of the image, see https://blurha.sh.
+	BlurHash string `json:"blurhash"`
+	// LQIP is a tiny low-quality preview of the image, as a base64 data URI.
+	LQIP string `json:"lqip"`
+	// DominantColor is the dominant color of the image, as a hex string, e.g. "#1e90ff".
+	DominantColor string `json:"dominant_color"`
+}
+
+// newImagePlaceholder generates the placeholders of the image.
+func newImagePlaceholder(img image.Image) (*ImagePlaceholder, error) {
+	sample := resizeImage(img, placeholderSampleSize, placeholderSampleSize, ResizeFit)
+
+	// previews of transparent images are encoded as PNG to keep the transparency
+	preview := resizeImage(sample, lqipSize, lqipSize, ResizeFit)
+	format, mimeType := "jpeg", "image/jpeg"
+	if o, ok := preview.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		format, mimeType = "png", "image/png"
+	}
+	data, err := encodeImage(preview, format, lqipQuality)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImagePlaceholder{
+		BlurHash:      blurHash(sample, blurHashComponentsX, blurHashComponentsY),
+		LQIP:          "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data),
+		DominantColor: dominantColor(sample),
+	}, nil
+}
+
+// metadata returns the image placeholders as object metadata.
+func (p *ImagePlaceholder) metadata() map[string]string {
+	return map[string]string{
+		blurHashMetadataKey:      p.BlurHash,
+		lqipMetadataKey:          p.LQIP,
+		dominantColorMetadataKey: p.DominantColor,
+	}
+}
+
+// imagePlaceholderFromMetadata returns the image placeholders stored in the object metadata, or nil if they're not stored.
+func imagePlaceholderFromMetadata(metadata map[string]string) *ImagePlaceholder {
+	p := &ImagePlaceholder{
+		BlurHash:      metadata[blurHashMetadataKey],
+		LQIP:          metadata[lqipMetadataKey],
+		DominantColor: metadata[dominantColorMetadataKey],
+	}
+	if p.BlurHash == "" && p.LQIP == "" && p.DominantColor == "" {
+		return nil
+	}
+	return p
+}
+
+// dominantColor returns the most common color of the image, as a hex string.
+// The colors are grouped into buckets of similar colors, and the average color of the largest bucket is returned.
+// Mostly transparent pixels are ignored.
+func dominantColor(img image.Image) string {
+	type bucket struct {
+		r, g, b, n int
+	}
+	var (
+		buckets = make(map[int]*bucket)
+		top     = &bucket{}
+	)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			// 4 bits per channel
+			idx := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			bk, ok := buckets[idx]
+			if !ok {
+				bk = &bucket{}
+				buckets[idx] = bk
+			}
+			bk.r, bk.g, bk.b, bk.n = bk.r+int(c.R), bk.g+int(c.G), bk.b+int(c.B), bk.n+1
+			if bk.n > top.n {
+				top = bk
+			}
+		}
+	}
+	if top.n == 0 {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", top.r/top.n, top.g/top.n, top.b/top.n)
+}
+
+// blurHashCharacters is the alphabet of the base83 encoding used by BlurHash.
+const blurHashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurHash encodes the image as a BlurHash with the number of components on each axis, 1 to 9.
+// See https://github.com/woltapp/blurhash/blob/master/Algorithm.md.
+func blurHash(img image.Image, componentsX, componentsY int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// linear RGB pixels, so the image is only converted once
+	pixels := make([][3]float64, 0, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			pixels = append(pixels, [3]float64{srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)})
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := normalisation * basisY * math.Cos(math.Pi*float64(i)*float64(x)/float64(width))
+					p := pixels[y*width+x]
+					f[0], f[1], f[2] = f[0]+basis*p[0], f[1]+basis*p[1], f[2]+basis*p[2]
+				}
+			}
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	encodeBase83(&sb, (componentsX-1)+(componentsY-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			actualMax = max(actualMax, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantisedMax := min(max(int(math.Floor(actualMax*166-0.5)), 0), 82)
+		maxValue = float64(quantisedMax+1) / 166
+		encodeBase83(&sb, quantisedMax, 1)
+	} else {
+		encodeBase83(&sb, 0, 1)
+	}
+
+	encodeBase83(&sb, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	quantise := func(v float64) int {
+		return min(max(int(math.Floor(signPow(v/maxValue, 0.5)*9+9.5)), 0), 18)
+	}
+	for _, f := range ac {
+		encodeBase83(&sb, quantise(f[0])*19*19+quantise(f[1])*19+quantise(f[2]), 2)
+	}
+	return sb.String()
+}
+
+// encodeBase83 writes the value encoded in base83 with the given number of digits.
+func encodeBase83(sb *strings.Builder, value, length int) {
+	divisor := 1
+	for i := 1; i < length; i++ {
+		divisor *= 83
+	}
+	for ; divisor > 0; divisor /= 83 {
+		sb.WriteByte(blurHashCharacters[(value/divisor)%83])
+	}
+}
+
+// srgbToLinear converts an sRGB channel value to linear RGB.
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts a linear RGB channel value to sRGB.
+func linearToSRGB(v float64) int {
+	v = min(max(v, 0), 1)
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow raises the absolute value to the power, keeping the sign.
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,121 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+// solidImage returns an image filled with the color, with the right third filled with the other color.
+func solidImage(width, height int, c, other color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x >= width*2/3 {
+				img.Set(x, y, other)
+			} else {
+				img.Set(x, y, c)
+			}
+		}
+	}
+	return img
+}
+
+func TestImagePlaceholders(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+		filemanager.WithImagePlaceholders(true),
+	)
+	require.NoError(t, err)
+
+	red, blue := color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}
+
+	t.Run("solid color", func(t *testing.T) {
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader(encodePNG(t, solidImage(64, 64, red, red))), "red.png", "image/png")
+		require.NoError(t, err)
+		require.NotNil(t, result.Placeholder)
+
+		// 4x3 components, and the average color is encoded in the 3rd to 6th characters
+		hash := result.Placeholder.BlurHash
+		require.Len(t, hash, 28)
+		require.Equal(t, "L", hash[:1])
+		require.Equal(t, "TI:j", hash[2:6])
+		require.Equal(t, "#ff0000", result.Placeholder.DominantColor)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, jpeg.Encode(&buf, solidImage(600, 400, red, blue), &jpeg.Options{Quality: 95}))
+
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader(buf.Bytes()), "photo.jpg", "image/jpeg")
+		require.NoError(t, err)
+		p := result.Placeholder
+		require.NotNil(t, p)
+		require.Len(t, p.BlurHash, 28)
+		require.True(t, strings.HasPrefix(p.BlurHash, "L"))
+
+		// the dominant color is close to red
+		require.Len(t, p.DominantColor, 7)
+		require.True(t, strings.HasPrefix(p.DominantColor, "#f"), p.DominantColor)
+
+		// the preview is a tiny JPEG image, small enough for the object metadata
+		data, ok := strings.CutPrefix(p.LQIP, "data:image/jpeg;base64,")
+		require.True(t, ok, p.LQIP)
+		require.Less(t, len(p.LQIP), 1500)
+		preview, err := base64.StdEncoding.DecodeString(data)
+		require.NoError(t, err)
+		cfg := decodeConfig(t, preview)
+		require.Equal(t, 16, cfg.Width)
+		require.Equal(t, 11, cfg.Height)
+
+		obj := s3Client.object("photo.jpg")
+		require.Equal(t, p.BlurHash, aws.StringValue(obj.metadata["blurhash"]))
+		require.Equal(t, p.LQIP, aws.StringValue(obj.metadata["lqip"]))
+		require.Equal(t, p.DominantColor, aws.StringValue(obj.metadata["dominant-color"]))
+
+		info, err := fm.Stat(context.Background(), "photo.jpg")
+		require.NoError(t, err)
+		require.Equal(t, p, info.Placeholder)
+	})
+
+	t.Run("transparent", func(t *testing.T) {
+		img := solidImage(40, 40, color.NRGBA{}, blue)
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader(encodePNG(t, img)), "logo.png", "image/png")
+		require.NoError(t, err)
+
+		// transparent pixels are ignored, and the preview keeps the transparency
+		require.Equal(t, "#0000ff", result.Placeholder.DominantColor)
+		data, ok := strings.CutPrefix(result.Placeholder.LQIP, "data:image/png;base64,")
+		require.True(t, ok, result.Placeholder.LQIP)
+		preview, err := base64.StdEncoding.DecodeString(data)
+		require.NoError(t, err)
+		decoded, err := png.Decode(bytes.NewReader(preview))
+		require.NoError(t, err)
+		_, _, _, a := decoded.At(0, 0).RGBA()
+		require.Zero(t, a)
+	})
+
+	t.Run("other types", func(t *testing.T) {
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader([]byte("hello")), "notes.txt", "text/plain")
+		require.NoError(t, err)
+		require.Nil(t, result.Placeholder)
+
+		info, err := fm.Stat(context.Background(), "notes.txt")
+		require.NoError(t, err)
+		require.Nil(t, info.Placeholder)
+	})
+}
@@ -0,0 +1,425 @@
+package filemanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"mime"
+)
+
+// Image metadata stripping modes, see ImageSanitizer.Strip.
+const (
+	// KeepMetadata keeps the image metadata as is.
+	KeepMetadata MetadataStrip = iota
+	// StripGPSMetadata removes the GPS location from the EXIF metadata and keeps the other metadata.
+	// The XMP metadata is removed as well, since it may contain a copy of the GPS location.
+	StripGPSMetadata
+	// StripAllMetadata removes the EXIF, XMP and IPTC metadata and comments.
+	// Color profiles are kept, since they affect how the image is displayed.
+	StripAllMetadata
+)
+
+// EXIF tags used by the sanitizer.
+const (
+	exifOrientationTag = 0x0112
+	exifGPSInfoTag     = 0x8825
+)
+
+type (
+	// MetadataStrip defines which metadata is removed from uploaded images.
+	MetadataStrip int
+
+	// ImageSanitizer cleans uploaded JPEG and PNG images before they are stored:
+	// it applies the EXIF orientation to the pixels, so images are displayed upright everywhere,
+	// and strips the metadata leaking private data, such as the location the photo was taken at.
+	// Other images, e.g. WebP, HEIC or TIFF, are not modified and keep their metadata;
+	// reject them with the content safety policy or WithUploadAllowedTypes if that's not acceptable.
+	ImageSanitizer struct {
+		// AutoOrient rotates and flips the image according to the EXIF orientation.
+		// The image is re-encoded then, and its EXIF orientation is reset.
+		AutoOrient bool
+		// Strip defines which metadata is removed. Defaults to KeepMetadata.
+		Strip MetadataStrip
+		// Quality is the quality of re-encoded JPEG images. Defaults to DefaultJPEGQuality.
+		Quality int
+	}
+
+	// jpegSegment is a marker segment of a JPEG file.
+	jpegSegment struct {
+		marker byte
+		data   []byte // the segment payload, without the marker and length
+	}
+)
+
+// Sanitize returns the sanitized image. Images other than JPEG and PNG are returned as is.
+func (s ImageSanitizer) Sanitize(data []byte, contentType string) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "image/jpeg":
+		return s.sanitizeJPEG(data)
+	case "image/png":
+		return s.sanitizePNG(data)
+	default:
+		return data, nil
+	}
+}
+
+// sanitizeJPEG sanitizes a JPEG image.
+// Metadata is stored in APPn and COM segments preceding the image data.
+func (s ImageSanitizer) sanitizeJPEG(data []byte) ([]byte, error) {
+	segments, imageData, err := parseJPEGSegments(data)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToProcessImage, err)
+	}
+
+	orientation := 1
+	kept := segments[:0:0]
+	for _, seg := range segments {
+		switch {
+		case seg.marker == 0xE1 && bytes.HasPrefix(seg.data, []byte("Exif\x00\x00")):
+			if s.Strip == StripAllMetadata {
+				if s.AutoOrient {
+					orientation = exifOrientation(seg.data[6:])
+				}
+				continue
+			}
+			seg.data = bytes.Clone(seg.data)
+			if s.AutoOrient {
+				orientation = exifOrientation(seg.data[6:])
+				setExifOrientation(seg.data[6:], 1)
+			}
+			if s.Strip == StripGPSMetadata {
+				stripExifGPS(seg.data[6:])
+			}
+		case s.Strip == StripAllMetadata && (seg.marker == 0xE1 || seg.marker == 0xED || seg.marker == 0xFE):
+			continue // XMP, IPTC and comments
+		case s.Strip == StripGPSMetadata && isXMPSegment(seg):
+			continue
+		}
+		kept = append(kept, seg)
+	}
+
+	if orientation > 1 && orientation <= 8 {
+		img, _, err := decodeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := encodeImage(orientImage(img, orientation), "jpeg", s.Quality)
+		if err != nil {
+			return nil, err
+		}
+
+		// keep the metadata segments, the tables and the image data come from the encoder,
+		// as well as the color transform, so the Adobe segment is dropped
+		metadata := kept[:0:0]
+		for _, seg := range kept {
+			if seg.marker >= 0xE0 && seg.marker <= 0xED || seg.marker == 0xFE {
+				metadata = append(metadata, seg)
+			}
+		}
+		return buildJPEG(metadata, encoded[2:]), nil
+	}
+
+	return buildJPEG(kept, imageData), nil
+}
+
+// sanitizePNG sanitizes a PNG image.
+// Metadata is stored in eXIf, text and time chunks.
+func (s ImageSanitizer) sanitizePNG(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errors.Join(ErrFailedToProcessImage, errors.New("invalid PNG signature"))
+	}
+
+	var (
+		out         = bytes.NewBuffer(make([]byte, 0, len(data)))
+		orientation = 1
+	)
+	out.WriteString(signature)
+	for rest := data[len(signature):]; len(rest) > 0; {
+		if len(rest) < 12 {
+			return nil, errors.Join(ErrFailedToProcessImage, errors.New("truncated PNG chunk"))
+		}
+		length := binary.BigEndian.Uint32(rest)
+		if uint64(length)+12 > uint64(len(rest)) {
+			return nil, errors.Join(ErrFailedToProcessImage, errors.New("truncated PNG chunk"))
+		}
+		chunkType, chunkData := string(rest[4:8]), rest[8:8+length]
+		rest = rest[12+length:]
+
+		switch chunkType {
+		case "eXIf":
+			if s.AutoOrient {
+				orientation = exifOrientation(chunkData)
+			}
+			if s.Strip == StripAllMetadata {
+				continue
+			}
+			chunkData = bytes.Clone(chunkData)
+			if s.AutoOrient {
+				setExifOrientation(chunkData, 1)
+			}
+			if s.Strip == StripGPSMetadata {
+				stripExifGPS(chunkData)
+			}
+		case "tEXt", "zTXt", "iTXt", "tIME":
+			if s.Strip == StripAllMetadata {
+				continue
+			}
+			// XMP is stored in a text chunk with the "XML:com.adobe.xmp" keyword
+			if s.Strip == StripGPSMetadata && chunkType != "tIME" && bytes.HasPrefix(chunkData, []byte("XML:com.adobe.xmp\x00")) {
+				continue
+			}
+		}
+		writePNGChunk(out, chunkType, chunkData)
+	}
+
+	if orientation > 1 && orientation <= 8 {
+		// re-encoding drops all ancillary chunks, including the metadata
+		img, _, err := decodeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		return encodeImage(orientImage(img, orientation), "png", 0)
+	}
+
+	return out.Bytes(), nil
+}
+
+// isXMPSegment checks if the JPEG segment contains XMP metadata, including the extended XMP.
+func isXMPSegment(seg jpegSegment) bool {
+	return seg.marker == 0xE1 && (bytes.HasPrefix(seg.data, []byte("http://ns.adobe.com/xap/1.0/\x00")) ||
+		bytes.HasPrefix(seg.data, []byte("http://ns.adobe.com/xmp/extension/\x00")))
+}
+
+// parseJPEGSegments returns the marker segments preceding the image data,
+// and the rest of the file starting with the start of scan marker.
+func parseJPEGSegments(data []byte) ([]jpegSegment, []byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil, errors.New("invalid JPEG signature")
+	}
+
+	var segments []jpegSegment
+	for i := 2; ; {
+		// skip fill bytes
+		for i < len(data) && data[i] == 0xFF && i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, nil, errors.New("invalid JPEG segment")
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan or end of image
+			return segments, data[i:], nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, nil, errors.New("truncated JPEG segment")
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[i+4 : i+2+length]})
+		i += 2 + length
+	}
+}
+
+// buildJPEG assembles a JPEG file from the segments and the image data.
+func buildJPEG(segments []jpegSegment, imageData []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	for _, seg := range segments {
+		buf.Write([]byte{0xFF, seg.marker})
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(seg.data)+2))
+		buf.Write(seg.data)
+	}
+	buf.Write(imageData)
+	return buf.Bytes()
+}
+
+// writePNGChunk writes a PNG chunk with its checksum.
+func writePNGChunk(buf *bytes.Buffer, chunkType string, data []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	buf.WriteString(chunkType)
+	buf.Write(data)
+	_ = binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// tiffIFD walks the IFD at the offset in the TIFF structure of EXIF metadata, calling fn with the offset of each entry.
+// Invalid structures are ignored.
+func tiffIFD(tiff []byte, offset uint32, fn func(order binary.ByteOrder, entry int)) {
+	order := tiffByteOrder(tiff)
+	if order == nil || uint64(offset)+2 > uint64(len(tiff)) {
+		return
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			return
+		}
+		fn(order, entry)
+	}
+}
+
+// tiffByteOrder returns the byte order of the TIFF structure, or nil if the header is invalid.
+func tiffByteOrder(tiff []byte) binary.ByteOrder {
+	if len(tiff) < 8 {
+		return nil
+	}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian
+	case "MM\x00*":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// exifOrientation returns the orientation stored in the EXIF metadata, or 1 if it's not set.
+func exifOrientation(tiff []byte) int {
+	orientation := 1
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return orientation
+	}
+	tiffIFD(tiff, order.Uint32(tiff[4:]), func(order binary.ByteOrder, entry int) {
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			orientation = int(order.Uint16(tiff[entry+8:]))
+		}
+	})
+	return orientation
+}
+
+// imageOrientation returns the EXIF orientation of a JPEG or PNG image, or 1 if it's not set.
+func imageOrientation(data []byte, format string) int {
+	switch format {
+	case "jpeg":
+		segments, _, err := parseJPEGSegments(data)
+		if err != nil {
+			return 1
+		}
+		for _, seg := range segments {
+			if seg.marker == 0xE1 && bytes.HasPrefix(seg.data, []byte("Exif\x00\x00")) {
+				return exifOrientation(seg.data[6:])
+			}
+		}
+	case "png":
+		// the eXIf chunk precedes the image data, the signature is checked by the decoder
+		for rest := data[min(8, len(data)):]; len(rest) >= 12; {
+			length := binary.BigEndian.Uint32(rest)
+			if uint64(length)+12 > uint64(len(rest)) || string(rest[4:8]) == "IDAT" {
+				break
+			}
+			if string(rest[4:8]) == "eXIf" {
+				return exifOrientation(rest[8 : 8+length])
+			}
+			rest = rest[12+length:]
+		}
+	}
+	return 1
+}
+
+// setExifOrientation updates the orientation stored in the EXIF metadata in place.
+func setExifOrientation(tiff []byte, orientation int) {
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return
+	}
+	tiffIFD(tiff, order.Uint32(tiff[4:]), func(order binary.ByteOrder, entry int) {
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			order.PutUint16(tiff[entry+8:], uint16(orientation))
+		}
+	})
+}
+
+// stripExifGPS erases the GPS information of the EXIF metadata in place:
+// the values of all GPS entries are zeroed and the GPS IFD is emptied.
+func stripExifGPS(tiff []byte) {
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return
+	}
+
+	gpsOffset := uint32(0)
+	tiffIFD(tiff, order.Uint32(tiff[4:]), func(order binary.ByteOrder, entry int) {
+		if order.Uint16(tiff[entry:]) == exifGPSInfoTag {
+			gpsOffset = order.Uint32(tiff[entry+8:])
+		}
+	})
+	if gpsOffset == 0 {
+		return
+	}
+
+	tiffIFD(tiff, gpsOffset, func(order binary.ByteOrder, entry int) {
+		size := uint64(tiffTypeSize(order.Uint16(tiff[entry+2:]))) * uint64(order.Uint32(tiff[entry+4:]))
+		if size > 4 {
+			if offset := uint64(order.Uint32(tiff[entry+8:])); offset+size <= uint64(len(tiff)) {
+				clear(tiff[offset : offset+size])
+			}
+		}
+		clear(tiff[entry : entry+12])
+	})
+	// the offset comes from the file, the GPS IFD may be out of the metadata
+	if uint64(gpsOffset)+2 <= uint64(len(tiff)) {
+		order.PutUint16(tiff[gpsOffset:], 0)
+	}
+}
+
+// tiffTypeSize returns the size of a TIFF field type in bytes.
+func tiffTypeSize(fieldType uint16) int {
+	switch fieldType {
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	default: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	}
+}
+
+// orientImage transforms the image according to the EXIF orientation, so it's displayed upright.
+func orientImage(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	// orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotate 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertically
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90 clockwise
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate 90 counterclockwise
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,205 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+// testExif returns EXIF metadata in the TIFF format with the orientation and a GPS latitude of 52°30'12.34".
+func testExif(orientation uint16) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8)
+
+	// IFD0: orientation and GPS IFD pointer
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = append(tiff, exifEntry(0x0112, 3, 1, uint32(orientation))...)
+	tiff = append(tiff, exifEntry(0x8825, 4, 1, 38)...)
+	tiff = le.AppendUint32(tiff, 0)
+
+	// GPS IFD: latitude reference and latitude
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = append(tiff, exifEntry(0x0001, 2, 2, uint32('N'))...)
+	tiff = append(tiff, exifEntry(0x0002, 5, 3, 68)...)
+	tiff = le.AppendUint32(tiff, 0)
+	for _, v := range []uint32{52, 1, 30, 1, 1234, 100} {
+		tiff = le.AppendUint32(tiff, v)
+	}
+	return tiff
+}
+
+// exifEntry returns a little-endian IFD entry.
+func exifEntry(tag, fieldType uint16, count, value uint32) []byte {
+	le := binary.LittleEndian
+	entry := le.AppendUint16(nil, tag)
+	entry = le.AppendUint16(entry, fieldType)
+	entry = le.AppendUint32(entry, count)
+	return le.AppendUint32(entry, value)
+}
+
+// jpegWithMetadata returns a JPEG image with EXIF, XMP and comment segments.
+// The left half of the image is red and the right half is blue.
+func jpegWithMetadata(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 20 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}))
+	encoded := buf.Bytes()
+
+	segment := func(marker byte, data []byte) []byte {
+		seg := []byte{0xFF, marker}
+		seg = binary.BigEndian.AppendUint16(seg, uint16(len(data)+2))
+		return append(seg, data...)
+	}
+	out := []byte{0xFF, 0xD8}
+	out = append(out, segment(0xE1, append([]byte("Exif\x00\x00"), testExif(orientation)...))...)
+	out = append(out, segment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"))...)
+	out = append(out, segment(0xFE, []byte("taken at home"))...)
+	return append(out, encoded[2:]...)
+}
+
+// gpsLatitude is the encoded GPS latitude value of testExif.
+var gpsLatitude = binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, 1234), 100)
+
+func TestImageSanitizer(t *testing.T) {
+	t.Run("strip GPS", func(t *testing.T) {
+		data := jpegWithMetadata(t, 1)
+		require.True(t, bytes.Contains(data, gpsLatitude))
+
+		out, err := filemanager.ImageSanitizer{Strip: filemanager.StripGPSMetadata}.Sanitize(data, "image/jpeg")
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(out, gpsLatitude))
+		// XMP may contain the GPS location as well
+		require.False(t, bytes.Contains(out, []byte("xmpmeta")))
+		require.True(t, bytes.Contains(out, []byte("Exif\x00\x00")))
+		require.True(t, bytes.Contains(out, []byte("taken at home")))
+
+		_, err = jpeg.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid GPS offset", func(t *testing.T) {
+		data := jpegWithMetadata(t, 1)
+		// point the GPS IFD of testExif out of the metadata
+		pointer := bytes.Index(data, []byte("Exif\x00\x00")) + 6 + 30
+		binary.LittleEndian.PutUint32(data[pointer:], 0xFFFFFF00)
+
+		out, err := filemanager.ImageSanitizer{Strip: filemanager.StripGPSMetadata}.Sanitize(data, "image/jpeg")
+		require.NoError(t, err)
+		_, err = jpeg.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+	})
+
+	t.Run("strip all", func(t *testing.T) {
+		out, err := filemanager.ImageSanitizer{Strip: filemanager.StripAllMetadata}.Sanitize(jpegWithMetadata(t, 1), "image/jpeg")
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(out, []byte("Exif")))
+		require.False(t, bytes.Contains(out, []byte("xmpmeta")))
+		require.False(t, bytes.Contains(out, []byte("taken at home")))
+
+		_, err = jpeg.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+	})
+
+	t.Run("auto orient", func(t *testing.T) {
+		out, err := filemanager.ImageSanitizer{AutoOrient: true, Strip: filemanager.StripGPSMetadata}.Sanitize(
+			jpegWithMetadata(t, 6), "image/jpeg",
+		)
+		require.NoError(t, err)
+
+		// rotated 90 degrees clockwise: the left half becomes the top half
+		img, err := jpeg.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+		r, _, b, _ := img.At(10, 5).RGBA()
+		require.Greater(t, r, b)
+		r, _, b, _ = img.At(10, 35).RGBA()
+		require.Greater(t, b, r)
+
+		// the orientation is reset, so viewers don't rotate the image again
+		require.True(t, bytes.Contains(out, exifEntry(0x0112, 3, 1, 1)))
+		require.False(t, bytes.Contains(out, gpsLatitude))
+	})
+
+	t.Run("png", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, testImage(10, 10)))
+		encoded := buf.Bytes()
+
+		chunk := func(chunkType string, data []byte) []byte {
+			c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+			c = append(c, chunkType...)
+			c = append(c, data...)
+			return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+		}
+		// insert the metadata chunks after the IHDR chunk
+		data := append([]byte{}, encoded[:33]...)
+		data = append(data, chunk("eXIf", testExif(1))...)
+		data = append(data, chunk("tEXt", []byte("Comment\x00taken at home"))...)
+		data = append(data, chunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))...)
+		data = append(data, encoded[33:]...)
+		_, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		out, err := filemanager.ImageSanitizer{Strip: filemanager.StripGPSMetadata}.Sanitize(data, "image/png")
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(out, gpsLatitude))
+		require.False(t, bytes.Contains(out, []byte("xmpmeta")))
+		require.True(t, bytes.Contains(out, []byte("taken at home")))
+		_, err = png.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+
+		out, err = filemanager.ImageSanitizer{Strip: filemanager.StripAllMetadata}.Sanitize(data, "image/png")
+		require.NoError(t, err)
+		require.Equal(t, encoded, out)
+	})
+
+	t.Run("other types", func(t *testing.T) {
+		data := []byte("GIF89a")
+		out, err := filemanager.ImageSanitizer{Strip: filemanager.StripAllMetadata}.Sanitize(data, "image/gif")
+		require.NoError(t, err)
+		require.Equal(t, data, out)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithImageSanitizer(&filemanager.ImageSanitizer{AutoOrient: true, Strip: filemanager.StripAllMetadata}),
+		)
+		require.NoError(t, err)
+
+		data := jpegWithMetadata(t, 8)
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader(data), "photo.jpg", "image/jpeg")
+		require.NoError(t, err)
+
+		stored := s3Client.object("photo.jpg").data
+		require.Equal(t, int64(len(stored)), result.Size)
+		require.False(t, bytes.Contains(stored, gpsLatitude))
+		cfg := decodeConfig(t, stored)
+		require.Equal(t, 20, cfg.Width)
+		require.Equal(t, 40, cfg.Height)
+	})
+}
@@ -0,0 +1,146 @@
+package filemanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"path"
+	"strings"
+)
+
+// Image resize modes, see ImageVariant.Mode.
+const (
+	// ResizeFit scales the image down to fit into the variant size, keeping the aspect ratio.
+	ResizeFit ResizeMode = iota
+	// ResizeFill scales the image to cover the variant size, keeping the aspect ratio,
+	// and crops the centered region of the variant size.
+	ResizeFill
+	// ResizeCrop crops the centered region of the variant size without scaling.
+	ResizeCrop
+)
+
+type (
+	// ResizeMode defines how an image is resized to the variant size.
+	ResizeMode int
+
+	// ImageVariant represents a resized copy of an uploaded image, e.g. a thumbnail.
+	ImageVariant struct {
+		// Name is the name of the variant, used in the variant key, e.g. "thumb".
+		Name string
+		// Width is the width of the variant in pixels.
+		Width int
+		// Height is the height of the variant in pixels.
+		Height int
+		// Mode defines how the image is resized. Defaults to ResizeFit.
+		Mode ResizeMode
+		// Quality is the quality of JPEG variants. Defaults to DefaultJPEGQuality.
+		Quality int
+	}
+)
+
+// String returns the name of the resize mode: "fit", "fill" or "crop".
+func (m ResizeMode) String() string {
+	switch m {
+	case ResizeFill:
+		return "fill"
+	case ResizeCrop:
+		return "crop"
+	default:
+		return "fit"
+	}
+}
+
+// parseResizeMode returns the resize mode by its name, an empty name is ResizeFit.
+func parseResizeMode(name string) (ResizeMode, error) {
+	switch name {
+	case "", "fit":
+		return ResizeFit, nil
+	case "fill":
+		return ResizeFill, nil
+	case "crop":
+		return ResizeCrop, nil
+	default:
+		return ResizeFit, fmt.Errorf("unknown resize mode %q", name)
+	}
+}
+
+// ImageVariantKey returns the object key of an image variant, stored next to the original image,
+// e.g. "photos/cat_thumb.jpg" for the "thumb" variant of "photos/cat.jpg".
+func ImageVariantKey(key, name string) string {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "_" + name + ext
+}
+
+// validate checks the variant settings.
+func (v ImageVariant) validate() error {
+	if v.Name == "" || strings.ContainsAny(v.Name, "/_") {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidImageVariant, v.Name)
+	}
+	if v.Width <= 0 || v.Height <= 0 {
+		return fmt.Errorf("%w: invalid size %dx%d of %q", ErrInvalidImageVariant, v.Width, v.Height, v.Name)
+	}
+	if v.Mode < ResizeFit || v.Mode > ResizeCrop {
+		return fmt.Errorf("%w: invalid resize mode of %q", ErrInvalidImageVariant, v.Name)
+	}
+	return nil
+}
+
+// resizeImage resizes the image according to the mode.
+// Images are never upscaled, an image smaller than the size is returned as is by ResizeFit
+// and covers as much of the size as possible with ResizeFill and ResizeCrop.
+func resizeImage(img image.Image, width, height int, mode ResizeMode) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	switch mode {
+	case ResizeFill:
+		// scale to cover the size, then crop the overflow
+		scale := max(float64(width)/float64(w), float64(height)/float64(h))
+		if scale < 1 {
+			img = scaleImage(img, max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5)))
+		}
+		return cropImage(img, width, height)
+	case ResizeCrop:
+		return cropImage(img, width, height)
+	default:
+		scale := min(float64(width)/float64(w), float64(height)/float64(h))
+		if scale >= 1 {
+			return img
+		}
+		return scaleImage(img, max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5)))
+	}
+}
+
+// uploadImageVariants generates the configured variants of the image and uploads them next to the original image.
+// The variants are encoded in the format of the original image and uploaded with the same options.
+// It returns the URLs of the uploaded variants by variant names.
+func (fm *FileManager) uploadImageVariants(
+	ctx context.Context,
+	img image.Image,
+	format, key, contentType string,
+	opts []UploadOption,
+) (map[string]string, error) {
+	urls := make(map[string]string, len(fm.imageVariants))
+	for _, v := range fm.imageVariants {
+		data, err := encodeImage(resizeImage(img, v.Width, v.Height, v.Mode), format, v.Quality)
+		if err != nil {
+			return nil, err
+		}
+		variantOpts := opts
+		if fm.imageInfo {
+			info, err := readImageInfo(data)
+			if err != nil {
+				return nil, err
+			}
+			variantOpts = append(opts[:len(opts):len(opts)], WithObjectMetadata(info.metadata()))
+		}
+		url, err := fm.putObject(ctx, bytes.NewReader(data), ImageVariantKey(key, v.Name), contentType, variantOpts...)
+		if err != nil {
+			return nil, errors.Join(ErrFailedToProcessImage, err)
+		}
+		urls[v.Name] = url
+	}
+	return urls, nil
+}
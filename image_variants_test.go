@@ -0,0 +1,186 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+// testImage returns an image of the given size with a horizontal gradient.
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// encodePNG encodes the image as PNG.
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// decodeConfig decodes the dimensions of an encoded image.
+func decodeConfig(t *testing.T, data []byte) image.Config {
+	t.Helper()
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestImageVariantKey(t *testing.T) {
+	require.Equal(t, "photos/cat_thumb.jpg", filemanager.ImageVariantKey("photos/cat.jpg", "thumb"))
+	require.Equal(t, "avatar_small", filemanager.ImageVariantKey("avatar", "small"))
+}
+
+func TestUploadImage(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+		filemanager.WithImageVariants(
+			filemanager.ImageVariant{Name: "thumb", Width: 100, Height: 100, Mode: filemanager.ResizeFill},
+			filemanager.ImageVariant{Name: "small", Width: 200, Height: 200},
+			filemanager.ImageVariant{Name: "center", Width: 50, Height: 60, Mode: filemanager.ResizeCrop},
+			filemanager.ImageVariant{Name: "large", Width: 1000, Height: 1000},
+		),
+	)
+	require.NoError(t, err)
+
+	t.Run("invalid variant", func(t *testing.T) {
+		_, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithImageVariants(filemanager.ImageVariant{Name: "thumb"}),
+		)
+		require.ErrorIs(t, err, filemanager.ErrInvalidImageVariant)
+	})
+
+	t.Run("png", func(t *testing.T) {
+		data := encodePNG(t, testImage(400, 200))
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader(data), "photos/cat.png", "image/png")
+		require.NoError(t, err)
+		require.Equal(t, "https://cdn.example.com/uploads/photos/cat.png", result.URL)
+		require.Equal(t, int64(len(data)), result.Size)
+		require.Equal(t, map[string]string{
+			"thumb":  "https://cdn.example.com/uploads/photos/cat_thumb.png",
+			"small":  "https://cdn.example.com/uploads/photos/cat_small.png",
+			"center": "https://cdn.example.com/uploads/photos/cat_center.png",
+			"large":  "https://cdn.example.com/uploads/photos/cat_large.png",
+		}, result.Variants)
+
+		expected := map[string][2]int{
+			"photos/cat_thumb.png":  {100, 100},
+			"photos/cat_small.png":  {200, 100},
+			"photos/cat_center.png": {50, 60},
+			"photos/cat_large.png":  {400, 200}, // never upscaled
+		}
+		for key, size := range expected {
+			obj := s3Client.object(key)
+			require.NotNil(t, obj, key)
+			require.Equal(t, "image/png", obj.contentType)
+			cfg := decodeConfig(t, obj.data)
+			require.Equal(t, size, [2]int{cfg.Width, cfg.Height}, key)
+		}
+		require.Equal(t, data, s3Client.object("photos/cat.png").data)
+	})
+
+	t.Run("jpeg", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, jpeg.Encode(&buf, testImage(300, 600), nil))
+		url, err := fm.Upload(context.Background(), bytes.NewReader(buf.Bytes()), "portrait.jpg", "image/jpeg")
+		require.NoError(t, err)
+		require.Equal(t, "https://cdn.example.com/uploads/portrait.jpg", url)
+
+		cfg := decodeConfig(t, s3Client.object("portrait_small.jpg").data)
+		require.Equal(t, 100, cfg.Width)
+		require.Equal(t, 200, cfg.Height)
+	})
+
+	t.Run("exif orientation", func(t *testing.T) {
+		// variants are encoded without the metadata, so the orientation is applied to the pixels
+		_, err := fm.Upload(context.Background(), bytes.NewReader(jpegWithMetadata(t, 6)), "rotated.jpg", "image/jpeg")
+		require.NoError(t, err)
+
+		img, err := jpeg.Decode(bytes.NewReader(s3Client.object("rotated_small.jpg").data))
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+		// rotated 90 degrees clockwise: the left half becomes the top half
+		r, _, b, _ := img.At(10, 5).RGBA()
+		require.Greater(t, r, b)
+		r, _, b, _ = img.At(10, 35).RGBA()
+		require.Greater(t, b, r)
+	})
+
+	t.Run("trusted content", func(t *testing.T) {
+		// published and synced files are stored byte for byte, without variants
+		data := encodePNG(t, testImage(400, 200))
+		_, err := fm.Upload(context.Background(), bytes.NewReader(data), "assets/logo.png", "image/png", filemanager.WithTrustedContent())
+		require.NoError(t, err)
+		require.Equal(t, data, s3Client.object("assets/logo.png").data)
+		require.Nil(t, s3Client.object("assets/logo_thumb.png"))
+
+		_, err = fm.Publish(context.Background(), fstest.MapFS{"logo.png": {Data: data}}, "static", filemanager.PublishOptions{
+			NoHash: []string{"*"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, data, s3Client.object("static/logo.png").data)
+		require.Nil(t, s3Client.object("static/logo_thumb.png"))
+	})
+
+	t.Run("not an image", func(t *testing.T) {
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader([]byte("hello")), "notes.txt", "text/plain")
+		require.NoError(t, err)
+		require.Empty(t, result.Variants)
+		require.Equal(t, int64(5), result.Size)
+		require.Nil(t, s3Client.object("notes_thumb.txt"))
+	})
+
+	t.Run("corrupted image", func(t *testing.T) {
+		_, err := fm.Upload(context.Background(), bytes.NewReader([]byte("not a png")), "broken.png", "image/png")
+		require.ErrorIs(t, err, filemanager.ErrFailedToProcessImage)
+		require.Nil(t, s3Client.object("broken.png"))
+	})
+
+	t.Run("multipart form", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		part, err := mw.CreatePart(map[string][]string{
+			"Content-Disposition": {`form-data; name="avatar"; filename="avatar.png"`},
+			"Content-Type":        {"image/png"},
+		})
+		require.NoError(t, err)
+		_, err = part.Write(encodePNG(t, testImage(120, 120)))
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		result, err := fm.UploadAllFromMultipartForm(req)
+		require.NoError(t, err)
+		require.Len(t, result.Files, 1)
+		require.NoError(t, result.Files[0].Error)
+		require.Equal(t, "https://cdn.example.com/uploads/avatar_thumb.png", result.Files[0].Variants["thumb"])
+		require.NotNil(t, s3Client.object("avatar_thumb.png"))
+	})
+}
@@ -0,0 +1,218 @@
+package filemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"mime"
+	"path"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Watermark positions, see Watermark.Position.
+const (
+	WatermarkBottomRight WatermarkPosition = iota
+	WatermarkBottomLeft
+	WatermarkTopRight
+	WatermarkTopLeft
+	WatermarkCenter
+)
+
+// Default settings of the watermark.
+const (
+	DefaultWatermarkOpacity = 0.5
+	DefaultWatermarkMargin  = 16
+)
+
+type (
+	// WatermarkPosition defines where the watermark is placed on the image.
+	WatermarkPosition int
+
+	// Watermark overlays an image or a text onto uploaded JPEG and PNG images, e.g. to publish watermarked previews.
+	// Other images are not modified.
+	//
+	// The watermarked image is re-encoded without the metadata, e.g. the EXIF and XMP metadata with the GPS location,
+	// the JPEG color profile is kept; the EXIF orientation is applied to the pixels first,
+	// so the watermark is placed on the upright image.
+	Watermark struct {
+		// Image is the watermark image, e.g. a PNG logo with transparency decoded with png.Decode.
+		// If it's not set, the Text is rendered instead.
+		Image image.Image
+		// Text is the watermark text, rendered with the Face in the Color.
+		Text string
+		// Face is the font face of the text. Defaults to basicfont.Face7x13.
+		Face font.Face
+		// Color is the text color. Defaults to white.
+		Color color.Color
+		// Position is the position of the watermark. Defaults to WatermarkBottomRight.
+		Position WatermarkPosition
+		// Margin is the distance between the watermark and the image edges, in pixels.
+		// Defaults to DefaultWatermarkMargin, a negative value places the watermark at the edges.
+		Margin int
+		// Opacity is the opacity of the watermark, from 0 to 1. Defaults to DefaultWatermarkOpacity.
+		Opacity float64
+		// Scale is the watermark width relative to the image width, e.g. 0.2 for a fifth of the image.
+		// The watermark is drawn in its own size if it's not set.
+		Scale float64
+		// Quality is the quality of watermarked JPEG images. Defaults to DefaultJPEGQuality.
+		Quality int
+		// OriginalPrefix keeps the original image under the prefix with the private ACL, if set,
+		// e.g. the original of "photos/cat.jpg" is stored as "originals/photos/cat.jpg" with the "originals" prefix.
+		OriginalPrefix string
+	}
+)
+
+// Apply returns the watermarked image. Images other than JPEG and PNG are returned as is.
+func (w *Watermark) Apply(data []byte, contentType string) ([]byte, error) {
+	if !isWatermarkableImage(contentType) {
+		return data, nil
+	}
+	if err := w.validate(); err != nil {
+		return nil, errors.Join(ErrFailedToProcessImage, err)
+	}
+
+	img, format, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	if format != "jpeg" {
+		return encodeImage(w.draw(img), format, w.Quality)
+	}
+
+	// the metadata, e.g. the EXIF and XMP metadata with the GPS location, is dropped,
+	// only the JFIF header and the color profile are kept, the tables and the image data come from the encoder
+	segments, _, err := parseJPEGSegments(data)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToProcessImage, err)
+	}
+	kept := segments[:0:0]
+	for _, seg := range segments {
+		if seg.marker == 0xE0 || seg.marker == 0xE2 && bytes.HasPrefix(seg.data, []byte("ICC_PROFILE\x00")) {
+			kept = append(kept, seg)
+		}
+	}
+	// the watermark is drawn upright, so the orientation is applied to the pixels
+	orientation := imageOrientation(data, format)
+	if orientation > 1 && orientation <= 8 {
+		img = orientImage(img, orientation)
+	}
+
+	encoded, err := encodeImage(w.draw(img), format, w.Quality)
+	if err != nil {
+		return nil, err
+	}
+	return buildJPEG(kept, encoded[2:]), nil
+}
+
+// validate checks if the watermark is valid.
+func (w *Watermark) validate() error {
+	if w.Image == nil && w.Text == "" {
+		return fmt.Errorf("%w: image or text is required", ErrInvalidWatermark)
+	}
+	if w.Position < WatermarkBottomRight || w.Position > WatermarkCenter {
+		return fmt.Errorf("%w: invalid position", ErrInvalidWatermark)
+	}
+	return nil
+}
+
+// originalKey returns the key the original image is kept under, or an empty string if originals are not kept.
+func (w *Watermark) originalKey(key string) string {
+	if w.OriginalPrefix == "" {
+		return ""
+	}
+	return path.Join(w.OriginalPrefix, key)
+}
+
+// draw draws the watermark onto a copy of the image.
+func (w *Watermark) draw(img image.Image) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	mark := w.Image
+	if mark == nil {
+		mark = w.renderText()
+	}
+	if w.Scale > 0 {
+		mb := mark.Bounds()
+		width := max(1, int(float64(dst.Bounds().Dx())*w.Scale+0.5))
+		height := max(1, mb.Dy()*width/mb.Dx())
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), mark, mb, xdraw.Src, nil)
+		mark = scaled
+	}
+
+	opacity := w.Opacity
+	if opacity <= 0 || opacity > 1 {
+		opacity = DefaultWatermarkOpacity
+	}
+	mask := image.NewUniform(color.Alpha{A: uint8(opacity*255 + 0.5)})
+	r := w.position(dst.Bounds(), mark.Bounds().Size())
+	draw.DrawMask(dst, r, mark, mark.Bounds().Min, mask, image.Point{}, draw.Over)
+	return dst
+}
+
+// position returns the rectangle of the watermark of the size on the image.
+func (w *Watermark) position(bounds image.Rectangle, size image.Point) image.Rectangle {
+	margin := w.Margin
+	if margin == 0 {
+		margin = DefaultWatermarkMargin
+	}
+	margin = max(margin, 0)
+
+	left, top := bounds.Min.X+margin, bounds.Min.Y+margin
+	right, bottom := bounds.Max.X-margin-size.X, bounds.Max.Y-margin-size.Y
+
+	var p image.Point
+	switch w.Position {
+	case WatermarkTopLeft:
+		p = image.Pt(left, top)
+	case WatermarkTopRight:
+		p = image.Pt(right, top)
+	case WatermarkBottomLeft:
+		p = image.Pt(left, bottom)
+	case WatermarkCenter:
+		p = image.Pt(bounds.Min.X+(bounds.Dx()-size.X)/2, bounds.Min.Y+(bounds.Dy()-size.Y)/2)
+	default:
+		p = image.Pt(right, bottom)
+	}
+	return image.Rectangle{Min: p, Max: p.Add(size)}
+}
+
+// renderText renders the watermark text onto a transparent image of the text size.
+func (w *Watermark) renderText() image.Image {
+	face := w.Face
+	if face == nil {
+		face = basicfont.Face7x13
+	}
+	c := w.Color
+	if c == nil {
+		c = color.White
+	}
+
+	metrics := face.Metrics()
+	width := font.MeasureString(face, w.Text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	img := image.NewRGBA(image.Rect(0, 0, max(width, 1), max(height, 1)))
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+	d.DrawString(w.Text)
+	return img
+}
+
+// isWatermarkableImage checks if the content type is an image the watermark is applied to.
+func isWatermarkableImage(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "image/jpeg" || mediaType == "image/png"
+}
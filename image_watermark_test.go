@@ -0,0 +1,167 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestWatermark(t *testing.T) {
+	black, red := color.NRGBA{A: 255}, color.NRGBA{R: 255, A: 255}
+
+	t.Run("image", func(t *testing.T) {
+		w := &filemanager.Watermark{
+			Image:    solidImage(10, 10, red, red),
+			Position: filemanager.WatermarkCenter,
+			Opacity:  1,
+		}
+		out, err := w.Apply(encodePNG(t, solidImage(100, 50, black, black)), "image/png")
+		require.NoError(t, err)
+
+		img, err := png.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 100, 50), img.Bounds())
+		requireColor(t, red, img.At(50, 25))
+		requireColor(t, red, img.At(45, 20))
+		requireColor(t, black, img.At(44, 25))
+		requireColor(t, black, img.At(55, 25))
+	})
+
+	t.Run("opacity and position", func(t *testing.T) {
+		w := &filemanager.Watermark{
+			Image:    solidImage(10, 10, red, red),
+			Position: filemanager.WatermarkTopLeft,
+			Margin:   5,
+		}
+		out, err := w.Apply(encodePNG(t, solidImage(100, 50, black, black)), "image/png")
+		require.NoError(t, err)
+
+		img, err := png.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+		requireColor(t, color.NRGBA{R: 128, A: 255}, img.At(5, 5))
+		requireColor(t, black, img.At(4, 4))
+		requireColor(t, black, img.At(15, 15))
+	})
+
+	t.Run("text", func(t *testing.T) {
+		w := &filemanager.Watermark{Text: "© example.com", Margin: -1}
+		out, err := w.Apply(encodePNG(t, solidImage(200, 100, black, black)), "image/png")
+		require.NoError(t, err)
+
+		img, err := png.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+
+		// the text is drawn in the bottom right corner only
+		var lit image.Rectangle
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 200; x++ {
+				if r, _, _, _ := img.At(x, y).RGBA(); r > 0 {
+					lit = lit.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		require.False(t, lit.Empty())
+		require.Greater(t, lit.Min.X, 100)
+		require.Greater(t, lit.Min.Y, 80)
+	})
+
+	t.Run("exif orientation", func(t *testing.T) {
+		green := color.NRGBA{G: 255, A: 255}
+		w := &filemanager.Watermark{
+			Image:    solidImage(4, 4, green, green),
+			Position: filemanager.WatermarkBottomRight,
+			Margin:   -1,
+			Opacity:  1,
+			Quality:  95,
+		}
+		out, err := w.Apply(jpegWithMetadata(t, 6), "image/jpeg")
+		require.NoError(t, err)
+
+		// the watermark is drawn in the bottom right corner of the upright image
+		img, err := jpeg.Decode(bytes.NewReader(out))
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+		r, g, b, _ := img.At(18, 38).RGBA()
+		require.Greater(t, g, max(r, b))
+		r, _, b, _ = img.At(10, 5).RGBA()
+		require.Greater(t, r, b)
+
+		// the metadata is dropped, so viewers don't rotate the image again
+		require.False(t, bytes.Contains(out, []byte("Exif\x00\x00")))
+	})
+
+	t.Run("other types", func(t *testing.T) {
+		data := []byte("GIF89a")
+		out, err := (&filemanager.Watermark{Text: "example.com"}).Apply(data, "image/gif")
+		require.NoError(t, err)
+		require.Equal(t, data, out)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(newMemoryS3Client()),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithWatermark(&filemanager.Watermark{}),
+		)
+		require.ErrorIs(t, err, filemanager.ErrInvalidWatermark)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithWatermark(&filemanager.Watermark{Text: "example.com", OriginalPrefix: "originals"}),
+			filemanager.WithImageVariants(filemanager.ImageVariant{Name: "thumb", Width: 100, Height: 100}),
+		)
+		require.NoError(t, err)
+
+		data := jpegWithMetadata(t, 1)
+		result, err := fm.UploadImage(context.Background(), bytes.NewReader(data), "photos/item.jpg", "image/jpeg")
+		require.NoError(t, err)
+		require.Equal(t, "originals/photos/item.jpg", result.OriginalKey)
+
+		// the original is kept private
+		original := s3Client.object("originals/photos/item.jpg")
+		require.NotNil(t, original)
+		require.Equal(t, "private", original.acl)
+		require.Equal(t, data, original.data)
+
+		// the public image is watermarked, without the metadata
+		public := s3Client.object("photos/item.jpg")
+		require.Equal(t, filemanager.DefaultACL, public.acl)
+		require.NotEqual(t, data, public.data)
+		require.Equal(t, int64(len(public.data)), result.Size)
+		require.False(t, bytes.Contains(public.data, gpsLatitude))
+		require.False(t, bytes.Contains(public.data, []byte("xmpmeta")))
+		require.False(t, bytes.Contains(public.data, []byte("taken at home")))
+		cfg := decodeConfig(t, public.data)
+		require.Equal(t, 40, cfg.Width)
+		require.Contains(t, result.Variants, "thumb")
+
+		// other files are uploaded as is
+		_, err = fm.UploadImage(context.Background(), bytes.NewReader([]byte("hello")), "notes.txt", "text/plain")
+		require.NoError(t, err)
+		require.Nil(t, s3Client.object("originals/notes.txt"))
+	})
+}
+
+// requireColor asserts that the colors are equal, within a small tolerance.
+func requireColor(t *testing.T, want, got color.Color) {
+	t.Helper()
+	wr, wg, wb, wa := want.RGBA()
+	gr, gg, gb, ga := got.RGBA()
+	for i, d := range []int{int(wr) - int(gr), int(wg) - int(gg), int(wb) - int(gb), int(wa) - int(ga)} {
+		require.LessOrEqual(t, max(d, -d), 0x200, "channel %d: want %v, got %v", i, want, got)
+	}
+}
@@ -0,0 +1,429 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// memoryS3Client is an in-memory implementation of the S3Client interface.
+// It's used to test flows consisting of many requests, where mocks would be too verbose.
+type memoryS3Client struct {
+	mu      sync.Mutex
+	objects map[string]*memoryObject
+	uploads map[string]*memoryUpload
+	nextID  int
+}
+
+type memoryObject struct {
+	data               []byte
+	contentType        string
+	contentEncoding    string
+	contentDisposition string
+	cacheControl       string
+	acl                string
+	metadata           map[string]*string
+	lastModified       time.Time
+}
+
+type memoryUpload struct {
+	key                string
+	contentType        string
+	contentDisposition string
+	acl                string
+	metadata           map[string]*string
+	initiated          time.Time
+	parts              map[int64][]byte
+}
+
+func newMemoryS3Client() *memoryS3Client {
+	return &memoryS3Client{
+		objects: make(map[string]*memoryObject),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+// object returns the stored object, or nil if the object does not exist.
+func (m *memoryS3Client) object(key string) *memoryObject {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.objects[key]
+}
+
+func (m *memoryS3Client) PutObjectWithContext(
+	_ aws.Context,
+	input *s3.PutObjectInput,
+	_ ...request.Option,
+) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[aws.StringValue(input.Key)] = &memoryObject{
+		data:               data,
+		contentType:        aws.StringValue(input.ContentType),
+		contentEncoding:    aws.StringValue(input.ContentEncoding),
+		contentDisposition: aws.StringValue(input.ContentDisposition),
+		cacheControl:       aws.StringValue(input.CacheControl),
+		acl:                aws.StringValue(input.ACL),
+		metadata:           input.Metadata,
+		lastModified:       time.Now().UTC().Truncate(time.Second),
+	}
+	return &s3.PutObjectOutput{ETag: aws.String(etag(data))}, nil
+}
+
+func (m *memoryS3Client) ListObjectsV2WithContext(
+	_ aws.Context,
+	input *s3.ListObjectsV2Input,
+	_ ...request.Option,
+) (*s3.ListObjectsV2Output, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := aws.StringValue(input.Prefix)
+	delimiter := aws.StringValue(input.Delimiter)
+
+	keys := make([]string, 0, len(m.objects))
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsV2Output{}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if !seen[common] {
+					seen[common] = true
+					out.CommonPrefixes = append(out.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(common)})
+				}
+				continue
+			}
+		}
+		obj := m.objects[key]
+		out.Contents = append(out.Contents, &s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(obj.data))),
+			ETag:         aws.String(etag(obj.data)),
+			LastModified: aws.Time(obj.lastModified),
+		})
+	}
+	out.KeyCount = aws.Int64(int64(len(out.Contents) + len(out.CommonPrefixes)))
+	return out, nil
+}
+
+func (m *memoryS3Client) HeadObjectWithContext(
+	_ aws.Context,
+	input *s3.HeadObjectInput,
+	_ ...request.Option,
+) (*s3.HeadObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength:      aws.Int64(int64(len(obj.data))),
+		ContentType:        aws.String(obj.contentType),
+		ContentEncoding:    aws.String(obj.contentEncoding),
+		ContentDisposition: aws.String(obj.contentDisposition),
+		CacheControl:       aws.String(obj.cacheControl),
+		ETag:               aws.String(etag(obj.data)),
+		LastModified:       aws.Time(obj.lastModified),
+		Metadata:           obj.metadata,
+	}, nil
+}
+
+func (m *memoryS3Client) GetObjectWithContext(
+	_ aws.Context,
+	input *s3.GetObjectInput,
+	_ ...request.Option,
+) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	if input.IfMatch != nil && aws.StringValue(input.IfMatch) != etag(obj.data) {
+		return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+
+	data := obj.data
+	if input.Range != nil {
+		var start, end int
+		if n, _ := fmt.Sscanf(aws.StringValue(input.Range), "bytes=%d-%d", &start, &end); n < 2 {
+			end = len(data) - 1
+		}
+		data = data[start:min(end+1, len(data))]
+	}
+
+	return &s3.GetObjectOutput{
+		Body:               io.NopCloser(bytes.NewReader(data)),
+		ContentLength:      aws.Int64(int64(len(data))),
+		ContentType:        aws.String(obj.contentType),
+		ContentEncoding:    aws.String(obj.contentEncoding),
+		ContentDisposition: aws.String(obj.contentDisposition),
+		CacheControl:       aws.String(obj.cacheControl),
+		ETag:               aws.String(etag(obj.data)),
+		LastModified:       aws.Time(obj.lastModified),
+		Metadata:           obj.metadata,
+	}, nil
+}
+
+func (m *memoryS3Client) DeleteObjectWithContext(
+	_ aws.Context,
+	input *s3.DeleteObjectInput,
+	_ ...request.Option,
+) (*s3.DeleteObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *memoryS3Client) CopyObjectWithContext(
+	_ aws.Context,
+	input *s3.CopyObjectInput,
+	_ ...request.Option,
+) (*s3.CopyObjectOutput, error) {
+	source, err := url.PathUnescape(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	_, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, ok := m.objects[srcKey]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	dst := *src
+	dst.data = bytes.Clone(src.data)
+	dst.acl = aws.StringValue(input.ACL)
+	if aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace {
+		dst.contentType = aws.StringValue(input.ContentType)
+		dst.contentEncoding = aws.StringValue(input.ContentEncoding)
+		dst.contentDisposition = aws.StringValue(input.ContentDisposition)
+		dst.cacheControl = aws.StringValue(input.CacheControl)
+		dst.metadata = input.Metadata
+	}
+	dst.lastModified = time.Now().UTC().Truncate(time.Second)
+	m.objects[aws.StringValue(input.Key)] = &dst
+	return &s3.CopyObjectOutput{CopyObjectResult: &s3.CopyObjectResult{ETag: aws.String(etag(dst.data))}}, nil
+}
+
+func (m *memoryS3Client) CreateMultipartUploadWithContext(
+	_ aws.Context,
+	input *s3.CreateMultipartUploadInput,
+	_ ...request.Option,
+) (*s3.CreateMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	id := fmt.Sprintf("upload-%d", m.nextID)
+	m.uploads[id] = &memoryUpload{
+		key:                aws.StringValue(input.Key),
+		contentType:        aws.StringValue(input.ContentType),
+		contentDisposition: aws.StringValue(input.ContentDisposition),
+		acl:                aws.StringValue(input.ACL),
+		metadata:           input.Metadata,
+		initiated:          time.Now().UTC(),
+		parts:              make(map[int64][]byte),
+	}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id), Key: input.Key}, nil
+}
+
+func (m *memoryS3Client) UploadPartWithContext(
+	_ aws.Context,
+	input *s3.UploadPartInput,
+	_ ...request.Option,
+) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[aws.StringValue(input.UploadId)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+	upload.parts[aws.Int64Value(input.PartNumber)] = data
+	return &s3.UploadPartOutput{ETag: aws.String(etag(data))}, nil
+}
+
+func (m *memoryS3Client) UploadPartCopyWithContext(
+	_ aws.Context,
+	input *s3.UploadPartCopyInput,
+	_ ...request.Option,
+) (*s3.UploadPartCopyOutput, error) {
+	source, err := url.PathUnescape(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	_, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	var start, end int
+	if _, err := fmt.Sscanf(aws.StringValue(input.CopySourceRange), "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, ok := m.objects[srcKey]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	upload, ok := m.uploads[aws.StringValue(input.UploadId)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+	data := bytes.Clone(src.data[start:min(end+1, len(src.data))])
+	upload.parts[aws.Int64Value(input.PartNumber)] = data
+	return &s3.UploadPartCopyOutput{CopyPartResult: &s3.CopyPartResult{ETag: aws.String(etag(data))}}, nil
+}
+
+func (m *memoryS3Client) CompleteMultipartUploadWithContext(
+	_ aws.Context,
+	input *s3.CompleteMultipartUploadInput,
+	_ ...request.Option,
+) (*s3.CompleteMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[aws.StringValue(input.UploadId)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+
+	var data []byte
+	for _, part := range input.MultipartUpload.Parts {
+		partData, ok := upload.parts[aws.Int64Value(part.PartNumber)]
+		if !ok || etag(partData) != aws.StringValue(part.ETag) {
+			return nil, awserr.New("InvalidPart", "One or more of the specified parts could not be found.", nil)
+		}
+		data = append(data, partData...)
+	}
+
+	m.objects[upload.key] = &memoryObject{
+		data:               data,
+		contentType:        upload.contentType,
+		contentDisposition: upload.contentDisposition,
+		acl:                upload.acl,
+		metadata:           upload.metadata,
+		lastModified:       time.Now().UTC().Truncate(time.Second),
+	}
+	delete(m.uploads, aws.StringValue(input.UploadId))
+	return &s3.CompleteMultipartUploadOutput{Key: aws.String(upload.key)}, nil
+}
+
+func (m *memoryS3Client) AbortMultipartUploadWithContext(
+	_ aws.Context,
+	input *s3.AbortMultipartUploadInput,
+	_ ...request.Option,
+) (*s3.AbortMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.uploads[aws.StringValue(input.UploadId)]; !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+	delete(m.uploads, aws.StringValue(input.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (m *memoryS3Client) ListMultipartUploadsWithContext(
+	_ aws.Context,
+	input *s3.ListMultipartUploadsInput,
+	_ ...request.Option,
+) (*s3.ListMultipartUploadsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := &s3.ListMultipartUploadsOutput{}
+	for id, upload := range m.uploads {
+		if !strings.HasPrefix(upload.key, aws.StringValue(input.Prefix)) {
+			continue
+		}
+		out.Uploads = append(out.Uploads, &s3.MultipartUpload{
+			Key:       aws.String(upload.key),
+			UploadId:  aws.String(id),
+			Initiated: aws.Time(upload.initiated),
+		})
+	}
+	return out, nil
+}
+
+func (m *memoryS3Client) ListPartsWithContext(
+	_ aws.Context,
+	input *s3.ListPartsInput,
+	_ ...request.Option,
+) (*s3.ListPartsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[aws.StringValue(input.UploadId)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+
+	out := &s3.ListPartsOutput{}
+	for number, data := range upload.parts {
+		out.Parts = append(out.Parts, &s3.Part{
+			PartNumber: aws.Int64(number),
+			Size:       aws.Int64(int64(len(data))),
+			ETag:       aws.String(etag(data)),
+		})
+	}
+	sort.Slice(out.Parts, func(i, j int) bool {
+		return aws.Int64Value(out.Parts[i].PartNumber) < aws.Int64Value(out.Parts[j].PartNumber)
+	})
+	return out, nil
+}
+
+func (m *memoryS3Client) UploadPartRequest(input *s3.UploadPartInput) (*request.Request, *s3.UploadPartOutput) {
+	// presigning doesn't send any requests, so a real client is used
+	return s3.New(session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("key", "secret", ""),
+		Endpoint:    aws.String("https://s3.example.com"),
+		Region:      aws.String("us-east-1"),
+	}))).UploadPartRequest(input)
+}
+
+// etag returns the ETag of the data, as computed by S3 for single part uploads.
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,340 @@
+package filemanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+// IncompleteUpload represents a multipart upload that was started but neither completed nor aborted.
+type IncompleteUpload struct {
+	// Key is the object key of the upload.
+	Key string
+	// UploadID is the ID of the multipart upload.
+	UploadID string
+	// Initiated is the time the upload was started.
+	Initiated time.Time
+}
+
+// UploadStream uploads a file from a reader to the S3 bucket using S3 multipart upload.
+// Unlike Upload, it does not require the reader to be seekable and is not limited to 5GB,
+// the file is read and uploaded part by part, so only a few parts are kept in memory at once.
+// Parts are uploaded in parallel, limited by the configured part concurrency.
+// If any part fails to upload, the multipart upload is aborted.
+// If a scanner is configured, the file is scanned while it's uploaded, and the upload is aborted if the file is infected.
+// The file is not processed like with Upload: SVG images are stored as attachments instead of being sanitized,
+// and images are not sanitized, watermarked, resized to variants or checked against the image limits.
+// Upload options may be used to set additional attributes of the uploaded file.
+// It returns the URL of the uploaded file and any error encountered during the upload process.
+func (fm *FileManager) UploadStream(ctx context.Context, r io.Reader, filename, contentType string, opts ...UploadOption) (string, error) {
+	contentType, opts, err := fm.streamedContent(contentType, opts)
+	if err != nil {
+		return "", errors.Join(ErrFailedToUploadFile, err)
+	}
+	o := newUploadOptions(opts)
+
+	// start multipart upload
+	resp, err := fm.s3.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		ACL:                aws.String(o.acl),
+		CacheControl:       stringOrNil(o.cacheControl),
+		ContentDisposition: stringOrNil(o.contentDisposition),
+		ContentEncoding:    stringOrNil(o.contentEncoding),
+		ContentType:        aws.String(contentType),
+		Bucket:             aws.String(fm.bucket),
+		Key:                aws.String(filename),
+		Metadata:           metadataOrNil(o.metadata),
+	})
+	if err != nil {
+		return "", errors.Join(ErrFailedToUploadFile, err)
+	}
+
+	// the file is scanned while it's uploaded, and committed once it's clean
+	waitScan := func(error) error { return nil }
+	if fm.scanner != nil && !o.trusted && !o.noScan {
+		r, waitScan = fm.scanStream(ctx, r, filename)
+	}
+
+	// upload parts
+	parts, err := fm.uploadParts(ctx, r, filename, aws.StringValue(resp.UploadId))
+	if scanErr := waitScan(err); err == nil {
+		err = scanErr
+	}
+	if err != nil {
+		fm.abortMultipartUpload(ctx, filename, aws.StringValue(resp.UploadId))
+		return "", errors.Join(ErrFailedToUploadFile, err)
+	}
+
+	// complete multipart upload
+	if _, err := fm.s3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(fm.bucket),
+		Key:             aws.String(filename),
+		UploadId:        resp.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		fm.abortMultipartUpload(ctx, filename, aws.StringValue(resp.UploadId))
+		return "", errors.Join(ErrFailedToUploadFile, err)
+	}
+
+	return fm.fileAbsolutePath(filename), nil
+}
+
+// uploadParts reads the reader part by part and uploads each part in parallel.
+// It returns the list of uploaded parts sorted by part number.
+func (fm *FileManager) uploadParts(ctx context.Context, r io.Reader, key, uploadID string) ([]*s3.CompletedPart, error) {
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(fm.partWorkers)
+
+	var (
+		mu      sync.Mutex
+		parts   []*s3.CompletedPart
+		readErr error
+	)
+
+	for partNumber := int64(1); ; partNumber++ {
+		if partNumber > MaxUploadParts {
+			// the stream may end right after the last allowed part
+			n, err := io.ReadFull(r, make([]byte, 1))
+			switch {
+			case n > 0:
+				readErr = ErrTooManyParts
+			case !errors.Is(err, io.EOF):
+				readErr = err
+			}
+			break
+		}
+		if egCtx.Err() != nil {
+			break // one of the parts failed, stop reading
+		}
+
+		// read next part
+		buf := make([]byte, fm.partSize)
+		n, err := io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			readErr = err
+			break
+		}
+		// S3 requires at least one part, even if the file is empty
+		if n == 0 && partNumber > 1 {
+			break
+		}
+
+		eg.Go(func() error {
+			part, err := fm.uploadPart(egCtx, key, uploadID, partNumber, buf[:n])
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			parts = append(parts, part)
+			mu.Unlock()
+			return nil
+		})
+
+		if n < len(buf) {
+			break // last part
+		}
+	}
+
+	if err := errors.Join(readErr, eg.Wait()); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+
+	return parts, nil
+}
+
+// uploadPart uploads a single part of a multipart upload.
+func (fm *FileManager) uploadPart(ctx context.Context, key, uploadID string, partNumber int64, data []byte) (*s3.CompletedPart, error) {
+	resp, err := fm.s3.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Body:          bytes.NewReader(data),
+		Bucket:        aws.String(fm.bucket),
+		Key:           aws.String(key),
+		PartNumber:    aws.Int64(partNumber),
+		UploadId:      aws.String(uploadID),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.CompletedPart{
+		ETag:       resp.ETag,
+		PartNumber: aws.Int64(partNumber),
+	}, nil
+}
+
+// copyMultipart copies the object within the bucket in parts, since objects larger than 5GB
+// can't be copied with a single request. The copy gets the content type of the object and the attributes of the options.
+// Parts are copied in parallel, limited by the configured part concurrency.
+// If any part fails to copy, the multipart upload is aborted.
+func (fm *FileManager) copyMultipart(ctx context.Context, info *ObjectInfo, key string, o *uploadOptions) error {
+	resp, err := fm.s3.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		ACL:                aws.String(o.acl),
+		CacheControl:       stringOrNil(o.cacheControl),
+		ContentDisposition: stringOrNil(o.contentDisposition),
+		ContentEncoding:    stringOrNil(o.contentEncoding),
+		ContentType:        aws.String(info.ContentType),
+		Bucket:             aws.String(fm.bucket),
+		Key:                aws.String(key),
+		Metadata:           metadataOrNil(o.metadata),
+	})
+	if err != nil {
+		return err
+	}
+
+	source := &url.URL{Path: fm.bucket + "/" + info.Key}
+	parts := make([]*s3.CompletedPart, (info.Size+copyPartSize-1)/copyPartSize)
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(fm.partWorkers)
+	for i := range parts {
+		eg.Go(func() error {
+			start := int64(i) * copyPartSize
+			part, err := fm.s3.UploadPartCopyWithContext(egCtx, &s3.UploadPartCopyInput{
+				Bucket:          aws.String(fm.bucket),
+				CopySource:      aws.String(source.EscapedPath()),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, min(start+copyPartSize, info.Size)-1)),
+				Key:             aws.String(key),
+				PartNumber:      aws.Int64(int64(i + 1)),
+				UploadId:        resp.UploadId,
+			})
+			if err != nil {
+				return err
+			}
+			parts[i] = &s3.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int64(int64(i + 1))}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		fm.abortMultipartUpload(ctx, key, aws.StringValue(resp.UploadId))
+		return err
+	}
+
+	if _, err := fm.s3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(fm.bucket),
+		Key:             aws.String(key),
+		UploadId:        resp.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		fm.abortMultipartUpload(ctx, key, aws.StringValue(resp.UploadId))
+		return err
+	}
+	return nil
+}
+
+// abortMultipartUpload aborts a multipart upload, so the uploaded parts are removed from the storage.
+// The upload is aborted even if the context is canceled.
+func (fm *FileManager) abortMultipartUpload(ctx context.Context, key, uploadID string) {
+	if _, err := fm.s3.AbortMultipartUploadWithContext(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(fm.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}); err != nil {
+		slog.ErrorContext(ctx, "failed to abort multipart upload", "key", key, "upload_id", uploadID, "error", err)
+	}
+}
+
+// ListIncompleteUploads returns the multipart uploads whose keys start with the prefix that were started
+// but neither completed nor aborted. Parts of such uploads are invisible, but still stored in the bucket.
+// Uploads of the TusHandler are not returned, since clients resume them until they expire, see TusHandler.RemoveExpired.
+func (fm *FileManager) ListIncompleteUploads(ctx context.Context, prefix string) ([]IncompleteUpload, error) {
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(fm.bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var uploads []IncompleteUpload
+	for {
+		resp, err := fm.s3.ListMultipartUploadsWithContext(ctx, input)
+		if err != nil {
+			return nil, errors.Join(ErrFailedToListUploads, err)
+		}
+
+		for _, upload := range resp.Uploads {
+			uploads = append(uploads, IncompleteUpload{
+				Key:       aws.StringValue(upload.Key),
+				UploadID:  aws.StringValue(upload.UploadId),
+				Initiated: aws.TimeValue(upload.Initiated),
+			})
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			break
+		}
+		input.KeyMarker = resp.NextKeyMarker
+		input.UploadIdMarker = resp.NextUploadIdMarker
+	}
+
+	// the tus uploads are listed after the multipart uploads, so the uploads created in between are listed as well
+	tusUploads, err := fm.tusUploads(ctx)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToListUploads, err)
+	}
+	owned := make(map[string]bool, len(tusUploads))
+	for _, upload := range tusUploads {
+		owned[upload.UploadID] = true
+	}
+	uploads = slices.DeleteFunc(uploads, func(upload IncompleteUpload) bool {
+		return owned[upload.UploadID]
+	})
+
+	return uploads, nil
+}
+
+// AbortStaleUploads aborts the incomplete multipart uploads whose keys start with the prefix
+// that were started more than olderThan ago, so the storage used by their parts is reclaimed.
+// Uploads of the TusHandler are not aborted, see ListIncompleteUploads.
+// Uploads are aborted in parallel, limited by the configured upload concurrency.
+// It returns the number of aborted uploads.
+func (fm *FileManager) AbortStaleUploads(ctx context.Context, prefix string, olderThan time.Duration) (int, error) {
+	uploads, err := fm.ListIncompleteUploads(ctx, prefix)
+	if err != nil {
+		return 0, errors.Join(ErrFailedToAbortUploads, err)
+	}
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(fm.concurrency)
+
+	var aborted atomic.Int64
+	threshold := time.Now().Add(-olderThan)
+	for _, upload := range uploads {
+		if upload.Initiated.After(threshold) {
+			continue // upload may be still in progress
+		}
+
+		eg.Go(func() error {
+			if _, err := fm.s3.AbortMultipartUploadWithContext(egCtx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(fm.bucket),
+				Key:      aws.String(upload.Key),
+				UploadId: aws.String(upload.UploadID),
+			}); err != nil {
+				return err
+			}
+			aborted.Add(1)
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return int(aborted.Load()), errors.Join(ErrFailedToAbortUploads, err)
+	}
+
+	return int(aborted.Load()), nil
+}
@@ -0,0 +1,198 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestUploadStream(t *testing.T) {
+	bucket := "test-bucket"
+	cdn := "https://cdn.example.com"
+	filename := "large.bin"
+	uploadID := "upload-id"
+
+	// 2 full parts and a smaller last part
+	content := bytes.Repeat([]byte("a"), 2*filemanager.MinPartSize+100)
+
+	mockS3 := new(mockS3Client)
+	mockS3.On("CreateMultipartUploadWithContext", mock.Anything, &s3.CreateMultipartUploadInput{
+		ACL:         aws.String(defaultACL),
+		ContentType: aws.String("application/octet-stream"),
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(filename),
+	}, mock.Anything).Return(&s3.CreateMultipartUploadOutput{UploadId: aws.String(uploadID)}, nil)
+	for partNumber, size := range map[int64]int64{1: filemanager.MinPartSize, 2: filemanager.MinPartSize, 3: 100} {
+		mockS3.On("UploadPartWithContext", mock.Anything, mock.MatchedBy(func(in *s3.UploadPartInput) bool {
+			return aws.Int64Value(in.PartNumber) == partNumber && aws.Int64Value(in.ContentLength) == size
+		}), mock.Anything).Return(&s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", partNumber))}, nil).Once()
+	}
+	mockS3.On("CompleteMultipartUploadWithContext", mock.Anything, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(filename),
+		UploadId: aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{
+			{ETag: aws.String("etag-1"), PartNumber: aws.Int64(1)},
+			{ETag: aws.String("etag-2"), PartNumber: aws.Int64(2)},
+			{ETag: aws.String("etag-3"), PartNumber: aws.Int64(3)},
+		}},
+	}, mock.Anything).Return(&s3.CompleteMultipartUploadOutput{}, nil)
+
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(mockS3),
+		filemanager.WithBucketName(bucket),
+		filemanager.WithCDNURL(cdn),
+		filemanager.WithPartSize(filemanager.MinPartSize),
+	)
+	require.NoError(t, err)
+
+	url, err := fm.UploadStream(context.Background(), bytes.NewBuffer(content), filename, "application/octet-stream")
+	require.NoError(t, err)
+	require.Equal(t, "https://cdn.example.com/uploads/"+filename, url)
+
+	mockS3.AssertExpectations(t)
+}
+
+func TestUploadStream_AbortOnFailure(t *testing.T) {
+	bucket := "test-bucket"
+	filename := "large.bin"
+	uploadID := "upload-id"
+
+	mockS3 := new(mockS3Client)
+	mockS3.On("CreateMultipartUploadWithContext", mock.Anything, mock.Anything, mock.Anything).
+		Return(&s3.CreateMultipartUploadOutput{UploadId: aws.String(uploadID)}, nil)
+	mockS3.On("UploadPartWithContext", mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, errors.New("part failed"))
+	mockS3.On("AbortMultipartUploadWithContext", mock.Anything, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(filename),
+		UploadId: aws.String(uploadID),
+	}, mock.Anything).Return(&s3.AbortMultipartUploadOutput{}, nil).Once()
+
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(mockS3),
+		filemanager.WithBucketName(bucket),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	_, err = fm.UploadStream(context.Background(), bytes.NewBufferString("content"), filename, "text/plain")
+	require.ErrorIs(t, err, filemanager.ErrFailedToUploadFile)
+
+	mockS3.AssertExpectations(t)
+	mockS3.AssertNotCalled(t, "CompleteMultipartUploadWithContext", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	bucket := "test-bucket"
+	now := time.Now()
+
+	mockS3 := new(mockS3Client)
+	mockS3.On("ListMultipartUploadsWithContext", mock.Anything, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String("videos/"),
+	}, mock.Anything).Return(&s3.ListMultipartUploadsOutput{
+		Uploads: []*s3.MultipartUpload{
+			{Key: aws.String("videos/old.bin"), UploadId: aws.String("old"), Initiated: aws.Time(now.Add(-48 * time.Hour))},
+		},
+		IsTruncated:        aws.Bool(true),
+		NextKeyMarker:      aws.String("videos/old.bin"),
+		NextUploadIdMarker: aws.String("old"),
+	}, nil).Twice()
+	mockS3.On("ListMultipartUploadsWithContext", mock.Anything, &s3.ListMultipartUploadsInput{
+		Bucket:         aws.String(bucket),
+		Prefix:         aws.String("videos/"),
+		KeyMarker:      aws.String("videos/old.bin"),
+		UploadIdMarker: aws.String("old"),
+	}, mock.Anything).Return(&s3.ListMultipartUploadsOutput{
+		Uploads: []*s3.MultipartUpload{
+			{Key: aws.String("videos/new.bin"), UploadId: aws.String("new"), Initiated: aws.Time(now.Add(-time.Minute))},
+		},
+	}, nil).Twice()
+	mockS3.On("ListObjectsV2WithContext", mock.Anything, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(".tus/"),
+	}, mock.Anything).Return(&s3.ListObjectsV2Output{}, nil).Twice()
+	mockS3.On("AbortMultipartUploadWithContext", mock.Anything, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String("videos/old.bin"),
+		UploadId: aws.String("old"),
+	}, mock.Anything).Return(&s3.AbortMultipartUploadOutput{}, nil).Once()
+
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(mockS3),
+		filemanager.WithBucketName(bucket),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	uploads, err := fm.ListIncompleteUploads(context.Background(), "videos/")
+	require.NoError(t, err)
+	require.Len(t, uploads, 2)
+
+	aborted, err := fm.AbortStaleUploads(context.Background(), "videos/", 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, aborted)
+
+	mockS3.AssertExpectations(t)
+}
+
+func TestListIncompleteUploads(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for _, key := range []string{"videos/a.mp4", "other-app/b.mp4"} {
+		_, err := s3Client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String("test-bucket"),
+			Key:    aws.String(key),
+		})
+		require.NoError(t, err)
+	}
+
+	// uploads of the tus handler are resumed by clients until they expire
+	tus := filemanager.NewTusHandler(fm, "/files/")
+	req := httptest.NewRequest(http.MethodPost, "/files/", nil)
+	req.Header.Set("Tus-Resumable", filemanager.TusVersion)
+	req.Header.Set("Upload-Length", "100")
+	rec := httptest.NewRecorder()
+	tus.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	uploads, err := fm.ListIncompleteUploads(ctx, "videos/")
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	require.Equal(t, "videos/a.mp4", uploads[0].Key)
+
+	uploads, err = fm.ListIncompleteUploads(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, uploads, 2)
+
+	aborted, err := fm.AbortStaleUploads(ctx, "", 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, aborted)
+
+	req = httptest.NewRequest(http.MethodHead, rec.Header().Get("Location"), nil)
+	req.Header.Set("Tus-Resumable", filemanager.TusVersion)
+	rec = httptest.NewRecorder()
+	tus.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("Upload-Offset"))
+}
@@ -0,0 +1,213 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type (
+	// ObjectInfo represents the attributes of a file stored in the S3 bucket.
+	ObjectInfo struct {
+		// Key is the object key of the file.
+		Key string
+		// Size is the file size in bytes.
+		Size int64
+		// ContentType is the content type of the file.
+		ContentType string
+		// ContentEncoding is the content encoding of the file, e.g. "gzip".
+		ContentEncoding string
+		// ContentDisposition is the content disposition of the file.
+		ContentDisposition string
+		// CacheControl is the cache control directive of the file.
+		CacheControl string
+		// ETag is the entity tag of the file.
+		ETag string
+		// LastModified is the time the file was last modified.
+		LastModified time.Time
+		// Metadata is the user-defined metadata of the file, with lower-cased keys.
+		Metadata map[string]string
+		// Image contains the attributes of the image, if they were stored on upload.
+		Image *ImageInfo
+		// Placeholder contains the placeholders of the image, if they were stored on upload.
+		Placeholder *ImagePlaceholder
+		// Hash contains the perceptual hashes of the image, if they were stored on upload.
+		Hash *ImageHash
+	}
+
+	// objectReader is an io.ReadSeeker reading a file from the S3 bucket.
+	// The file is requested lazily on the first read after a seek, starting from the current offset,
+	// so seeking doesn't transfer any data.
+	objectReader struct {
+		ctx    context.Context
+		fm     *FileManager
+		info   *ObjectInfo
+		offset int64
+		body   io.ReadCloser
+	}
+)
+
+// Stat returns the attributes of a file stored in the S3 bucket.
+// It returns ErrNotFound if the file does not exist.
+func (fm *FileManager) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := fm.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(fm.bucket),
+		Key:    aws.String(key),
+	})
+	if err := handleS3Error(err); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Join(ErrFailedToGetFile, err)
+	}
+
+	metadata := normalizeMetadata(resp.Metadata)
+	return &ObjectInfo{
+		Key:                key,
+		Size:               aws.Int64Value(resp.ContentLength),
+		ContentType:        aws.StringValue(resp.ContentType),
+		ContentEncoding:    aws.StringValue(resp.ContentEncoding),
+		ContentDisposition: aws.StringValue(resp.ContentDisposition),
+		CacheControl:       aws.StringValue(resp.CacheControl),
+		ETag:               aws.StringValue(resp.ETag),
+		LastModified:       aws.TimeValue(resp.LastModified),
+		Metadata:           metadata,
+		Image:              imageInfoFromMetadata(metadata),
+		Placeholder:        imagePlaceholderFromMetadata(metadata),
+		Hash:               imageHashFromMetadata(metadata),
+	}, nil
+}
+
+// List returns the attributes of all files whose keys start with the prefix, sorted by key.
+// The content type and metadata are not returned by S3 listings, use Stat to get them.
+func (fm *FileManager) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(fm.bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var objects []ObjectInfo
+	for {
+		resp, err := fm.s3.ListObjectsV2WithContext(ctx, input)
+		if err := handleS3Error(err); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, nil
+			}
+			return nil, errors.Join(ErrFailedToListFiles, err)
+		}
+
+		for _, obj := range resp.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				ETag:         aws.StringValue(obj.ETag),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			break
+		}
+		input.ContinuationToken = resp.NextContinuationToken
+	}
+
+	return objects, nil
+}
+
+// Open opens a file stored in the S3 bucket for reading.
+// The returned reader supports seeking, data is requested from S3 only when it's read.
+// The caller must close the reader.
+// It returns ErrNotFound if the file does not exist.
+func (fm *FileManager) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	info, err := fm.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return fm.newObjectReader(ctx, info), nil
+}
+
+// newObjectReader returns a reader of the file described by info.
+func (fm *FileManager) newObjectReader(ctx context.Context, info *ObjectInfo) *objectReader {
+	return &objectReader{ctx: ctx, fm: fm, info: info}
+}
+
+// Read implements the io.Reader interface.
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.info.Size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(r.fm.bucket),
+			Key:    aws.String(r.info.Key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", r.offset)),
+		}
+		// make sure all reads return the same version of the file
+		if r.info.ETag != "" {
+			input.IfMatch = aws.String(r.info.ETag)
+		}
+		resp, err := r.fm.s3.GetObjectWithContext(r.ctx, input)
+		if err := handleS3Error(err); err != nil {
+			return 0, errors.Join(ErrFailedToGetFile, err)
+		}
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Seek implements the io.Seeker interface.
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.info.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != r.offset {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+// Close closes the current response body, if any.
+func (r *objectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// normalizeMetadata converts the S3 metadata to a map with lower-cased keys,
+// since metadata keys are case-insensitive and the SDK returns them in canonical header format.
+func normalizeMetadata(metadata map[string]*string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	return result
+}
@@ -0,0 +1,320 @@
+package filemanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Default settings of published assets.
+const (
+	DefaultAssetCacheControl = "public, max-age=31536000, immutable"
+	DefaultHTMLCacheControl  = "no-cache"
+	DefaultAssetHashLength   = 8
+	DefaultAssetManifestName = "manifest.json"
+)
+
+// maxAssetHashLength is the length of the hex-encoded SHA-256 hash.
+const maxAssetHashLength = sha256.Size * 2
+
+type (
+	// PublishOptions represents the options of a static asset publishing.
+	PublishOptions struct {
+		// Concurrency is the max number of files uploaded in parallel.
+		// Defaults to the FileManager's upload concurrency.
+		Concurrency int
+
+		// Include is a list of glob patterns of the files to publish, see UploadDirOptions.Include for the syntax.
+		// If empty, all files are published.
+		Include []string
+
+		// Exclude is a list of glob patterns of the files to skip, see Include for the syntax.
+		Exclude []string
+
+		// NoHash is a list of glob patterns of the files published under their original names, e.g. "robots.txt".
+		// HTML files are never hashed, since they are the entry points referencing the other assets.
+		NoHash []string
+
+		// HashLength is the number of hex characters of the content hash in the asset keys.
+		// Defaults to DefaultAssetHashLength.
+		HashLength int
+
+		// AssetCacheControl is the Cache-Control header of the hashed assets.
+		// Defaults to DefaultAssetCacheControl.
+		AssetCacheControl string
+
+		// HTMLCacheControl is the Cache-Control header of the HTML files, the not hashed files and the manifest.
+		// Defaults to DefaultHTMLCacheControl.
+		HTMLCacheControl string
+
+		// ManifestName is the name of the manifest file uploaded under the prefix.
+		// Defaults to DefaultAssetManifestName.
+		ManifestName string
+
+		// Compress is a list of compressors used to store precompressed variants of text files,
+		// e.g. "app.js.gz" and "app.js.br" next to "app.js", with the Content-Encoding header set.
+		// The serving handler picks a variant according to the Accept-Encoding request header.
+		// Variants not smaller than the original file are not stored.
+		Compress []Compressor
+
+		// CompressInPlace stores the files compressed with the first compressor under their own keys
+		// instead of storing variants, e.g. for CDNs decompressing files for clients not supporting the encoding.
+		// Files the first compressor doesn't make smaller are stored uncompressed.
+		CompressInPlace bool
+
+		// CompressTypes is a list of content types of the compressed files, wildcards are allowed, e.g. "text/*".
+		// Defaults to DefaultCompressibleTypes.
+		CompressTypes []string
+
+		// MinCompressSize is the min size of the compressed files in bytes.
+		// Defaults to DefaultMinCompressSize.
+		MinCompressSize int64
+	}
+
+	// PublishResult represents the result of a static asset publishing.
+	PublishResult struct {
+		// Files are the results of all published files, ordered by path.
+		// The original name of a file is its logical path relative to the published directory.
+		Files []UploadResult
+		// Manifest maps the logical paths of the published files to their URLs.
+		Manifest map[string]string
+		// ManifestURL is the URL of the uploaded manifest file.
+		ManifestURL string
+	}
+
+	// publishFile represents a file to publish.
+	publishFile struct {
+		result       *UploadResult
+		cacheControl string
+	}
+)
+
+// Publish uploads a build directory of static assets, e.g. os.DirFS("./dist"), under the prefix in the S3 bucket.
+// Each asset is stored under a content-hashed key, e.g. "css/style.css" as "css/style.3f2a1b9c.css",
+// with a long immutable Cache-Control header, so it can be cached by browsers and CDNs forever.
+// HTML files and files matching the NoHash patterns keep their names and are uploaded with no-cache.
+//
+// The assets are uploaded first, then the HTML files and finally a manifest.json mapping the logical paths
+// to the CDN URLs, so the entry points never reference missing assets.
+// If any asset fails to upload, the HTML files and the manifest are not uploaded.
+//
+// It returns the publishing result and an error joining the errors of all failed uploads.
+func (fm *FileManager) Publish(ctx context.Context, fsys fs.FS, prefix string, opts PublishOptions) (*PublishResult, error) {
+	if opts.HashLength <= 0 {
+		opts.HashLength = DefaultAssetHashLength
+	}
+	opts.HashLength = min(opts.HashLength, maxAssetHashLength)
+	if opts.AssetCacheControl == "" {
+		opts.AssetCacheControl = DefaultAssetCacheControl
+	}
+	if opts.HTMLCacheControl == "" {
+		opts.HTMLCacheControl = DefaultHTMLCacheControl
+	}
+	if opts.ManifestName == "" {
+		opts.ManifestName = DefaultAssetManifestName
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = fm.concurrency
+	}
+	if len(opts.CompressTypes) == 0 {
+		opts.CompressTypes = DefaultCompressibleTypes
+	}
+	if opts.MinCompressSize <= 0 {
+		opts.MinCompressSize = DefaultMinCompressSize
+	}
+
+	// collect files to publish, hashing the assets
+	var (
+		files         []UploadResult
+		assets, pages []publishFile
+	)
+	if err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !matchUploadDirPatterns(p, opts.Include, opts.Exclude) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, UploadResult{OriginalName: p, Key: path.Join(prefix, p), Size: info.Size()})
+		return nil
+	}); err != nil {
+		return nil, errors.Join(ErrFailedToUploadFile, err)
+	}
+	for i := range files {
+		res := &files[i]
+		if isHTMLFile(res.OriginalName) || (len(opts.NoHash) > 0 && matchUploadDirPatterns(res.OriginalName, opts.NoHash, nil)) {
+			pages = append(pages, publishFile{result: res, cacheControl: opts.HTMLCacheControl})
+			continue
+		}
+		hash, err := hashFSFile(fsys, res.OriginalName)
+		if err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+		res.Key = path.Join(prefix, hashedAssetName(res.OriginalName, hash[:opts.HashLength]))
+		assets = append(assets, publishFile{result: res, cacheControl: opts.AssetCacheControl})
+	}
+
+	result := &PublishResult{Files: files}
+	if err := fm.publishFiles(ctx, fsys, assets, &opts); err != nil {
+		return result, err
+	}
+	if err := fm.publishFiles(ctx, fsys, pages, &opts); err != nil {
+		return result, err
+	}
+
+	// upload the manifest
+	result.Manifest = make(map[string]string, len(files))
+	for _, res := range files {
+		result.Manifest[res.OriginalName] = res.URL
+	}
+	manifest, err := json.MarshalIndent(result.Manifest, "", "  ")
+	if err != nil {
+		return result, errors.Join(ErrFailedToUploadFile, err)
+	}
+	result.ManifestURL, err = fm.Upload(ctx, bytes.NewReader(manifest), path.Join(prefix, opts.ManifestName), "application/json",
+		WithObjectCacheControl(opts.HTMLCacheControl),
+	)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// publishFiles uploads the files in parallel.
+// It returns an error joining the errors of all failed uploads.
+func (fm *FileManager) publishFiles(ctx context.Context, fsys fs.FS, files []publishFile, opts *PublishOptions) error {
+	eg := errgroup.Group{}
+	eg.SetLimit(opts.Concurrency)
+	for _, f := range files {
+		eg.Go(func() error {
+			res := f.result
+			uploadOpts := []UploadOption{WithObjectCacheControl(f.cacheControl), WithTrustedContent()}
+			if len(opts.Compress) == 0 || res.Size < opts.MinCompressSize {
+				res.ContentType, res.URL, res.Error = fm.uploadFSFile(ctx, fsys, res.OriginalName, res.Key, uploadOpts...)
+				return nil
+			}
+			res.ContentType, res.URL, res.Error = fm.publishCompressedFile(ctx, fsys, res.OriginalName, res.Key, opts, uploadOpts)
+			return nil
+		})
+	}
+	_ = eg.Wait() // errors are reported per file
+
+	var errs []error
+	for _, f := range files {
+		if f.result.Error != nil {
+			errs = append(errs, f.result.Error)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publishCompressedFile uploads a file of the file system with its compressed variants,
+// or compressed in place, if its content type is compressible.
+// It returns the detected content type and the URL of the uploaded file.
+func (fm *FileManager) publishCompressedFile(
+	ctx context.Context,
+	fsys fs.FS,
+	name, key string,
+	opts *PublishOptions,
+	uploadOpts []UploadOption,
+) (string, string, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", "", errors.Join(ErrFailedToUploadFile, err)
+	}
+	contentType, err := detectFileContentType(name, bytes.NewReader(data))
+	if err != nil {
+		return "", "", errors.Join(ErrFailedToUploadFile, err)
+	}
+	if !matchContentType(contentType, opts.CompressTypes) {
+		url, err := fm.Upload(ctx, bytes.NewReader(data), key, contentType, uploadOpts...)
+		return contentType, url, err
+	}
+
+	var encodings []string
+	for _, c := range opts.Compress {
+		compressed, err := compress(c, data)
+		if err != nil {
+			return "", "", errors.Join(ErrFailedToUploadFile, err)
+		}
+		if len(compressed) >= len(data) {
+			if opts.CompressInPlace {
+				break // only the first compressor is used, the file is stored uncompressed
+			}
+			continue // not worth it
+		}
+
+		encoding := c.Encoding()
+		variantOpts := append(uploadOpts[:len(uploadOpts):len(uploadOpts)], WithObjectContentEncoding(encoding))
+		if opts.CompressInPlace {
+			url, err := fm.Upload(ctx, bytes.NewReader(compressed), key, contentType, variantOpts...)
+			return contentType, url, err
+		}
+
+		// variants are uploaded before the original file, so it never references missing variants
+		if _, err := fm.Upload(ctx, bytes.NewReader(compressed), compressedVariantKey(key, encoding), contentType, variantOpts...); err != nil {
+			return "", "", err
+		}
+		encodings = append(encodings, encoding)
+	}
+
+	if len(encodings) > 0 {
+		uploadOpts = append(uploadOpts, WithObjectMetadata(map[string]string{
+			encodingsMetadataKey: strings.Join(encodings, ","),
+		}))
+	}
+	url, err := fm.Upload(ctx, bytes.NewReader(data), key, contentType, uploadOpts...)
+	return contentType, url, err
+}
+
+// hashFSFile returns the hex-encoded SHA-256 hash of the file content.
+func hashFSFile(fsys fs.FS, name string) (string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func(file fs.File) {
+		if err := file.Close(); err != nil {
+			slog.Error("failed to close file", "error", err)
+		}
+	}(file)
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashedAssetName inserts the hash before the file extension, e.g. "css/style.css" becomes "css/style.3f2a1b9c.css".
+func hashedAssetName(name, hash string) string {
+	ext := path.Ext(name)
+	if ext == "" || path.Base(name) == ext { // no extension or a dotfile
+		return name + "." + hash
+	}
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// isHTMLFile checks if the file is an HTML page by its extension.
+func isHTMLFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".htm":
+		return true
+	}
+	return false
+}
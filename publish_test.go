@@ -0,0 +1,185 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestPublish(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"index.html":    {Data: []byte("<html></html>")},
+		"css/style.css": {Data: []byte("body{}")},
+		"js/app.js":     {Data: []byte("console.log(1)")},
+		"robots.txt":    {Data: []byte("User-agent: *")},
+	}
+	hash := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])[:8]
+	}
+
+	result, err := fm.Publish(context.Background(), fsys, "site", filemanager.PublishOptions{
+		NoHash: []string{"robots.txt"},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 4)
+
+	cssKey := "site/css/style." + hash("body{}") + ".css"
+	jsKey := "site/js/app." + hash("console.log(1)") + ".js"
+	expected := map[string]string{
+		"index.html":    "https://cdn.example.com/uploads/site/index.html",
+		"css/style.css": "https://cdn.example.com/uploads/" + cssKey,
+		"js/app.js":     "https://cdn.example.com/uploads/" + jsKey,
+		"robots.txt":    "https://cdn.example.com/uploads/site/robots.txt",
+	}
+	require.Equal(t, expected, result.Manifest)
+	require.Equal(t, "https://cdn.example.com/uploads/site/manifest.json", result.ManifestURL)
+
+	// hashed assets are immutable, entry points are revalidated
+	require.Equal(t, filemanager.DefaultAssetCacheControl, s3Client.object(cssKey).cacheControl)
+	require.Equal(t, "text/css; charset=utf-8", s3Client.object(cssKey).contentType)
+	require.Equal(t, filemanager.DefaultAssetCacheControl, s3Client.object(jsKey).cacheControl)
+	require.Equal(t, filemanager.DefaultHTMLCacheControl, s3Client.object("site/index.html").cacheControl)
+	require.Equal(t, filemanager.DefaultHTMLCacheControl, s3Client.object("site/robots.txt").cacheControl)
+	require.Nil(t, s3Client.object("site/css/style.css"))
+
+	manifest := s3Client.object("site/manifest.json")
+	require.NotNil(t, manifest)
+	require.Equal(t, "application/json", manifest.contentType)
+	require.Equal(t, filemanager.DefaultHTMLCacheControl, manifest.cacheControl)
+
+	var stored map[string]string
+	require.NoError(t, json.Unmarshal(manifest.data, &stored))
+	require.Equal(t, expected, stored)
+}
+
+func TestPublishCompressed(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	script := strings.Repeat("console.log('hello, world');\n", 100)
+	fsys := fstest.MapFS{
+		"app.js":    {Data: []byte(script)},
+		"small.css": {Data: []byte("body{}")},
+		"logo.png":  {Data: bytes.Repeat([]byte{0}, 2048)},
+	}
+	deflate := filemanager.NewCompressor("deflate", func(w io.Writer) io.WriteCloser {
+		zw, _ := flate.NewWriter(w, flate.BestCompression)
+		return zw
+	})
+
+	t.Run("variants", func(t *testing.T) {
+		_, err := fm.Publish(context.Background(), fsys, "site", filemanager.PublishOptions{
+			NoHash:   []string{"*"},
+			Compress: []filemanager.Compressor{filemanager.GzipCompressor{}, deflate},
+		})
+		require.NoError(t, err)
+
+		gz := s3Client.object("site/app.js.gz")
+		require.NotNil(t, gz)
+		require.Equal(t, "gzip", gz.contentEncoding)
+		require.Equal(t, "text/javascript; charset=utf-8", gz.contentType)
+		zr, err := gzip.NewReader(bytes.NewReader(gz.data))
+		require.NoError(t, err)
+		data, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		require.Equal(t, script, string(data))
+
+		require.NotNil(t, s3Client.object("site/app.js.deflate"))
+		require.Equal(t, script, string(s3Client.object("site/app.js").data))
+		require.Empty(t, s3Client.object("site/app.js").contentEncoding)
+
+		// small and binary files are not compressed
+		require.Nil(t, s3Client.object("site/small.css.gz"))
+		require.Nil(t, s3Client.object("site/logo.png.gz"))
+	})
+
+	t.Run("serve variants", func(t *testing.T) {
+		serve := func(acceptEncoding string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/site/app.js", nil)
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+			rec := httptest.NewRecorder()
+			fm.ServeFile(rec, req, "site/app.js")
+			return rec
+		}
+
+		rec := serve("gzip, deflate")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		require.Equal(t, "text/javascript; charset=utf-8", rec.Header().Get("Content-Type"))
+		require.Equal(t, s3Client.object("site/app.js.gz").data, rec.Body.Bytes())
+
+		rec = serve("gzip;q=0.5, deflate")
+		require.Equal(t, "deflate", rec.Header().Get("Content-Encoding"))
+
+		rec = serve("identity")
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+		require.Equal(t, script, rec.Body.String())
+
+		rec = serve("gzip;q=0, *")
+		require.Equal(t, "deflate", rec.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("in place", func(t *testing.T) {
+		_, err := fm.Publish(context.Background(), fsys, "inplace", filemanager.PublishOptions{
+			NoHash:          []string{"*"},
+			Compress:        []filemanager.Compressor{filemanager.GzipCompressor{}},
+			CompressInPlace: true,
+		})
+		require.NoError(t, err)
+
+		obj := s3Client.object("inplace/app.js")
+		require.Equal(t, "gzip", obj.contentEncoding)
+		require.Less(t, len(obj.data), len(script))
+		require.Nil(t, s3Client.object("inplace/app.js.gz"))
+
+		// only the first compressor is used
+		noop := filemanager.NewCompressor("identity", func(w io.Writer) io.WriteCloser {
+			return nopWriteCloser{w}
+		})
+		_, err = fm.Publish(context.Background(), fsys, "inplace-first", filemanager.PublishOptions{
+			NoHash:          []string{"*"},
+			Compress:        []filemanager.Compressor{noop, filemanager.GzipCompressor{}},
+			CompressInPlace: true,
+		})
+		require.NoError(t, err)
+		obj = s3Client.object("inplace-first/app.js")
+		require.Empty(t, obj.contentEncoding)
+		require.Equal(t, script, string(obj.data))
+	})
+}
+
+// nopWriteCloser is an io.WriteCloser with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
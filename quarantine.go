@@ -0,0 +1,337 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultQuarantinePrefix is the default prefix of the keys quarantined files are stored under.
+const DefaultQuarantinePrefix = "quarantine"
+
+// quarantineACLMetadata is the metadata key of the ACL a quarantined file is promoted with.
+const quarantineACLMetadata = "quarantine-acl"
+
+// Scan statuses of quarantined files.
+const (
+	// ScanPending means the file is quarantined and waits to be scanned.
+	ScanPending ScanStatus = "pending"
+	// ScanClean means the file is clean and promoted to its public key.
+	ScanClean ScanStatus = "clean"
+	// ScanInfected means the file is infected and removed.
+	ScanInfected ScanStatus = "infected"
+	// ScanRejected means the file is clean, but rejected by the content checks applied on upload,
+	// e.g. an invalid SVG image or an image above the image limits, and removed.
+	ScanRejected ScanStatus = "rejected"
+)
+
+type (
+	// ScanStatus represents the scan status of a quarantined file.
+	ScanStatus string
+
+	// QuarantineResult represents the state of a quarantined file.
+	QuarantineResult struct {
+		// Key is the key the file is promoted to once it's clean.
+		Key string `json:"key"`
+		// QuarantineKey is the private key the file is stored under until it's scanned.
+		QuarantineKey string `json:"quarantine_key"`
+		// URL is the public URL of the file, available once it's clean.
+		URL string `json:"url"`
+		// ContentType is the content type the file is stored with, as set by the content safety policy.
+		ContentType string `json:"content_type"`
+		// Status is the scan status of the file.
+		Status ScanStatus `json:"status"`
+		// Signature is the name of the malware signature found in an infected file.
+		Signature string `json:"signature,omitempty"`
+	}
+)
+
+// Quarantine uploads a file to the private quarantine prefix and scans it in the background,
+// so the caller doesn't wait for the scan. It returns the pending result right after the upload,
+// or once a scan slot is free if the configured upload concurrency of scans is reached.
+//
+// Once the scanner clears the file, it's promoted to its key with the intended ACL, set with the WithObjectACL
+// upload option, and removed from the quarantine. Infected files are removed.
+// The quarantine callback, if set, is called with the result of the scan.
+// If the file can't be scanned, it stays in the quarantine until ScanQuarantine retries it.
+//
+// The content safety policy is applied right away, so unsafe content types fail the upload.
+// The other content checks and processing of Upload, e.g. the SVG sanitizing, the image limits, the image sanitizer,
+// the watermark and the image variants, are applied when the file is promoted, see ScanQuarantined.
+// It returns an error wrapping ErrMissedScanner if no scanner is configured.
+func (fm *FileManager) Quarantine(ctx context.Context, r io.Reader, filename, contentType string, opts ...UploadOption) (*QuarantineResult, error) {
+	if fm.scanner == nil {
+		return nil, errors.Join(ErrFailedToUploadFile, ErrMissedScanner)
+	}
+
+	o := newUploadOptions(opts)
+	if !o.trusted && fm.contentSafety != nil {
+		var err error
+		if contentType, opts, err = fm.contentSafety.apply(contentType, opts); err != nil {
+			return nil, errors.Join(ErrFailedToUploadFile, err)
+		}
+	}
+	result := &QuarantineResult{
+		Key:           filename,
+		QuarantineKey: fm.quarantineKey(filename),
+		URL:           fm.fileAbsolutePath(filename),
+		ContentType:   contentType,
+		Status:        ScanPending,
+	}
+	// the quarantined file is private and scanned in the background, it's processed once it's promoted
+	quarantineOpts := append(opts[:len(opts):len(opts)],
+		WithObjectACL(s3.ObjectCannedACLPrivate),
+		WithObjectMetadata(map[string]string{quarantineACLMetadata: o.acl}),
+		WithTrustedContent(),
+	)
+	if _, err := fm.UploadStream(ctx, r, result.QuarantineKey, contentType, quarantineOpts...); err != nil {
+		return nil, err
+	}
+
+	// concurrent scans are limited by the configured upload concurrency, so the caller waits for a free slot;
+	// if the wait is canceled, the file stays in the quarantine until ScanQuarantine scans it
+	release, err := fm.acquireQuarantineScan(ctx, filename)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to start quarantined file scan", "key", filename, "error", err)
+		return result, nil
+	}
+
+	// the scan outlives the request the file was uploaded in
+	go func(ctx context.Context) {
+		defer release()
+		if _, err := fm.scanQuarantined(ctx, filename); err != nil {
+			slog.ErrorContext(ctx, "failed to scan quarantined file", "key", filename, "error", err)
+		}
+	}(context.WithoutCancel(ctx))
+
+	return result, nil
+}
+
+// ScanQuarantined scans the quarantined file, promotes it to its key if it's clean or removes it if it's infected.
+// The filename is the key the file is promoted to, as passed to Quarantine.
+//
+// Clean files go through the content checks and processing of Upload, if they apply to the content type,
+// e.g. SVG images are sanitized; other files are copied within the bucket. The internal quarantine metadata
+// is not copied. Files rejected by the content checks are removed with the ScanRejected status.
+//
+// If the file can't be scanned, it stays in the quarantine and the pending result is returned with the error.
+// Scans are limited by the configured upload concurrency.
+// It returns ErrNotFound if the file is not quarantined, or ErrScanInProgress if the file is being scanned
+// by another call within the process.
+func (fm *FileManager) ScanQuarantined(ctx context.Context, filename string) (*QuarantineResult, error) {
+	if fm.scanner == nil {
+		return nil, errors.Join(ErrFailedToScanFile, ErrMissedScanner)
+	}
+
+	release, err := fm.acquireQuarantineScan(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return fm.scanQuarantined(ctx, filename)
+}
+
+// acquireQuarantineScan waits for a free scan slot, limited by the configured upload concurrency,
+// and marks the file as being scanned, so it's not promoted and reported twice.
+// The returned function releases the slot once the scan is done.
+// It returns ErrScanInProgress if the file is being scanned by another call within the process.
+func (fm *FileManager) acquireQuarantineScan(ctx context.Context, filename string) (func(), error) {
+	if _, scanning := fm.quarantineInFlight.LoadOrStore(filename, struct{}{}); scanning {
+		return nil, ErrScanInProgress
+	}
+
+	select {
+	case fm.quarantineScans <- struct{}{}:
+		return func() {
+			<-fm.quarantineScans
+			fm.quarantineInFlight.Delete(filename)
+		}, nil
+	case <-ctx.Done():
+		fm.quarantineInFlight.Delete(filename)
+		return nil, errors.Join(ErrFailedToScanFile, ctx.Err())
+	}
+}
+
+// scanQuarantined scans the quarantined file, see ScanQuarantined. The caller must hold a scan slot.
+func (fm *FileManager) scanQuarantined(ctx context.Context, filename string) (*QuarantineResult, error) {
+	result := &QuarantineResult{
+		Key:           filename,
+		QuarantineKey: fm.quarantineKey(filename),
+		URL:           fm.fileAbsolutePath(filename),
+		Status:        ScanPending,
+	}
+	info, err := fm.Stat(ctx, result.QuarantineKey)
+	if err != nil {
+		return nil, err
+	}
+	result.ContentType = info.ContentType
+
+	file := fm.newObjectReader(ctx, info)
+	err = fm.scan(ctx, file, filename)
+	if err := file.Close(); err != nil {
+		slog.ErrorContext(ctx, "failed to close quarantined file", "key", result.QuarantineKey, "error", err)
+	}
+
+	var infectedErr *InfectedError
+	switch {
+	case errors.As(err, &infectedErr):
+		result.Status, result.Signature = ScanInfected, infectedErr.Signature
+	case errors.Is(err, ErrInfected):
+		result.Status = ScanInfected
+	case err != nil:
+		return result, err
+	default:
+		err := fm.promoteQuarantined(ctx, info, filename)
+		switch {
+		case isContentError(err):
+			slog.WarnContext(ctx, "quarantined file rejected", "key", filename, "error", err)
+			result.Status = ScanRejected
+		case err != nil:
+			return result, err
+		default:
+			result.Status = ScanClean
+		}
+	}
+
+	if err := fm.remove(ctx, result.QuarantineKey); err != nil {
+		return result, err
+	}
+	if fm.onQuarantineScanned != nil {
+		fm.onQuarantineScanned(ctx, result)
+	}
+	return result, nil
+}
+
+// ScanQuarantine scans all quarantined files, e.g. the files which failed to scan or were left
+// after a restart, see ScanQuarantined. Files are scanned in parallel, limited by the configured upload concurrency.
+// It returns the results of the scanned files, and an error if any file failed to scan.
+func (fm *FileManager) ScanQuarantine(ctx context.Context) ([]QuarantineResult, error) {
+	prefix := fm.quarantineKey("")
+	files, err := fm.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToScanFile, err)
+	}
+
+	eg := errgroup.Group{}
+	eg.SetLimit(fm.concurrency)
+
+	var (
+		mu      sync.Mutex
+		results []QuarantineResult
+	)
+	for _, file := range files {
+		eg.Go(func() error {
+			result, err := fm.ScanQuarantined(ctx, strings.TrimPrefix(file.Key, prefix))
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrScanInProgress) {
+				return nil // scanned in the meantime or in the background
+			}
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			results = append(results, *result)
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return results, errors.Join(ErrFailedToScanFile, err)
+	}
+	return results, nil
+}
+
+// QuarantineStatus returns the scan status of a file uploaded with Quarantine:
+// ScanPending if it's still quarantined, or ScanClean if it's promoted.
+// It returns ErrNotFound if the file is neither quarantined nor promoted, e.g. it was infected and removed.
+func (fm *FileManager) QuarantineStatus(ctx context.Context, filename string) (ScanStatus, error) {
+	for _, f := range []struct {
+		key    string
+		status ScanStatus
+	}{{fm.quarantineKey(filename), ScanPending}, {filename, ScanClean}} {
+		exists, err := fm.fileExists(ctx, f.key)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return f.status, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// promoteQuarantined stores the clean quarantined file under its key with the intended ACL
+// and the attributes it was uploaded with, except for the internal quarantine metadata.
+// Files processed on upload, e.g. SVG images, go through the same content checks and processing as with Upload,
+// other files are copied within the bucket, in parts if they're larger than 5GB.
+func (fm *FileManager) promoteQuarantined(ctx context.Context, info *ObjectInfo, filename string) error {
+	metadata := maps.Clone(info.Metadata)
+	delete(metadata, quarantineACLMetadata)
+	opts := []UploadOption{
+		WithObjectACL(info.Metadata[quarantineACLMetadata]),
+		WithObjectCacheControl(info.CacheControl),
+		WithObjectContentEncoding(info.ContentEncoding),
+		WithObjectContentDisposition(info.ContentDisposition),
+		WithObjectMetadata(metadata),
+		withoutScanning(),
+	}
+
+	if info.ContentEncoding == "" && (isSVGImage(info.ContentType) || fm.processesImage(info.ContentType)) {
+		file := fm.newObjectReader(ctx, info)
+		defer func(file io.Closer) {
+			if err := file.Close(); err != nil {
+				slog.ErrorContext(ctx, "failed to close quarantined file", "key", info.Key, "error", err)
+			}
+		}(file)
+		_, err := fm.upload(ctx, file, filename, info.ContentType, opts)
+		return err
+	}
+
+	o := newUploadOptions(opts)
+	if info.Size > maxCopyObjectSize {
+		if err := handleS3Error(fm.copyMultipart(ctx, info, filename, o)); err != nil {
+			return errors.Join(ErrFailedToCopyFile, err)
+		}
+		return nil
+	}
+
+	source := &url.URL{Path: fm.bucket + "/" + info.Key}
+	_, err := fm.s3.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		ACL:                aws.String(o.acl),
+		Bucket:             aws.String(fm.bucket),
+		CacheControl:       stringOrNil(o.cacheControl),
+		ContentDisposition: stringOrNil(o.contentDisposition),
+		ContentEncoding:    stringOrNil(o.contentEncoding),
+		ContentType:        aws.String(info.ContentType),
+		CopySource:         aws.String(source.EscapedPath()),
+		Key:                aws.String(filename),
+		Metadata:           metadataOrNil(o.metadata),
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+	})
+	if err := handleS3Error(err); err != nil {
+		return errors.Join(ErrFailedToCopyFile, err)
+	}
+	return nil
+}
+
+// isContentError checks if the upload failed because of the file content, e.g. an invalid SVG image.
+func isContentError(err error) bool {
+	var limitErr *ImageLimitError
+	return errors.As(err, &limitErr) || errors.Is(err, ErrInvalidSVG) ||
+		errors.Is(err, ErrFailedToProcessImage) || errors.Is(err, ErrUnsafeContentType)
+}
+
+// quarantineKey returns the key the file is quarantined under.
+func (fm *FileManager) quarantineKey(filename string) string {
+	return fm.quarantinePrefix + "/" + strings.TrimLeft(filename, "/")
+}
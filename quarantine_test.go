@@ -0,0 +1,408 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestQuarantine(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fakeClamd(t, l)
+
+	s3Client := newMemoryS3Client()
+	scanned := make(chan *filemanager.QuarantineResult, 10)
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+		filemanager.WithScanner(filemanager.ClamAVScanner{Address: l.Addr().String()}),
+		filemanager.WithQuarantineCallback(func(_ context.Context, result *filemanager.QuarantineResult) {
+			scanned <- result
+		}),
+	)
+	require.NoError(t, err)
+
+	waitScanned := func(t *testing.T) *filemanager.QuarantineResult {
+		select {
+		case result := <-scanned:
+			return result
+		case <-time.After(5 * time.Second):
+			t.Fatal("quarantined file was not scanned")
+			return nil
+		}
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		result, err := fm.Quarantine(ctx, strings.NewReader("hello"), "docs/hello.txt", "text/plain",
+			filemanager.WithObjectACL("authenticated-read"))
+		require.NoError(t, err)
+		require.Equal(t, filemanager.ScanPending, result.Status)
+		require.Equal(t, "quarantine/docs/hello.txt", result.QuarantineKey)
+		require.Equal(t, "https://cdn.example.com/uploads/docs/hello.txt", result.URL)
+
+		scan := waitScanned(t)
+		require.Equal(t, filemanager.ScanClean, scan.Status)
+		require.Equal(t, "docs/hello.txt", scan.Key)
+
+		obj := s3Client.object("docs/hello.txt")
+		require.NotNil(t, obj)
+		require.Equal(t, "hello", string(obj.data))
+		require.Equal(t, "text/plain", obj.contentType)
+		require.Equal(t, "authenticated-read", obj.acl)
+		require.NotContains(t, obj.metadata, "quarantine-acl")
+		require.Nil(t, s3Client.object("quarantine/docs/hello.txt"))
+
+		status, err := fm.QuarantineStatus(ctx, "docs/hello.txt")
+		require.NoError(t, err)
+		require.Equal(t, filemanager.ScanClean, status)
+	})
+
+	t.Run("infected", func(t *testing.T) {
+		result, err := fm.Quarantine(ctx, strings.NewReader(eicar), "eicar.txt", "text/plain")
+		require.NoError(t, err)
+		require.Equal(t, filemanager.ScanPending, result.Status)
+
+		scan := waitScanned(t)
+		require.Equal(t, filemanager.ScanInfected, scan.Status)
+		require.Equal(t, "Eicar-Test-Signature", scan.Signature)
+		require.Nil(t, s3Client.object("eicar.txt"))
+		require.Nil(t, s3Client.object("quarantine/eicar.txt"))
+
+		_, err = fm.QuarantineStatus(ctx, "eicar.txt")
+		require.ErrorIs(t, err, filemanager.ErrNotFound)
+	})
+
+	t.Run("content checks", func(t *testing.T) {
+		// promoted images go through the content checks and processing of Upload
+		_, err := fm.Quarantine(ctx, strings.NewReader(maliciousSVG), "logo.svg", "image/svg+xml")
+		require.NoError(t, err)
+		require.Equal(t, filemanager.ScanClean, waitScanned(t).Status)
+		obj := s3Client.object("logo.svg")
+		require.NotContains(t, string(obj.data), "alert")
+		require.Empty(t, obj.contentDisposition)
+
+		page := `<html xmlns="http://www.w3.org/1999/xhtml"><head><meta http-equiv="refresh" content="0;url=https://evil.example.com"/></head></html>`
+		_, err = fm.Quarantine(ctx, strings.NewReader(page), "page.svg", "image/svg+xml")
+		require.NoError(t, err)
+		require.Equal(t, filemanager.ScanRejected, waitScanned(t).Status)
+		require.Nil(t, s3Client.object("page.svg"))
+		require.Nil(t, s3Client.object("quarantine/page.svg"))
+	})
+
+	t.Run("scan quarantine", func(t *testing.T) {
+		// files left in the quarantine, e.g. after a restart
+		for key, content := range map[string]string{"a.txt": "a", "b.txt": eicar} {
+			_, err := fm.Upload(ctx, strings.NewReader(content), "quarantine/"+key, "text/plain",
+				filemanager.WithObjectACL("private"), filemanager.WithTrustedContent())
+			require.NoError(t, err)
+		}
+		status, err := fm.QuarantineStatus(ctx, "a.txt")
+		require.NoError(t, err)
+		require.Equal(t, filemanager.ScanPending, status)
+
+		results, err := fm.ScanQuarantine(ctx)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		for range results {
+			waitScanned(t)
+		}
+
+		require.Equal(t, "a", string(s3Client.object("a.txt").data))
+		require.Equal(t, filemanager.DefaultACL, s3Client.object("a.txt").acl) // no intended ACL recorded
+		require.Nil(t, s3Client.object("b.txt"))
+		require.Nil(t, s3Client.object("quarantine/a.txt"))
+		require.Nil(t, s3Client.object("quarantine/b.txt"))
+	})
+
+	t.Run("upload handler", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "report.txt")
+		require.NoError(t, err)
+		_, err = part.Write([]byte("report"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		filemanager.NewUploadHandler(fm, filemanager.WithUploadQuarantine(true)).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+
+		var resp struct {
+			URL    string `json:"url"`
+			Key    string `json:"key"`
+			Status string `json:"status"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, "pending", resp.Status)
+		require.Regexp(t, `^[0-9a-f]{32}\.txt$`, resp.Key)
+		require.Equal(t, "https://cdn.example.com/uploads/"+resp.Key, resp.URL)
+
+		require.Equal(t, filemanager.ScanClean, waitScanned(t).Status)
+		require.NotNil(t, s3Client.object(resp.Key))
+	})
+
+	t.Run("upload handler reports the stored type", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(failingScanner{}),
+			filemanager.WithContentSafetyPolicy(filemanager.ContentSafetyPolicy{"text/plain": filemanager.ContentNeutralType}),
+		)
+		require.NoError(t, err)
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "report.txt")
+		require.NoError(t, err)
+		_, err = part.Write([]byte("report"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		filemanager.NewUploadHandler(fm, filemanager.WithUploadQuarantine(true)).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+
+		var resp struct {
+			Key  string `json:"key"`
+			Type string `json:"type"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, filemanager.NeutralContentType, resp.Type)
+		require.Equal(t, filemanager.NeutralContentType, s3Client.object("quarantine/"+resp.Key).contentType)
+	})
+
+	t.Run("missed scanner", func(t *testing.T) {
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+		)
+		require.NoError(t, err)
+
+		_, err = fm.Quarantine(ctx, strings.NewReader("hello"), "hello.txt", "text/plain")
+		require.ErrorIs(t, err, filemanager.ErrMissedScanner)
+		require.Nil(t, s3Client.object("quarantine/hello.txt"))
+	})
+
+	t.Run("quarantined file is private", func(t *testing.T) {
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(failingScanner{}),
+			filemanager.WithQuarantinePrefix("/pending/"),
+		)
+		require.NoError(t, err)
+
+		// the file stays in the quarantine if it can't be scanned
+		_, err = fm.Quarantine(ctx, strings.NewReader("hello"), "private.txt", "text/plain")
+		require.NoError(t, err)
+		obj := s3Client.object("pending/private.txt")
+		require.NotNil(t, obj)
+		require.Equal(t, "private", obj.acl)
+		require.Equal(t, filemanager.DefaultACL, aws.StringValue(obj.metadata["quarantine-acl"]))
+
+		// the file is marked as being scanned until the background scan fails
+		require.Eventually(t, func() bool {
+			_, err = fm.ScanQuarantined(ctx, "private.txt")
+			return !errors.Is(err, filemanager.ErrScanInProgress)
+		}, 5*time.Second, time.Millisecond)
+		require.ErrorIs(t, err, filemanager.ErrFailedToScanFile)
+		require.NotNil(t, s3Client.object("pending/private.txt"))
+		require.Nil(t, s3Client.object("private.txt"))
+	})
+
+	t.Run("concurrent scans", func(t *testing.T) {
+		scanner := &blockingScanner{release: make(chan struct{})}
+		scanned := make(chan *filemanager.QuarantineResult, 10)
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(scanner),
+			filemanager.WithUploadConcurrency(1),
+			filemanager.WithQuarantineCallback(func(_ context.Context, result *filemanager.QuarantineResult) {
+				scanned <- result
+			}),
+		)
+		require.NoError(t, err)
+
+		_, err = fm.Quarantine(ctx, strings.NewReader("one"), "one.txt", "text/plain")
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return scanner.active.Load() == 1 }, 5*time.Second, time.Millisecond)
+
+		// files scanned in the background are neither scanned nor reported again
+		_, err = fm.ScanQuarantined(ctx, "one.txt")
+		require.ErrorIs(t, err, filemanager.ErrScanInProgress)
+		results, err := fm.ScanQuarantine(ctx)
+		require.NoError(t, err)
+		require.Empty(t, results)
+
+		// the second upload waits for the first scan, so scans don't pile up in the background
+		quarantined := make(chan error, 1)
+		go func() {
+			_, err := fm.Quarantine(ctx, strings.NewReader("two"), "two.txt", "text/plain")
+			quarantined <- err
+		}()
+		require.Eventually(t, func() bool { return s3Client.object("quarantine/two.txt") != nil }, 5*time.Second, time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Empty(t, quarantined)
+		close(scanner.release)
+		require.NoError(t, <-quarantined)
+		for range 2 {
+			select {
+			case result := <-scanned:
+				require.Equal(t, filemanager.ScanClean, result.Status)
+			case <-time.After(5 * time.Second):
+				t.Fatal("quarantined file was not scanned")
+			}
+		}
+		require.Empty(t, scanned)
+		require.EqualValues(t, 1, scanner.maxActive.Load())
+	})
+
+	t.Run("canceled wait", func(t *testing.T) {
+		scanner := &blockingScanner{release: make(chan struct{})}
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(scanner),
+			filemanager.WithUploadConcurrency(1),
+		)
+		require.NoError(t, err)
+		defer close(scanner.release)
+
+		_, err = fm.Quarantine(ctx, strings.NewReader("one"), "wait/one.txt", "text/plain")
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return scanner.active.Load() == 1 }, 5*time.Second, time.Millisecond)
+
+		// the file stays in the quarantine until ScanQuarantine scans it
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		result, err := fm.Quarantine(timeout, strings.NewReader("two"), "wait/two.txt", "text/plain")
+		require.NoError(t, err)
+		require.Equal(t, filemanager.ScanPending, result.Status)
+		require.NotNil(t, s3Client.object("quarantine/wait/two.txt"))
+		require.EqualValues(t, 1, scanner.active.Load())
+	})
+}
+
+// blockingScanner is a Scanner which blocks until it's released, and counts the concurrent scans.
+type blockingScanner struct {
+	release   chan struct{}
+	active    atomic.Int64
+	maxActive atomic.Int64
+}
+
+func (s *blockingScanner) Scan(_ context.Context, r io.Reader) error {
+	n := s.active.Add(1)
+	defer s.active.Add(-1)
+	for {
+		m := s.maxActive.Load()
+		if n <= m || s.maxActive.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	<-s.release
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+func TestQuarantine_LargeFile(t *testing.T) {
+	const (
+		bucket   = "test-bucket"
+		key      = "videos/big.mp4"
+		uploadID = "upload-id"
+		size     = 6 << 30 // 6GB, above the limit of a single copy request
+	)
+	mockS3 := new(mockS3Client)
+	mockS3.On("HeadObjectWithContext", mock.Anything, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("quarantine/" + key),
+	}, mock.Anything).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String("video/mp4"),
+		Metadata:      map[string]*string{"Quarantine-Acl": aws.String("private")},
+	}, nil)
+	mockS3.On("CreateMultipartUploadWithContext", mock.Anything, &s3.CreateMultipartUploadInput{
+		ACL:         aws.String("private"),
+		ContentType: aws.String("video/mp4"),
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+	}, mock.Anything).Return(&s3.CreateMultipartUploadOutput{UploadId: aws.String(uploadID)}, nil)
+	var parts []*s3.CompletedPart
+	for i := int64(0); i < 12; i++ {
+		mockS3.On("UploadPartCopyWithContext", mock.Anything, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(bucket),
+			CopySource:      aws.String(bucket + "/quarantine/" + key),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", i*512<<20, (i+1)*512<<20-1)),
+			Key:             aws.String(key),
+			PartNumber:      aws.Int64(i + 1),
+			UploadId:        aws.String(uploadID),
+		}, mock.Anything).Return(&s3.UploadPartCopyOutput{
+			CopyPartResult: &s3.CopyPartResult{ETag: aws.String(fmt.Sprintf("etag-%d", i+1))},
+		}, nil).Once()
+		parts = append(parts, &s3.CompletedPart{ETag: aws.String(fmt.Sprintf("etag-%d", i+1)), PartNumber: aws.Int64(i + 1)})
+	}
+	mockS3.On("CompleteMultipartUploadWithContext", mock.Anything, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}, mock.Anything).Return(&s3.CompleteMultipartUploadOutput{}, nil)
+	mockS3.On("DeleteObjectWithContext", mock.Anything, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("quarantine/" + key),
+	}, mock.Anything).Return(&s3.DeleteObjectOutput{}, nil)
+
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(mockS3),
+		filemanager.WithBucketName(bucket),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+		filemanager.WithScanner(cleanScanner{}),
+	)
+	require.NoError(t, err)
+
+	// files larger than 5GB can't be copied with a single request, they're copied in parts
+	result, err := fm.ScanQuarantined(context.Background(), key)
+	require.NoError(t, err)
+	require.Equal(t, filemanager.ScanClean, result.Status)
+	require.Equal(t, "video/mp4", result.ContentType)
+	mockS3.AssertExpectations(t)
+}
+
+// cleanScanner is a Scanner which finds every file clean without reading it.
+type cleanScanner struct{}
+
+func (cleanScanner) Scan(context.Context, io.Reader) error {
+	return nil
+}
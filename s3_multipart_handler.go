@@ -0,0 +1,454 @@
+package filemanager
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// DefaultPresignExpiration - default expiration time of presigned part upload URLs
+const DefaultPresignExpiration = 15 * time.Minute
+
+// S3MultipartMetadataPrefix is the prefix of the object metadata keys sent by the client,
+// so they can't override the metadata the file manager stores, e.g. the image info or the quarantine ACL.
+const S3MultipartMetadataPrefix = "client-"
+
+type (
+	// S3MultipartHandler is an http.Handler coordinating S3 multipart uploads made directly from a browser,
+	// compatible with the Uppy AwsS3Multipart plugin. The file data never goes through the server:
+	// the handler creates a multipart upload, presigns part upload URLs, lists the uploaded parts
+	// and completes or aborts the upload.
+	//
+	// If a scanner is configured, files are uploaded to the private quarantine prefix and scanned when the upload
+	// is completed, see FileManager.ScanQuarantined. Infected files and files rejected by the content checks
+	// are removed and the complete request fails with 422 Unprocessable Entity.
+	//
+	// The upload ID returned to the client is signed along with the key, so the client can only upload
+	// to the keys created by the handler, and the uploads can't be completed under another key.
+	//
+	// Routes, relative to the URL path the handler is mounted at:
+	//   - POST   /                          - create a multipart upload
+	//   - GET    /{uploadId}/{partNumber}?key= - presign a part upload URL
+	//   - GET    /{uploadId}?key=              - list uploaded parts
+	//   - POST   /{uploadId}/complete?key=     - complete the upload
+	//   - DELETE /{uploadId}?key=              - abort the upload
+	S3MultipartHandler struct {
+		fm           *FileManager
+		mux          *http.ServeMux
+		secret       []byte
+		maxSize      int64
+		allowedTypes []string
+		expiration   time.Duration
+		keyFunc      func(r *http.Request, filename string) (string, error)
+	}
+
+	// S3MultipartOption represents an S3 multipart handler option function.
+	S3MultipartOption func(*S3MultipartHandler)
+
+	// s3MultipartCreateRequest represents a request to create a multipart upload.
+	s3MultipartCreateRequest struct {
+		Filename string            `json:"filename"`
+		Type     string            `json:"type"`
+		Size     int64             `json:"size"`
+		Metadata map[string]string `json:"metadata"`
+	}
+
+	// s3MultipartCompleteRequest represents a request to complete a multipart upload.
+	s3MultipartCompleteRequest struct {
+		Parts []struct {
+			PartNumber int64  `json:"PartNumber"`
+			ETag       string `json:"ETag"`
+		} `json:"parts"`
+	}
+
+	// s3MultipartPart represents an uploaded part in the list parts response.
+	s3MultipartPart struct {
+		PartNumber int64  `json:"PartNumber"`
+		Size       int64  `json:"Size"`
+		ETag       string `json:"ETag"`
+	}
+)
+
+// WithS3MultipartMaxSize sets the max size of a file uploaded through the handler.
+// Defaults to the FileManager's max file size.
+func WithS3MultipartMaxSize(maxSize int64) S3MultipartOption {
+	return func(h *S3MultipartHandler) {
+		if maxSize > 0 {
+			h.maxSize = maxSize
+		}
+	}
+}
+
+// WithS3MultipartAllowedTypes sets the allowed content types, e.g. "video/*".
+// By default, any content type is allowed.
+func WithS3MultipartAllowedTypes(types ...string) S3MultipartOption {
+	return func(h *S3MultipartHandler) {
+		h.allowedTypes = types
+	}
+}
+
+// WithS3MultipartPresignExpiration sets the expiration time of presigned part upload URLs.
+func WithS3MultipartPresignExpiration(expiration time.Duration) S3MultipartOption {
+	return func(h *S3MultipartHandler) {
+		if expiration > 0 {
+			h.expiration = expiration
+		}
+	}
+}
+
+// WithS3MultipartKeyFunc sets the function generating an object key for a new upload.
+// The key must be a valid path, see fs.ValidPath, outside of the prefixes the file manager keeps for itself,
+// otherwise the upload is not created. By default, a random name with the file extension is used.
+func WithS3MultipartKeyFunc(fn func(r *http.Request, filename string) (string, error)) S3MultipartOption {
+	return func(h *S3MultipartHandler) {
+		if fn != nil {
+			h.keyFunc = fn
+		}
+	}
+}
+
+// NewS3MultipartHandler creates a new S3 multipart upload coordinator backed by the file manager.
+// The urlPath is the URL path the handler is mounted at, e.g. "/s3/multipart".
+// The secret is used to sign the upload IDs, so clients can't upload to keys the handler didn't create.
+func NewS3MultipartHandler(fm *FileManager, urlPath string, secret []byte, opts ...S3MultipartOption) *S3MultipartHandler {
+	h := &S3MultipartHandler{
+		fm:         fm,
+		mux:        http.NewServeMux(),
+		secret:     secret,
+		maxSize:    fm.maxFileSize,
+		expiration: DefaultPresignExpiration,
+		keyFunc:    randomFilename,
+	}
+	for _, o := range opts {
+		o(h)
+	}
+
+	urlPath = strings.TrimRight("/"+strings.Trim(urlPath, "/"), "/")
+	h.mux.HandleFunc("POST "+urlPath+"/{$}", h.create)
+	if urlPath != "" {
+		h.mux.HandleFunc("POST "+urlPath, h.create)
+	}
+	h.mux.HandleFunc("GET "+urlPath+"/{uploadId}/{partNumber}", h.signPart)
+	h.mux.HandleFunc("GET "+urlPath+"/{uploadId}", h.listParts)
+	h.mux.HandleFunc("POST "+urlPath+"/{uploadId}/complete", h.complete)
+	h.mux.HandleFunc("DELETE "+urlPath+"/{uploadId}", h.abort)
+
+	return h
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *S3MultipartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// create handles requests to create a multipart upload.
+func (h *S3MultipartHandler) create(w http.ResponseWriter, r *http.Request) {
+	var req s3MultipartCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Filename == "" {
+		writeJSONError(w, http.StatusBadRequest, "missed filename")
+		return
+	}
+	if req.Type == "" {
+		req.Type = "application/octet-stream"
+	}
+	if !matchContentType(req.Type, h.allowedTypes) {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "content type is not allowed")
+		return
+	}
+	if req.Size > h.maxSize {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "file is too large")
+		return
+	}
+	contentType, opts, err := h.fm.streamedContent(req.Type, nil)
+	if err != nil {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "content type is not allowed")
+		return
+	}
+	o := newUploadOptions(opts)
+
+	name, err := h.keyFunc(r, req.Filename)
+	if err != nil {
+		h.serverError(w, r, err)
+		return
+	}
+	key := h.fm.objectKey(name)
+	if key == "." || !fs.ValidPath(key) || h.fm.isInternalKey(key) {
+		h.serverError(w, r, fmt.Errorf("invalid key %q", name))
+		return
+	}
+
+	metadata := make(map[string]*string, len(req.Metadata))
+	for k, v := range req.Metadata {
+		metadata[S3MultipartMetadataPrefix+strings.ToLower(k)] = aws.String(v)
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		ACL:                aws.String(o.acl),
+		ContentDisposition: stringOrNil(o.contentDisposition),
+		ContentType:        aws.String(contentType),
+		Bucket:             aws.String(h.fm.bucket),
+		Key:                aws.String(key),
+		Metadata:           metadata,
+	}
+	if h.fm.scanner != nil {
+		// the file is private until it's scanned and promoted with the intended ACL
+		input.ACL = aws.String(s3.ObjectCannedACLPrivate)
+		input.Key = aws.String(h.storageKey(key))
+		metadata[quarantineACLMetadata] = aws.String(o.acl)
+	}
+	resp, err := h.fm.s3.CreateMultipartUploadWithContext(r.Context(), input)
+	if err != nil {
+		h.serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"uploadId": h.signUploadID(key, aws.StringValue(resp.UploadId)),
+		"key":      key,
+	})
+}
+
+// signPart handles requests to presign a part upload URL.
+func (h *S3MultipartHandler) signPart(w http.ResponseWriter, r *http.Request) {
+	key, uploadID, ok := h.upload(w, r)
+	if !ok {
+		return
+	}
+	partNumber, err := strconv.ParseInt(r.PathValue("partNumber"), 10, 64)
+	if err != nil || partNumber < 1 || partNumber > MaxUploadParts {
+		writeJSONError(w, http.StatusBadRequest, "invalid part number")
+		return
+	}
+	// parts are at least MinPartSize, except the last one
+	if (partNumber-1)*MinPartSize >= h.maxSize {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "file is too large")
+		return
+	}
+
+	req, _ := h.fm.s3.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(h.fm.bucket),
+		Key:        aws.String(h.storageKey(key)),
+		PartNumber: aws.Int64(partNumber),
+		UploadId:   aws.String(uploadID),
+	})
+	url, err := req.Presign(h.expiration)
+	if err != nil {
+		h.serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"url":     url,
+		"expires": int(h.expiration.Seconds()),
+	})
+}
+
+// listParts handles requests to list the uploaded parts.
+func (h *S3MultipartHandler) listParts(w http.ResponseWriter, r *http.Request) {
+	key, uploadID, ok := h.upload(w, r)
+	if !ok {
+		return
+	}
+
+	parts, err := h.parts(r.Context(), h.storageKey(key), uploadID)
+	if err != nil {
+		h.s3Error(w, r, err)
+		return
+	}
+
+	result := make([]s3MultipartPart, 0, len(parts))
+	for _, part := range parts {
+		result = append(result, s3MultipartPart{
+			PartNumber: aws.Int64Value(part.PartNumber),
+			Size:       aws.Int64Value(part.Size),
+			ETag:       aws.StringValue(part.ETag),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// complete handles requests to complete the upload.
+// The upload is aborted if the uploaded file exceeds the max size.
+// Quarantined files are scanned and promoted before the response is sent.
+func (h *S3MultipartHandler) complete(w http.ResponseWriter, r *http.Request) {
+	key, uploadID, ok := h.upload(w, r)
+	if !ok {
+		return
+	}
+	storageKey := h.storageKey(key)
+
+	var req s3MultipartCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	parts, err := h.parts(r.Context(), storageKey, uploadID)
+	if err != nil {
+		h.s3Error(w, r, err)
+		return
+	}
+	var size int64
+	for _, part := range parts {
+		size += aws.Int64Value(part.Size)
+	}
+	if size > h.maxSize {
+		h.fm.abortMultipartUpload(r.Context(), storageKey, uploadID)
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "file is too large")
+		return
+	}
+
+	completed := make([]*s3.CompletedPart, 0, len(req.Parts))
+	for _, part := range req.Parts {
+		completed = append(completed, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.PartNumber),
+		})
+	}
+	if _, err := h.fm.s3.CompleteMultipartUploadWithContext(r.Context(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(h.fm.bucket),
+		Key:             aws.String(storageKey),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	}); err != nil {
+		h.s3Error(w, r, err)
+		return
+	}
+
+	if storageKey != key {
+		result, err := h.fm.ScanQuarantined(r.Context(), key)
+		switch {
+		case result != nil && result.Status == ScanPending:
+			// the file is published once ScanQuarantine scans it
+			slog.ErrorContext(r.Context(), "failed to scan s3 multipart upload", "key", key, "error", err)
+		case err != nil:
+			h.serverError(w, r, err)
+			return
+		case result.Status == ScanInfected:
+			writeJSONError(w, http.StatusUnprocessableEntity, (&InfectedError{Signature: result.Signature}).Error())
+			return
+		case result.Status == ScanRejected:
+			writeJSONError(w, http.StatusUnprocessableEntity, ErrRejectedContent.Error())
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"location": h.fm.fileAbsolutePath(key),
+	})
+}
+
+// abort handles requests to abort the upload.
+func (h *S3MultipartHandler) abort(w http.ResponseWriter, r *http.Request) {
+	key, uploadID, ok := h.upload(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.fm.s3.AbortMultipartUploadWithContext(r.Context(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(h.fm.bucket),
+		Key:      aws.String(h.storageKey(key)),
+		UploadId: aws.String(uploadID),
+	}); err != nil {
+		h.s3Error(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+// parts returns all uploaded parts of the multipart upload.
+func (h *S3MultipartHandler) parts(ctx context.Context, key, uploadID string) ([]*s3.Part, error) {
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(h.fm.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}
+
+	var parts []*s3.Part
+	for {
+		resp, err := h.fm.s3.ListPartsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, resp.Parts...)
+		if !aws.BoolValue(resp.IsTruncated) {
+			break
+		}
+		input.PartNumberMarker = resp.NextPartNumberMarker
+	}
+
+	return parts, nil
+}
+
+// upload returns the object key from the request query and the S3 upload ID from the signed upload ID
+// in the request path, and checks the signature, so the handler can only be used for the uploads it created.
+func (h *S3MultipartHandler) upload(w http.ResponseWriter, r *http.Request) (key, uploadID string, ok bool) {
+	key = r.URL.Query().Get("key")
+	signed := r.PathValue("uploadId")
+	// the signature is base64url encoded, so the last dot separates it from the S3 upload ID
+	i := strings.LastIndexByte(signed, '.')
+	if i < 0 || key == "" || !hmac.Equal([]byte(signed), []byte(h.signUploadID(key, signed[:i]))) {
+		writeJSONError(w, http.StatusForbidden, "invalid upload")
+		return "", "", false
+	}
+	return key, signed[:i], true
+}
+
+// storageKey returns the key the file is uploaded to: the quarantine key if a scanner is configured.
+func (h *S3MultipartHandler) storageKey(key string) string {
+	if h.fm.scanner != nil {
+		return h.fm.quarantineKey(key)
+	}
+	return key
+}
+
+// signUploadID returns the S3 upload ID with the signature of the upload ID and the key appended.
+func (h *S3MultipartHandler) signUploadID(key, uploadID string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	_, _ = fmt.Fprintf(mac, "%s\n%s", uploadID, key)
+	return uploadID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// s3Error writes an error response for a failed S3 request.
+func (h *S3MultipartHandler) s3Error(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(handleS3Error(err), ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+	h.serverError(w, r, err)
+}
+
+// serverError logs the error and writes an internal server error response.
+func (h *S3MultipartHandler) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "s3 multipart request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+// randomFilename returns a random file name with the extension of the given file name.
+func randomFilename(_ *http.Request, filename string) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	return id + strings.ToLower(path.Ext(filename)), nil
+}
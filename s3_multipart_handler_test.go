@@ -0,0 +1,218 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestS3MultipartHandler(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+		filemanager.WithMaxFileSize(1<<30),
+	)
+	require.NoError(t, err)
+
+	h := filemanager.NewS3MultipartHandler(fm, "/s3/multipart", []byte("secret"), filemanager.WithS3MultipartAllowedTypes("video/*"))
+
+	do := func(method, target, body string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var resp map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	t.Run("content type is not allowed", func(t *testing.T) {
+		rec, _ := do(http.MethodPost, "/s3/multipart", `{"filename":"doc.pdf","type":"application/pdf"}`)
+		require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		rec, _ := do(http.MethodPost, "/s3/multipart", `{"filename":"movie.mp4","type":"video/mp4","size":2147483648}`)
+		require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("unsigned upload", func(t *testing.T) {
+		rec, _ := do(http.MethodGet, "/s3/multipart/upload-id/1?key=secret.mp4", "")
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		rec, _ = do(http.MethodDelete, "/s3/multipart/upload-id.signature?key=quarantine/secret.mp4", "")
+		require.Equal(t, http.StatusForbidden, rec.Code)
+
+		// the upload ID is signed along with the key
+		_, resp := do(http.MethodPost, "/s3/multipart", `{"filename":"movie.mp4","type":"video/mp4"}`)
+		uploadID := resp["uploadId"].(string)
+		rec, _ = do(http.MethodGet, "/s3/multipart/"+uploadID+"/1?key=index.html", "")
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		rec, _ = do(http.MethodGet, "/s3/multipart/"+uploadID+"/1?key="+resp["key"].(string), "")
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		h := filemanager.NewS3MultipartHandler(fm, "/s3/multipart", []byte("secret"), filemanager.WithS3MultipartKeyFunc(
+			func(_ *http.Request, filename string) (string, error) { return "videos//" + filename, nil },
+		))
+		req := httptest.NewRequest(http.MethodPost, "/s3/multipart", strings.NewReader(`{"filename":"movie.mp4","type":"video/mp4"}`))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+		uploads, err := fm.ListIncompleteUploads(context.Background(), "videos/")
+		require.NoError(t, err)
+		require.Empty(t, uploads)
+	})
+
+	t.Run("upload flow", func(t *testing.T) {
+		rec, resp := do(http.MethodPost, "/s3/multipart",
+			`{"filename":"movie.MP4","type":"video/mp4","metadata":{"name":"movie.MP4","image-width":"1"}}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		uploadID := resp["uploadId"].(string)
+		key := resp["key"].(string)
+		require.Regexp(t, `^[0-9a-f]{32}\.mp4$`, key)
+
+		rec, resp = do(http.MethodGet, "/s3/multipart/"+uploadID+"/1?key="+key, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, resp["url"], "partNumber=1")
+		require.Contains(t, resp["url"], "X-Amz-Signature=")
+		partURL, err := url.Parse(resp["url"].(string))
+		require.NoError(t, err)
+
+		// the browser uploads the part to the presigned URL
+		part, err := s3Client.UploadPartWithContext(context.Background(), &s3.UploadPartInput{
+			Body:       bytes.NewReader([]byte("video data")),
+			Key:        aws.String(key),
+			PartNumber: aws.Int64(1),
+			UploadId:   aws.String(partURL.Query().Get("uploadId")),
+		})
+		require.NoError(t, err)
+
+		listRec, _ := do(http.MethodGet, "/s3/multipart/"+uploadID+"?key="+key, "")
+		require.Equal(t, http.StatusOK, listRec.Code)
+		require.JSONEq(t, `[{"PartNumber":1,"Size":10,"ETag":`+string(mustJSON(t, aws.StringValue(part.ETag)))+`}]`, listRec.Body.String())
+
+		body := `{"parts":[{"PartNumber":1,"ETag":` + string(mustJSON(t, aws.StringValue(part.ETag))) + `}]}`
+		rec, resp = do(http.MethodPost, "/s3/multipart/"+uploadID+"/complete?key="+key, body)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "https://cdn.example.com/uploads/"+key, resp["location"])
+		require.Equal(t, []byte("video data"), s3Client.object(key).data)
+
+		// client metadata can't override the metadata stored by the file manager
+		info, err := fm.Stat(context.Background(), key)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"client-name": "movie.MP4", "client-image-width": "1"}, info.Metadata)
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		_, resp := do(http.MethodPost, "/s3/multipart", `{"filename":"movie.mp4","type":"video/mp4"}`)
+		uploadID := resp["uploadId"].(string)
+		key := resp["key"].(string)
+
+		rec, _ := do(http.MethodDelete, "/s3/multipart/"+uploadID+"?key="+key, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec, _ = do(http.MethodGet, "/s3/multipart/"+uploadID+"?key="+key, "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestS3MultipartHandler_Scan(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fakeClamd(t, l)
+
+	s3Client := newMemoryS3Client()
+	newHandler := func(scanner filemanager.Scanner) http.Handler {
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(scanner),
+		)
+		require.NoError(t, err)
+		return filemanager.NewS3MultipartHandler(fm, "/s3/multipart", []byte("secret"))
+	}
+	upload := func(h http.Handler, content string) (*httptest.ResponseRecorder, string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/s3/multipart", strings.NewReader(`{"filename":"file.txt","type":"text/plain"}`)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var created map[string]string
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		key := created["key"]
+
+		// the upload is created under the quarantine key, the presigned URL is opaque to the browser
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/s3/multipart/"+created["uploadId"]+"/1?key="+key, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var signed map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &signed))
+		partURL, err := url.Parse(signed["url"].(string))
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(partURL.Path, "/quarantine/"+key), partURL.Path)
+
+		part, err := s3Client.UploadPartWithContext(context.Background(), &s3.UploadPartInput{
+			Body:       strings.NewReader(content),
+			Key:        aws.String("quarantine/" + key),
+			PartNumber: aws.Int64(1),
+			UploadId:   aws.String(partURL.Query().Get("uploadId")),
+		})
+		require.NoError(t, err)
+
+		body := `{"parts":[{"PartNumber":1,"ETag":` + string(mustJSON(t, aws.StringValue(part.ETag))) + `}]}`
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/s3/multipart/"+created["uploadId"]+"/complete?key="+key, strings.NewReader(body)))
+		return rec, key
+	}
+
+	h := newHandler(filemanager.ClamAVScanner{Address: l.Addr().String()})
+
+	t.Run("clean", func(t *testing.T) {
+		rec, key := upload(h, "hello")
+		require.Equal(t, http.StatusOK, rec.Code)
+		obj := s3Client.object(key)
+		require.NotNil(t, obj)
+		require.Equal(t, []byte("hello"), obj.data)
+		require.Equal(t, defaultACL, obj.acl)
+		require.NotContains(t, obj.metadata, "quarantine-acl")
+		require.Nil(t, s3Client.object("quarantine/"+key))
+	})
+
+	t.Run("infected", func(t *testing.T) {
+		rec, key := upload(h, eicar)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		require.Contains(t, rec.Body.String(), "Eicar-Test-Signature")
+		require.Nil(t, s3Client.object(key))
+		require.Nil(t, s3Client.object("quarantine/"+key))
+	})
+
+	t.Run("scan failure", func(t *testing.T) {
+		// the file stays private until it's scanned
+		rec, key := upload(newHandler(failingScanner{}), "hello")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Nil(t, s3Client.object(key))
+		obj := s3Client.object("quarantine/" + key)
+		require.NotNil(t, obj)
+		require.Equal(t, "private", obj.acl)
+	})
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,276 @@
+package filemanager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// Default ClamAV scanner settings.
+const (
+	DefaultClamAVNetwork   = "tcp"
+	DefaultClamAVAddress   = "localhost:3310"
+	DefaultClamAVTimeout   = time.Minute
+	DefaultClamAVChunkSize = 64 << 10 // 64 KB
+)
+
+type (
+	// Scanner scans uploaded files for malware before they're stored.
+	Scanner interface {
+		// Scan reads the file until EOF and returns an error wrapping ErrInfected, e.g. an *InfectedError,
+		// if the file is infected. Any other error means the file couldn't be scanned.
+		Scan(ctx context.Context, r io.Reader) error
+	}
+
+	// clamdConn is a connection to clamd closed when the context it's dialed with is canceled.
+	clamdConn struct {
+		net.Conn
+		stop func() bool
+	}
+
+	// InfectedError is returned for files infected with malware.
+	// It wraps ErrInfected.
+	InfectedError struct {
+		// Signature is the name of the malware signature found in the file, e.g. "Eicar-Test-Signature".
+		Signature string
+	}
+
+	// ClamAVScanner is a Scanner streaming files to the clamd daemon of ClamAV with the INSTREAM command.
+	// The zero value connects to clamd at DefaultClamAVAddress over TCP.
+	//
+	// clamd rejects streams larger than its StreamMaxLength setting (25 MB by default),
+	// such files fail to scan, so uploads of them fail unless the scanner fails open, see WithScanFailOpen.
+	// Raise StreamMaxLength, along with MaxScanSize and MaxFileSize, in clamd.conf to the max upload size,
+	// clamd doesn't scan streams larger than 4 GB.
+	ClamAVScanner struct {
+		// Network is the network of the clamd socket, "tcp" or "unix".
+		// Defaults to DefaultClamAVNetwork.
+		Network string
+		// Address is the address of the clamd socket, e.g. "localhost:3310" or "/run/clamav/clamd.ctl".
+		// Defaults to DefaultClamAVAddress.
+		Address string
+		// Timeout limits the duration of a scan, including the connection.
+		// Defaults to DefaultClamAVTimeout.
+		Timeout time.Duration
+		// ChunkSize is the size of the chunks the file is streamed in.
+		// Defaults to DefaultClamAVChunkSize.
+		ChunkSize int
+	}
+)
+
+// Error returns the error message.
+func (e *InfectedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInfected, e.Signature)
+}
+
+// Unwrap returns ErrInfected.
+func (e *InfectedError) Unwrap() error {
+	return ErrInfected
+}
+
+// Scan streams the file to clamd and returns an *InfectedError if clamd finds a signature.
+func (s ClamAVScanner) Scan(ctx context.Context, r io.Reader) error {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer func(conn net.Conn) {
+		if err := conn.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close clamd connection", "error", err)
+		}
+	}(conn)
+
+	if err := s.stream(conn, r); err != nil {
+		// clamd stops reading and responds with an error if the stream exceeds its limit
+		if resp, respErr := readClamdResponse(conn); respErr == nil {
+			return parseClamdResponse(resp)
+		}
+		return clamdError(ctx, err)
+	}
+	resp, err := readClamdResponse(conn)
+	if err != nil {
+		return clamdError(ctx, err)
+	}
+	return parseClamdResponse(resp)
+}
+
+// Ping checks that clamd is available.
+func (s ClamAVScanner) Ping(ctx context.Context) error {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer func(conn net.Conn) {
+		if err := conn.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close clamd connection", "error", err)
+		}
+	}(conn)
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return clamdError(ctx, err)
+	}
+	resp, err := readClamdResponse(conn)
+	if err != nil {
+		return clamdError(ctx, err)
+	}
+	if resp != "PONG" {
+		return fmt.Errorf("clamd: unexpected response %q", resp)
+	}
+	return nil
+}
+
+// dial connects to clamd. The connection is closed when the timeout expires or the context is canceled.
+func (s ClamAVScanner) dial(ctx context.Context) (net.Conn, error) {
+	network, address, timeout := s.Network, s.Address, s.Timeout
+	if network == "" {
+		network = DefaultClamAVNetwork
+	}
+	if address == "" {
+		address = DefaultClamAVAddress
+	}
+	if timeout <= 0 {
+		timeout = DefaultClamAVTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+	return &clamdConn{Conn: conn, stop: context.AfterFunc(ctx, func() { _ = conn.Close() })}, nil
+}
+
+// Close closes the connection, unless it's already closed because the context is canceled.
+func (c *clamdConn) Close() error {
+	if !c.stop() {
+		return nil
+	}
+	return c.Conn.Close()
+}
+
+// clamdError returns the error of a clamd request, or the context error if the request failed
+// because the connection was closed when the context was canceled.
+func clamdError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("clamd: %w", ctxErr)
+	}
+	return fmt.Errorf("clamd: %w", err)
+}
+
+// stream sends the INSTREAM command with the file content:
+// chunks prefixed with their size as a 4-byte big-endian integer, terminated by a zero-length chunk.
+func (s ClamAVScanner) stream(conn net.Conn, r io.Reader) error {
+	chunkSize := s.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultClamAVChunkSize
+	}
+
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+				return err
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// readClamdResponse reads a null-terminated response of clamd.
+func readClamdResponse(conn net.Conn) (string, error) {
+	resp, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && (!errors.Is(err, io.EOF) || len(resp) == 0) {
+		return "", err
+	}
+	return string(bytes.TrimSpace(bytes.TrimRight(resp, "\x00"))), nil
+}
+
+// parseClamdResponse parses the response of the INSTREAM command,
+// e.g. "stream: OK" or "stream: Eicar-Test-Signature FOUND".
+func parseClamdResponse(resp string) error {
+	result := strings.TrimPrefix(resp, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &InfectedError{Signature: strings.TrimSuffix(result, " FOUND")}
+	default:
+		return fmt.Errorf("clamd: %s", strings.TrimSuffix(result, " ERROR"))
+	}
+}
+
+// scan scans the file with the configured scanner.
+// If the file can't be scanned, the error is returned wrapping ErrFailedToScanFile,
+// or the file is accepted unscanned if the scanner fails open.
+func (fm *FileManager) scan(ctx context.Context, r io.Reader, filename string) error {
+	err := fm.scanner.Scan(ctx, r)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrInfected):
+		slog.WarnContext(ctx, "infected file rejected", "key", filename, "error", err)
+		return err
+	case fm.scanFailOpen:
+		slog.WarnContext(ctx, "failed to scan file, accepted unscanned", "key", filename, "error", err)
+		return nil
+	default:
+		return errors.Join(ErrFailedToScanFile, err)
+	}
+}
+
+// scanStream scans the data read from the returned reader in the background, while it's uploaded.
+// The returned wait function closes the scanned stream with the upload error, if any,
+// and returns the scan result. It must be called once the reader isn't read anymore.
+func (fm *FileManager) scanStream(ctx context.Context, r io.Reader, filename string) (io.Reader, func(error) error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := fm.scan(ctx, pr, filename)
+		// the scanner may stop reading early, the rest of the stream is discarded, so the upload isn't blocked
+		_, _ = io.Copy(io.Discard, pr)
+		done <- err
+	}()
+
+	wait := func(uploadErr error) error {
+		if uploadErr != nil {
+			pw.CloseWithError(uploadErr)
+		} else {
+			pw.Close()
+		}
+		return <-done
+	}
+	return io.TeeReader(r, pw), wait
+}
@@ -0,0 +1,216 @@
+package filemanager_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd serves the clamd INSTREAM and PING commands on the listener,
+// and reports streams containing the EICAR test string as infected.
+func fakeClamd(t *testing.T, l net.Listener) {
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil {
+					return
+				}
+				switch cmd {
+				case "zPING\x00":
+					_, _ = conn.Write([]byte("PONG\x00"))
+				case "zINSTREAM\x00":
+					var data []byte
+					for {
+						var size uint32
+						if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+							return
+						}
+						if size == 0 {
+							break
+						}
+						chunk := make([]byte, size)
+						if _, err := io.ReadFull(r, chunk); err != nil {
+							return
+						}
+						data = append(data, chunk...)
+					}
+					if bytes.Contains(data, []byte(eicar)) {
+						_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+						return
+					}
+					_, _ = conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+}
+
+// failingScanner is a Scanner which can't scan files.
+type failingScanner struct{}
+
+func (failingScanner) Scan(context.Context, io.Reader) error {
+	return errors.New("scanner is unavailable")
+}
+
+func TestClamAVScanner(t *testing.T) {
+	ctx := context.Background()
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fakeClamd(t, tcp)
+
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	unix, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	fakeClamd(t, unix)
+
+	for name, scanner := range map[string]filemanager.ClamAVScanner{
+		"tcp":  {Address: tcp.Addr().String(), ChunkSize: 16},
+		"unix": {Network: "unix", Address: socket},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, scanner.Ping(ctx))
+			require.NoError(t, scanner.Scan(ctx, strings.NewReader("hello, world")))
+			require.NoError(t, scanner.Scan(ctx, strings.NewReader("")))
+
+			err := scanner.Scan(ctx, strings.NewReader("infected: "+eicar))
+			require.ErrorIs(t, err, filemanager.ErrInfected)
+			var infectedErr *filemanager.InfectedError
+			require.ErrorAs(t, err, &infectedErr)
+			require.Equal(t, "Eicar-Test-Signature", infectedErr.Signature)
+			require.Equal(t, "file is infected: Eicar-Test-Signature", err.Error())
+		})
+	}
+
+	t.Run("unavailable", func(t *testing.T) {
+		scanner := filemanager.ClamAVScanner{Network: "unix", Address: filepath.Join(t.TempDir(), "missing.sock")}
+		err := scanner.Scan(ctx, strings.NewReader("hello"))
+		require.Error(t, err)
+		require.NotErrorIs(t, err, filemanager.ErrInfected)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		// clamd accepts the connection, but never responds
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					_, _ = io.Copy(io.Discard, conn)
+					_ = conn.Close()
+				}()
+			}
+		}()
+
+		ctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		err = filemanager.ClamAVScanner{Address: l.Addr().String()}.Scan(ctx, strings.NewReader("hello"))
+		require.ErrorIs(t, err, context.Canceled)
+		require.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(filemanager.ClamAVScanner{Address: tcp.Addr().String()}),
+			filemanager.WithPartSize(filemanager.MinPartSize),
+		)
+		require.NoError(t, err)
+
+		_, err = fm.Upload(ctx, strings.NewReader("clean"), "clean.txt", "text/plain")
+		require.NoError(t, err)
+		require.Equal(t, "clean", string(s3Client.object("clean.txt").data))
+
+		_, err = fm.Upload(ctx, strings.NewReader(eicar), "eicar.txt", "text/plain")
+		require.ErrorIs(t, err, filemanager.ErrFailedToUploadFile)
+		require.ErrorIs(t, err, filemanager.ErrInfected)
+		require.Nil(t, s3Client.object("eicar.txt"))
+
+		// streamed files are scanned while uploaded, and committed once they're clean
+		large := bytes.Repeat([]byte("a"), filemanager.MinPartSize*2+10)
+		_, err = fm.UploadStream(ctx, bytes.NewReader(large), "large.txt", "text/plain")
+		require.NoError(t, err)
+		require.Equal(t, large, s3Client.object("large.txt").data)
+
+		_, err = fm.UploadStream(ctx, io.MultiReader(bytes.NewReader(large), strings.NewReader(eicar)), "infected.txt", "text/plain")
+		require.ErrorIs(t, err, filemanager.ErrInfected)
+		require.Nil(t, s3Client.object("infected.txt"))
+		uploads, err := fm.ListIncompleteUploads(ctx, "")
+		require.NoError(t, err)
+		require.Empty(t, uploads)
+
+		// trusted files are not scanned
+		_, err = fm.Upload(ctx, strings.NewReader(eicar), "trusted.txt", "text/plain", filemanager.WithTrustedContent())
+		require.NoError(t, err)
+	})
+
+	t.Run("fail closed", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(failingScanner{}),
+		)
+		require.NoError(t, err)
+
+		_, err = fm.Upload(ctx, strings.NewReader("hello"), "hello.txt", "text/plain")
+		require.ErrorIs(t, err, filemanager.ErrFailedToScanFile)
+		require.Nil(t, s3Client.object("hello.txt"))
+
+		_, err = fm.UploadStream(ctx, strings.NewReader("hello"), "stream.txt", "text/plain")
+		require.ErrorIs(t, err, filemanager.ErrFailedToScanFile)
+		require.Nil(t, s3Client.object("stream.txt"))
+	})
+
+	t.Run("fail open", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(failingScanner{}),
+			filemanager.WithScanFailOpen(true),
+		)
+		require.NoError(t, err)
+
+		_, err = fm.Upload(ctx, strings.NewReader("hello"), "hello.txt", "text/plain")
+		require.NoError(t, err)
+		require.NotNil(t, s3Client.object("hello.txt"))
+
+		_, err = fm.UploadStream(ctx, strings.NewReader("hello"), "stream.txt", "text/plain")
+		require.NoError(t, err)
+		require.NotNil(t, s3Client.object("stream.txt"))
+	})
+}
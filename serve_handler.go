@@ -0,0 +1,210 @@
+package filemanager
+
+import (
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+type (
+	// ServeHandler is an http.Handler that streams files from the S3 bucket to the client,
+	// so private files can be served without exposing the bucket.
+	// It supports Range requests, including multiple ranges, and conditional requests
+	// using the stored ETag and last modification time.
+	// Precompressed variants stored by Publish are picked according to the Accept-Encoding request header.
+	ServeHandler struct {
+		fm           *FileManager
+		keyFunc      func(r *http.Request) (string, error)
+		authorize    func(r *http.Request, key string) error
+		disposition  func(r *http.Request, info *ObjectInfo) string
+		cacheControl string
+	}
+
+	// ServeHandlerOption represents a serve handler option function.
+	ServeHandlerOption func(*ServeHandler)
+)
+
+// WithServeKeyFunc sets the function extracting the object key from the request.
+// By default, the request URL path is used as the object key, so the handler is usually mounted with http.StripPrefix,
+// and the objects the file manager keeps for itself, e.g. quarantined files, are not served.
+func WithServeKeyFunc(fn func(r *http.Request) (string, error)) ServeHandlerOption {
+	return func(h *ServeHandler) {
+		if fn != nil {
+			h.keyFunc = fn
+		}
+	}
+}
+
+// WithServeAuthorizer sets a function called before a file is served.
+// If it returns an error, the request is rejected with 401 Unauthorized if the error wraps ErrUnauthorized,
+// with 404 Not Found if the error wraps ErrNotFound, or with 403 Forbidden otherwise.
+func WithServeAuthorizer(fn func(r *http.Request, key string) error) ServeHandlerOption {
+	return func(h *ServeHandler) {
+		h.authorize = fn
+	}
+}
+
+// WithServeDisposition sets a function returning the Content-Disposition header for a file.
+// If the function returns an empty string, the stored content disposition of the file is used.
+// See AttachmentDisposition and InlineDisposition.
+func WithServeDisposition(fn func(r *http.Request, info *ObjectInfo) string) ServeHandlerOption {
+	return func(h *ServeHandler) {
+		h.disposition = fn
+	}
+}
+
+// WithServeAttachment forces browsers to download files instead of displaying them.
+func WithServeAttachment() ServeHandlerOption {
+	return WithServeDisposition(func(_ *http.Request, info *ObjectInfo) string {
+		return AttachmentDisposition(path.Base(info.Key))
+	})
+}
+
+// WithServeCacheControl sets the Cache-Control header of served files.
+// By default, the stored cache control directive of the file is used.
+func WithServeCacheControl(cacheControl string) ServeHandlerOption {
+	return func(h *ServeHandler) {
+		h.cacheControl = cacheControl
+	}
+}
+
+// NewServeHandler creates a new serve handler backed by the file manager.
+func NewServeHandler(fm *FileManager, opts ...ServeHandlerOption) *ServeHandler {
+	h := &ServeHandler{
+		fm: fm,
+		keyFunc: func(r *http.Request) (string, error) {
+			key := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+			if fm.isInternalKey(key) {
+				return "", ErrNotFound
+			}
+			return key, nil
+		},
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	return h
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *ServeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, err := h.keyFunc(r)
+	if err != nil || key == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if h.authorize != nil {
+		if err := h.authorize(r, key); err != nil {
+			switch {
+			case errors.Is(err, ErrUnauthorized):
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			case errors.Is(err, ErrNotFound):
+				http.NotFound(w, r)
+			default:
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			}
+			return
+		}
+	}
+
+	h.fm.serveFile(w, r, key, func(info *ObjectInfo) {
+		if h.disposition != nil {
+			if disposition := h.disposition(r, info); disposition != "" {
+				info.ContentDisposition = disposition
+			}
+		}
+		if h.cacheControl != "" {
+			info.CacheControl = h.cacheControl
+		}
+	})
+}
+
+// ServeFile streams a file from the S3 bucket to the client.
+// It supports Range requests, including multiple ranges, and conditional requests
+// using the stored ETag and last modification time.
+// If precompressed variants of the file were stored by Publish, the variant is picked
+// according to the Accept-Encoding request header.
+// It responds with 404 Not Found if the file does not exist.
+func (fm *FileManager) ServeFile(w http.ResponseWriter, r *http.Request, key string) {
+	fm.serveFile(w, r, key, nil)
+}
+
+// serveFile streams a file from the S3 bucket to the client.
+// The prepare function may modify the file attributes before the response headers are written.
+func (fm *FileManager) serveFile(w http.ResponseWriter, r *http.Request, key string, prepare func(info *ObjectInfo)) {
+	info, err := fm.Stat(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to serve file", "key", key, "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if prepare != nil {
+		prepare(info)
+	}
+
+	header := w.Header()
+
+	// pick a precompressed variant of the file, if any
+	stored := info
+	if encodings := info.Metadata[encodingsMetadataKey]; encodings != "" && info.ContentEncoding == "" {
+		header.Add("Vary", "Accept-Encoding")
+		if encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), strings.Split(encodings, ",")); encoding != "" {
+			variant, err := fm.Stat(r.Context(), compressedVariantKey(key, encoding))
+			if err == nil {
+				stored = variant
+			} else {
+				slog.ErrorContext(r.Context(), "failed to get compressed file", "key", key, "encoding", encoding, "error", err)
+			}
+		}
+	}
+
+	header.Set("Etag", stored.ETag)
+	header.Set("X-Content-Type-Options", "nosniff")
+	if info.ContentType != "" {
+		header.Set("Content-Type", info.ContentType)
+	}
+	if stored.ContentEncoding != "" {
+		header.Set("Content-Encoding", stored.ContentEncoding)
+	}
+	if info.ContentDisposition != "" {
+		header.Set("Content-Disposition", info.ContentDisposition)
+	}
+	if info.CacheControl != "" {
+		header.Set("Cache-Control", info.CacheControl)
+	}
+
+	content := fm.newObjectReader(r.Context(), stored)
+	defer func() {
+		if err := content.Close(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to close file", "key", key, "error", err)
+		}
+	}()
+
+	http.ServeContent(w, r, path.Base(key), stored.LastModified, content)
+}
+
+// AttachmentDisposition returns a Content-Disposition header value
+// that makes browsers download the file with the given name.
+func AttachmentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// InlineDisposition returns a Content-Disposition header value
+// that makes browsers display the file, using the given name if it's saved.
+func InlineDisposition(filename string) string {
+	return mime.FormatMediaType("inline", map[string]string{"filename": filename})
+}
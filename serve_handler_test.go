@@ -0,0 +1,127 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestServeHandler(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	content := []byte("0123456789abcdefghij")
+	_, err = fm.Upload(context.Background(), bytes.NewReader(content), "private/report.txt", "text/plain")
+	require.NoError(t, err)
+
+	h := http.StripPrefix("/files/", filemanager.NewServeHandler(fm,
+		filemanager.WithServeAttachment(),
+		filemanager.WithServeAuthorizer(func(r *http.Request, key string) error {
+			if r.Header.Get("Authorization") == "" {
+				return filemanager.ErrUnauthorized
+			}
+			return nil
+		}),
+	))
+
+	do := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/files/private/report.txt", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/private/report.txt", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/files/private/missing.txt", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	var etag string
+	t.Run("full file", func(t *testing.T) {
+		rec := do(nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, content, rec.Body.Bytes())
+		require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+		require.Equal(t, `attachment; filename=report.txt`, rec.Header().Get("Content-Disposition"))
+		require.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+		etag = rec.Header().Get("Etag")
+		require.NotEmpty(t, etag)
+	})
+
+	t.Run("conditional request", func(t *testing.T) {
+		rec := do(map[string]string{"If-None-Match": etag})
+		require.Equal(t, http.StatusNotModified, rec.Code)
+		require.Empty(t, rec.Body.Bytes())
+	})
+
+	t.Run("single range", func(t *testing.T) {
+		rec := do(map[string]string{"Range": "bytes=5-9"})
+		require.Equal(t, http.StatusPartialContent, rec.Code)
+		require.Equal(t, "56789", rec.Body.String())
+		require.Equal(t, "bytes 5-9/20", rec.Header().Get("Content-Range"))
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		rec := do(map[string]string{"Range": "bytes=0-1,-3"})
+		require.Equal(t, http.StatusPartialContent, rec.Code)
+
+		mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		require.Equal(t, "multipart/byteranges", mediaType)
+
+		reader := multipart.NewReader(rec.Body, params["boundary"])
+		var parts []string
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(part)
+			require.NoError(t, err)
+			parts = append(parts, string(data))
+		}
+		require.Equal(t, []string{"01", "hij"}, parts)
+	})
+
+	t.Run("internal objects", func(t *testing.T) {
+		for _, key := range []string{"quarantine/report.txt", ".tus/upload.info"} {
+			_, err := fm.Upload(context.Background(), bytes.NewReader(content), key, "text/plain", filemanager.WithTrustedContent())
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/files/"+key, nil)
+			req.Header.Set("Authorization", "Bearer token")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusNotFound, rec.Code, key)
+		}
+	})
+}
@@ -0,0 +1,383 @@
+package filemanager
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// svgNamespace is the XML namespace of SVG elements.
+const svgNamespace = "http://www.w3.org/2000/svg"
+
+// svgRemovedElements are the SVG elements removed with their content, by lower-cased local name.
+// foreignObject embeds HTML, the others execute scripts or load external documents,
+// or, like the HTML meta, base and form elements, redirect the page or submit data elsewhere.
+var svgRemovedElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+	"meta":          true,
+	"base":          true,
+	"form":          true,
+}
+
+// svgAnimationElements are the SVG elements animating attributes of other elements, by lower-cased local name.
+var svgAnimationElements = map[string]bool{
+	"animate":          true,
+	"animatemotion":    true,
+	"animatetransform": true,
+	"set":              true,
+}
+
+// svgURLPattern matches the url() references of CSS values.
+var svgURLPattern = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]*)['"]?\s*\)`)
+
+// svgImportPattern matches the @import rules of style sheets.
+var svgImportPattern = regexp.MustCompile(`(?i)@import[^;]*;?`)
+
+// svgImageFunctionPattern matches the start of the CSS image functions taking references as strings,
+// e.g. image-set("a.png" 1x).
+var svgImageFunctionPattern = regexp.MustCompile(`(?i)(?:-webkit-)?(?:image-set|image|cross-fade)\(`)
+
+// svgStringPattern matches the CSS strings.
+var svgStringPattern = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+
+// isSVGImage checks if the content type is an SVG image.
+func isSVGImage(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "image/svg+xml"
+}
+
+// SanitizeSVG removes the active content of an SVG image, so it's safe to serve from a trusted domain:
+// scripts, event handler attributes, foreignObject and other embedded documents,
+// animations of links and event handlers, and references to external resources.
+// Only references to fragments of the image itself and data URIs of raster images are kept.
+// Style sheets are checked with the CSS escapes decoded, a style sheet hiding an external reference
+// behind escapes, e.g. "u\72l(...)", is removed as a whole.
+//
+// The comments, processing instructions and the document type declaration are removed as well,
+// so the image can't define entities. It returns an error wrapping ErrInvalidSVG if the image is not well-formed XML
+// or its root is not an <svg> element in the SVG namespace, e.g. an XHTML document.
+func SanitizeSVG(data []byte) ([]byte, error) {
+	var (
+		buf     bytes.Buffer
+		stack   []xml.Name // the open elements, RawToken doesn't check that they're closed
+		skip    int        // depth of the removed element being skipped, 0 if none
+		inStyle bool       // whether the text is the content of a style element
+		root    bool       // whether the root element is read
+	)
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+	for {
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Join(ErrInvalidSVG, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 {
+				if root {
+					return nil, errors.Join(ErrInvalidSVG, fmt.Errorf("unexpected element <%s> after the root", xmlName(t.Name)))
+				}
+				if !isSVGRoot(t) {
+					return nil, errors.Join(ErrInvalidSVG, fmt.Errorf("root element <%s> is not an SVG element", xmlName(t.Name)))
+				}
+				root = true
+			}
+			stack = append(stack, t.Name)
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1] != t.Name {
+				return nil, errors.Join(ErrInvalidSVG, fmt.Errorf("unexpected end element </%s>", xmlName(t.Name)))
+			}
+			stack = stack[:len(stack)-1]
+			if skip > len(stack) {
+				skip = 0
+				continue
+			}
+		}
+		if skip > 0 {
+			continue
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if removeSVGElement(t) {
+				skip = len(stack)
+				continue
+			}
+			inStyle = strings.EqualFold(t.Name.Local, "style")
+			buf.WriteByte('<')
+			buf.WriteString(xmlName(t.Name))
+			for _, attr := range t.Attr {
+				if !keepSVGAttr(attr) {
+					continue
+				}
+				buf.WriteByte(' ')
+				buf.WriteString(xmlName(attr.Name))
+				buf.WriteString(`="`)
+				writeXMLEscaped(&buf, []byte(attr.Value))
+				buf.WriteByte('"')
+			}
+			buf.WriteByte('>')
+		case xml.EndElement:
+			inStyle = false
+			buf.WriteString("</")
+			buf.WriteString(xmlName(t.Name))
+			buf.WriteByte('>')
+		case xml.CharData:
+			if inStyle {
+				t = sanitizeSVGStyle(t)
+			}
+			writeXMLEscaped(&buf, t)
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				buf.WriteString("<?xml ")
+				buf.Write(t.Inst)
+				buf.WriteString("?>")
+			}
+		}
+	}
+	if len(stack) > 0 {
+		return nil, errors.Join(ErrInvalidSVG, fmt.Errorf("unclosed element <%s>", xmlName(stack[len(stack)-1])))
+	}
+	if !root {
+		return nil, errors.Join(ErrInvalidSVG, errors.New("missed root element"))
+	}
+	return buf.Bytes(), nil
+}
+
+// isSVGRoot checks if the root element is an <svg> element declaring the SVG namespace,
+// as a default namespace or for its prefix.
+func isSVGRoot(el xml.StartElement) bool {
+	if el.Name.Local != "svg" {
+		return false
+	}
+	for _, attr := range el.Attr {
+		declared := el.Name.Space == "" && attr.Name.Space == "" && attr.Name.Local == "xmlns" ||
+			el.Name.Space != "" && attr.Name.Space == "xmlns" && attr.Name.Local == el.Name.Space
+		if declared {
+			return attr.Value == svgNamespace
+		}
+	}
+	return false
+}
+
+// removeSVGElement checks if the element is removed with its content.
+func removeSVGElement(el xml.StartElement) bool {
+	name := strings.ToLower(el.Name.Local)
+	if svgRemovedElements[name] {
+		return true
+	}
+	if svgAnimationElements[name] {
+		// animations can turn a link into a javascript: URL or set an event handler
+		for _, attr := range el.Attr {
+			if strings.EqualFold(attr.Name.Local, "attributeName") {
+				target := strings.ToLower(attr.Value)
+				if _, local, ok := strings.Cut(target, ":"); ok {
+					target = local
+				}
+				if target == "href" || strings.HasPrefix(target, "on") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// keepSVGAttr checks if the attribute is kept.
+func keepSVGAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return false // event handlers
+	case name == "action" || name == "formaction":
+		return false // form submission targets
+	case name == "href" || name == "src":
+		return isSafeSVGReference(attr.Value)
+	}
+	// presentation attributes and the style attribute are CSS values
+	return isSafeSVGStyle(decodeCSSEscapes([]byte(attr.Value)))
+}
+
+// sanitizeSVGStyle removes the imports and the external references of a style sheet.
+// A style sheet with CSS escapes is removed as a whole if it's not safe, since the references
+// can't be replaced without re-encoding it.
+func sanitizeSVGStyle(text []byte) []byte {
+	if decoded := decodeCSSEscapes(text); !bytes.Equal(decoded, text) {
+		if isSafeSVGStyle(decoded) {
+			return text
+		}
+		return nil
+	}
+
+	text = svgImportPattern.ReplaceAll(text, nil)
+	text = replaceSVGImageFunctions(text)
+	return svgURLPattern.ReplaceAllFunc(text, func(m []byte) []byte {
+		if isSafeSVGReference(string(svgURLPattern.FindSubmatch(m)[1])) {
+			return m
+		}
+		return []byte("none")
+	})
+}
+
+// isSafeSVGStyle checks if the CSS with decoded escapes has no imports and only safe references.
+func isSafeSVGStyle(css []byte) bool {
+	if svgImportPattern.Match(css) || !bytes.Equal(replaceSVGImageFunctions(css), css) {
+		return false
+	}
+	for _, m := range svgURLPattern.FindAllSubmatch(css, -1) {
+		if !isSafeSVGReference(string(m[1])) {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceSVGImageFunctions replaces the image functions referencing external resources with strings,
+// e.g. image-set("https://example.com/a.png" 1x), with "none".
+func replaceSVGImageFunctions(css []byte) []byte {
+	var out []byte
+	for {
+		loc := svgImageFunctionPattern.FindIndex(css)
+		if loc == nil {
+			return append(out, css...)
+		}
+		// find the closing parenthesis of the function
+		end, depth := len(css), 0
+		for i := loc[1] - 1; i < len(css); i++ {
+			if css[i] == '(' {
+				depth++
+			} else if css[i] == ')' {
+				if depth--; depth == 0 {
+					end = i + 1
+					break
+				}
+			}
+		}
+
+		safe := true
+		for _, m := range svgStringPattern.FindAllSubmatch(css[loc[1]:end], -1) {
+			if !isSafeSVGReference(string(m[1]) + string(m[2])) {
+				safe = false
+			}
+		}
+		if safe {
+			out = append(out, css[:end]...)
+		} else {
+			out = append(append(out, css[:loc[0]]...), "none"...)
+		}
+		css = css[end:]
+	}
+}
+
+// decodeCSSEscapes decodes the escapes of the CSS, e.g. "\72" or "\r" for "r",
+// the way browsers do when they tokenize it.
+func decodeCSSEscapes(css []byte) []byte {
+	if bytes.IndexByte(css, '\\') < 0 {
+		return css
+	}
+	out := make([]byte, 0, len(css))
+	for i := 0; i < len(css); i++ {
+		if css[i] != '\\' {
+			out = append(out, css[i])
+			continue
+		}
+		i++
+		if i == len(css) {
+			break
+		}
+
+		// up to 6 hex digits followed by an optional whitespace
+		j := i
+		for j < len(css) && j-i < 6 && isHexDigit(css[j]) {
+			j++
+		}
+		if j == i {
+			switch css[i] {
+			case '\r':
+				if i+1 < len(css) && css[i+1] == '\n' {
+					i++
+				}
+			case '\n', '\f':
+				// an escaped newline continues a string on the next line
+			default:
+				out = append(out, css[i])
+			}
+			continue
+		}
+		r, _ := strconv.ParseUint(string(css[i:j]), 16, 32)
+		if r == 0 || r > utf8.MaxRune || r >= 0xD800 && r <= 0xDFFF {
+			r = utf8.RuneError
+		}
+		out = utf8.AppendRune(out, rune(r))
+		if j < len(css) && (css[j] == ' ' || css[j] == '\t' || css[j] == '\n' || css[j] == '\f') {
+			j++
+		} else if j+1 < len(css) && css[j] == '\r' && css[j+1] == '\n' {
+			j += 2
+		}
+		i = j - 1
+	}
+	return out
+}
+
+// isHexDigit checks if the byte is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// isSafeSVGReference checks if the reference is a fragment of the image or a data URI of a raster image.
+func isSafeSVGReference(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	if strings.HasPrefix(ref, "#") {
+		return true
+	}
+	for _, t := range []string{"data:image/png", "data:image/jpeg", "data:image/gif", "data:image/webp"} {
+		if strings.HasPrefix(ref, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// xmlName returns the name as written in the document, with the namespace prefix.
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// writeXMLEscaped writes the text with the XML special characters escaped.
+// Unlike xml.EscapeText, it keeps the line breaks and tabs as is.
+func writeXMLEscaped(buf *bytes.Buffer, text []byte) {
+	for _, c := range text {
+		switch c {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '"':
+			buf.WriteString("&quot;")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
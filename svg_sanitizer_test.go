@@ -0,0 +1,167 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+const maliciousSVG = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- made with an editor -->
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10" onload="alert(1)">
+  <style>@import url(https://evil.example.com/a.css); rect { fill: url(#grad); stroke: url("https://evil.example.com/t.png") }</style>
+  <script type="text/javascript">alert(document.cookie)</script>
+  <defs><linearGradient id="grad"><stop offset="0" stop-color="red"/></linearGradient></defs>
+  <rect width="10" height="10" ONCLICK="alert(2)" style="fill: url(https://evil.example.com/track.png)"/>
+  <circle cx="5" cy="5" r="2" fill="url(#grad)"/>
+  <use xlink:href="#grad"/>
+  <use href="https://evil.example.com/sprite.svg#icon"/>
+  <a xlink:href="javascript:alert(3)"><text x="1" y="1">link &amp; text</text></a>
+  <a><animate attributeName="href" values="javascript:alert(4)"/><text>click</text></a>
+  <animate attributeName="opacity" values="0;1" dur="1s"/>
+  <foreignObject width="10" height="10"><div xmlns="http://www.w3.org/1999/xhtml"><iframe src="https://evil.example.com"></iframe></div></foreignObject>
+  <image href="data:image/png;base64,iVBORw0KGgo="/>
+  <image href="data:image/svg+xml;base64,PHN2Zz48L3N2Zz4="/>
+</svg>`
+
+func TestSanitizeSVG(t *testing.T) {
+	t.Run("malicious", func(t *testing.T) {
+		out, err := filemanager.SanitizeSVG([]byte(maliciousSVG))
+		require.NoError(t, err)
+		svg := string(out)
+
+		for _, removed := range []string{
+			"alert", "onload", "ONCLICK", "<script", "foreignObject", "iframe", "evil.example.com", "@import",
+			"javascript:", "made with an editor", "data:image/svg+xml",
+		} {
+			require.NotContains(t, svg, removed)
+		}
+		for _, kept := range []string{
+			`<?xml version="1.0" encoding="UTF-8"?>`,
+			`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10">`,
+			`fill: url(#grad)`, `stroke: none`, `<circle cx="5" cy="5" r="2" fill="url(#grad)">`,
+			`<use xlink:href="#grad">`, `<text x="1" y="1">link &amp; text</text>`, `<text>click</text>`,
+			`<animate attributeName="opacity" values="0;1" dur="1s">`, `<image href="data:image/png;base64,iVBORw0KGgo=">`,
+		} {
+			require.Contains(t, svg, kept)
+		}
+		require.Contains(t, svg, "<rect width=\"10\" height=\"10\"></rect>")
+	})
+
+	t.Run("css", func(t *testing.T) {
+		for _, doc := range []string{
+			`<svg xmlns="http://www.w3.org/2000/svg"><style>rect { fill: u\72l(https://evil.example.com/a.png) }</style></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><style>@\69mport "https://evil.example.com/a.css";</style></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><style>rect { fill: \75 \52 \4c("https://evil.example.com/a.png") }</style></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><style>rect { background: image-set("https://evil.example.com/a.png" 1x) }</style></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><style>rect { background: -webkit-image-set('https://evil.example.com/a.png' 1x) }</style></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><rect style="fill: u\rl(https://evil.example.com/a.png)"/></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"><rect style="background: image-set(&quot;https://evil.example.com/a.png&quot; 1x)"/></svg>`,
+		} {
+			out, err := filemanager.SanitizeSVG([]byte(doc))
+			require.NoError(t, err)
+			require.NotContains(t, string(out), "evil.example.com", doc)
+		}
+
+		out, err := filemanager.SanitizeSVG([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><style>` +
+			`text { font-family: "Open Sans"; content: "\2014" } rect { background: image-set("#a" 1x, url(https://evil.example.com/b.png) 2x) }` +
+			`</style></svg>`))
+		require.NoError(t, err)
+		// the escaped style sheet is removed as a whole, since it references an external resource
+		require.Equal(t, `<svg xmlns="http://www.w3.org/2000/svg"><style></style></svg>`, string(out))
+
+		out, err = filemanager.SanitizeSVG([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><style>` +
+			`text { font-family: "Open Sans"; content: "\2014" } rect { fill: url(#grad) }</style></svg>`))
+		require.NoError(t, err)
+		require.Contains(t, string(out), `content: &quot;\2014&quot;`)
+		require.Contains(t, string(out), `url(#grad)`)
+	})
+
+	t.Run("entities", func(t *testing.T) {
+		bomb := `<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY a "aaaaaaaaaa"><!ENTITY b "&a;&a;&a;&a;">]><svg xmlns="http://www.w3.org/2000/svg">&b;</svg>`
+		_, err := filemanager.SanitizeSVG([]byte(bomb))
+		require.ErrorIs(t, err, filemanager.ErrInvalidSVG)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := filemanager.SanitizeSVG([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><g></svg>`))
+		require.ErrorIs(t, err, filemanager.ErrInvalidSVG)
+	})
+
+	t.Run("not an svg root", func(t *testing.T) {
+		for _, doc := range []string{
+			`<html xmlns="http://www.w3.org/1999/xhtml"><head><meta http-equiv="refresh" content="0;url=https://evil.example.com"/></head></html>`,
+			`<svg xmlns="http://www.w3.org/1999/xhtml"></svg>`,
+			`<svg></svg>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"></svg><html xmlns="http://www.w3.org/1999/xhtml"></html>`,
+			``,
+		} {
+			_, err := filemanager.SanitizeSVG([]byte(doc))
+			require.ErrorIs(t, err, filemanager.ErrInvalidSVG, doc)
+		}
+
+		out, err := filemanager.SanitizeSVG([]byte(`<s:svg xmlns:s="http://www.w3.org/2000/svg"><s:rect/></s:svg>`))
+		require.NoError(t, err)
+		require.Equal(t, `<s:svg xmlns:s="http://www.w3.org/2000/svg"><s:rect></s:rect></s:svg>`, string(out))
+	})
+
+	t.Run("html elements", func(t *testing.T) {
+		out, err := filemanager.SanitizeSVG([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><g xmlns="http://www.w3.org/1999/xhtml">` +
+			`<meta http-equiv="refresh" content="0;url=https://evil.example.com"/><base href="https://evil.example.com/"/>` +
+			`<form action="https://evil.example.com"><input name="password"/></form>` +
+			`<button formaction="https://evil.example.com">go</button></g></svg>`))
+		require.NoError(t, err)
+		require.NotContains(t, string(out), "evil.example.com")
+		require.NotContains(t, string(out), "<form")
+		require.Contains(t, string(out), "<button>go</button>")
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+		)
+		require.NoError(t, err)
+
+		result, err := fm.UploadImage(context.Background(), strings.NewReader(maliciousSVG), "icons/logo.svg", "image/svg+xml")
+		require.NoError(t, err)
+		obj := s3Client.object("icons/logo.svg")
+		require.NotContains(t, string(obj.data), "alert")
+		require.Equal(t, int64(len(obj.data)), result.Size)
+		require.Empty(t, obj.contentDisposition)
+
+		// streamed images can't be sanitized, they're downloaded instead
+		_, err = fm.UploadStream(context.Background(), strings.NewReader(maliciousSVG), "icons/stream.svg", "image/svg+xml")
+		require.NoError(t, err)
+		require.Equal(t, "attachment", s3Client.object("icons/stream.svg").contentDisposition)
+	})
+
+	t.Run("attachment", func(t *testing.T) {
+		s3Client := newMemoryS3Client()
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithSVGAttachment(true),
+		)
+		require.NoError(t, err)
+
+		_, err = fm.Upload(context.Background(), bytes.NewReader([]byte(maliciousSVG)), "icons/logo.svg", "image/svg+xml")
+		require.NoError(t, err)
+		obj := s3Client.object("icons/logo.svg")
+		require.Equal(t, maliciousSVG, string(obj.data))
+		require.Equal(t, "attachment", obj.contentDisposition)
+
+		// other files are not affected
+		_, err = fm.Upload(context.Background(), bytes.NewReader([]byte("hello")), "notes.txt", "text/plain")
+		require.NoError(t, err)
+		require.Empty(t, s3Client.object("notes.txt").contentDisposition)
+	})
+}
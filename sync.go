@@ -0,0 +1,542 @@
+package filemanager
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// SyncRemotePrefix marks the remote side of a sync, e.g. "s3://site" is the "site" prefix in the bucket.
+const SyncRemotePrefix = "s3://"
+
+// Metadata keys stored with synced files, used to detect changes of files uploaded in multiple parts,
+// whose ETag is not the MD5 checksum of the content, and to keep the modification time of local files.
+const (
+	syncChecksumMetadataKey = "md5"
+	syncModTimeMetadataKey  = "mtime"
+)
+
+// Sync comparison modes, see SyncOptions.Compare.
+const (
+	// SyncCompareSize treats files with different sizes as changed.
+	SyncCompareSize SyncCompare = 1 << iota
+	// SyncCompareChecksum treats files with different MD5 checksums as changed.
+	SyncCompareChecksum
+	// SyncCompareModTime treats files with different modification times as changed.
+	SyncCompareModTime
+)
+
+// Sync operations, see SyncAction.Op.
+const (
+	SyncOpUpload   SyncOp = "upload"
+	SyncOpDownload SyncOp = "download"
+	SyncOpDelete   SyncOp = "delete"
+)
+
+type (
+	// SyncCompare is a set of flags defining how files are compared during a sync.
+	SyncCompare int
+
+	// SyncOp is an operation performed during a sync.
+	SyncOp string
+
+	// SyncOptions represents the options of a sync.
+	SyncOptions struct {
+		// Compare defines how the source and destination files are compared.
+		// Defaults to SyncCompareSize|SyncCompareChecksum.
+		Compare SyncCompare
+
+		// Delete removes the destination files that don't exist in the source.
+		Delete bool
+
+		// DryRun only reports the actions that would be performed, without changing any file.
+		DryRun bool
+
+		// Include is a list of glob patterns of the files to sync, see UploadDirOptions.Include for the syntax.
+		// The patterns are matched against the paths relative to the synced directories.
+		// If empty, all files are synced.
+		Include []string
+
+		// Exclude is a list of glob patterns of the files to skip, see Include for the syntax.
+		// Excluded destination files are never deleted.
+		Exclude []string
+
+		// Concurrency is the max number of files transferred in parallel.
+		// Defaults to the FileManager's upload concurrency.
+		Concurrency int
+
+		// UploadOptions are applied to the uploaded files, e.g. WithObjectACL("private") for a bucket served
+		// through signed URLs or WithObjectCacheControl. The files are uploaded with DefaultACL by default.
+		UploadOptions []UploadOption
+
+		// Output receives a line for each performed action, e.g. "upload: public/index.html to s3://site/index.html".
+		// In dry-run mode the lines are prefixed with "(dryrun)".
+		Output io.Writer
+	}
+
+	// SyncAction represents an operation performed during a sync.
+	SyncAction struct {
+		// Op is the performed operation.
+		Op SyncOp
+		// Path is the path of the file relative to the synced directories.
+		Path string
+		// Key is the object key of the file in the S3 bucket.
+		Key string
+		// Size is the size of the transferred file in bytes.
+		Size int64
+		// Reason describes why the action is performed, e.g. "new", "size changed" or "extraneous".
+		Reason string
+		// Error is the error occurred while performing the action, if any.
+		Error error
+	}
+
+	// syncEntry represents a file on either side of a sync.
+	syncEntry struct {
+		size     int64
+		modTime  time.Time
+		checksum string // hex-encoded MD5, empty if not known yet
+		local    string // path of a local file
+		key      string // object key of a remote file
+		loaded   bool   // whether the metadata of a remote file is loaded
+	}
+)
+
+// Sync mirrors the files between a local directory and a prefix in the S3 bucket, in either direction.
+// The remote side is written with the SyncRemotePrefix, e.g. Sync(ctx, "./public", "s3://site", opts)
+// uploads a local directory and Sync(ctx, "s3://backups", "./backups", opts) downloads a prefix.
+//
+// Only new and changed files are transferred, see SyncOptions.Compare.
+// Uploaded files store their MD5 checksum and modification time in the object metadata,
+// and downloaded files get the modification time of the source file,
+// so the following syncs can detect changes reliably.
+//
+// It returns the performed actions, ordered by path with deletions last,
+// and an error joining the errors of all failed actions.
+func (fm *FileManager) Sync(ctx context.Context, src, dst string, opts SyncOptions) ([]SyncAction, error) {
+	srcRemote, dstRemote := strings.HasPrefix(src, SyncRemotePrefix), strings.HasPrefix(dst, SyncRemotePrefix)
+	if srcRemote == dstRemote {
+		return nil, errors.Join(ErrInvalidSyncPath, errors.New("exactly one of the paths must start with "+SyncRemotePrefix))
+	}
+	if opts.Compare == 0 {
+		opts.Compare = SyncCompareSize | SyncCompareChecksum
+	}
+
+	var localDir, prefix string
+	if srcRemote {
+		prefix, localDir = strings.Trim(strings.TrimPrefix(src, SyncRemotePrefix), "/"), dst
+	} else {
+		localDir, prefix = src, strings.Trim(strings.TrimPrefix(dst, SyncRemotePrefix), "/")
+	}
+
+	localFiles, err := listLocalSyncFiles(localDir, !srcRemote)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToSync, err)
+	}
+	remoteFiles, err := fm.listRemoteSyncFiles(ctx, prefix)
+	if err != nil {
+		return nil, errors.Join(ErrFailedToSync, err)
+	}
+
+	srcFiles, dstFiles, op := localFiles, remoteFiles, SyncOpUpload
+	if srcRemote {
+		srcFiles, dstFiles, op = remoteFiles, localFiles, SyncOpDownload
+	}
+
+	// plan actions
+	var transfers, deletions []SyncAction
+	for _, p := range sortedSyncPaths(srcFiles) {
+		if !matchUploadDirPatterns(p, opts.Include, opts.Exclude) {
+			continue
+		}
+		srcFile, dstFile := srcFiles[p], dstFiles[p]
+		reason := "new"
+		if dstFile != nil {
+			var err error
+			if reason, err = fm.syncChangeReason(ctx, srcFile, dstFile, opts.Compare); err != nil {
+				return nil, errors.Join(ErrFailedToSync, err)
+			}
+			if reason == "" {
+				continue
+			}
+		}
+		transfers = append(transfers, SyncAction{Op: op, Path: p, Key: path.Join(prefix, p), Size: srcFile.size, Reason: reason})
+	}
+	if opts.Delete {
+		for _, p := range sortedSyncPaths(dstFiles) {
+			if srcFiles[p] != nil || !matchUploadDirPatterns(p, opts.Include, opts.Exclude) {
+				continue
+			}
+			deletions = append(deletions, SyncAction{Op: SyncOpDelete, Path: p, Key: path.Join(prefix, p), Reason: "extraneous"})
+		}
+	}
+
+	var mu sync.Mutex
+	report := func(a SyncAction) {
+		if opts.Output == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := fmt.Fprintln(opts.Output, formatSyncAction(a, localDir, srcRemote, opts.DryRun)); err != nil {
+			slog.ErrorContext(ctx, "failed to write sync output", "error", err)
+		}
+	}
+
+	if opts.DryRun {
+		actions := append(transfers, deletions...)
+		for _, a := range actions {
+			report(a)
+		}
+		return actions, nil
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = fm.concurrency
+	}
+
+	// transfer files first, so nothing is deleted if the sync is interrupted
+	run := func(actions []SyncAction, fn func(a *SyncAction) error) {
+		eg := errgroup.Group{}
+		eg.SetLimit(concurrency)
+		for i := range actions {
+			eg.Go(func() error {
+				a := &actions[i]
+				if err := ctx.Err(); err != nil {
+					a.Error = errors.Join(ErrFailedToSync, err)
+				} else {
+					a.Error = fn(a)
+				}
+				if a.Error == nil {
+					report(*a)
+				}
+				return nil
+			})
+		}
+		_ = eg.Wait() // errors are reported per action
+	}
+	run(transfers, func(a *SyncAction) error {
+		if op == SyncOpDownload {
+			return fm.downloadSyncFile(ctx, srcFiles[a.Path], filepath.Join(localDir, filepath.FromSlash(a.Path)))
+		}
+		return fm.uploadSyncFile(ctx, srcFiles[a.Path], a.Key, opts.UploadOptions)
+	})
+	run(deletions, func(a *SyncAction) error {
+		if op == SyncOpDownload {
+			if err := os.Remove(dstFiles[a.Path].local); err != nil {
+				return errors.Join(ErrFailedToSync, err)
+			}
+			return nil
+		}
+		return fm.remove(ctx, a.Key)
+	})
+
+	actions := append(transfers, deletions...)
+	var errs []error
+	for _, a := range actions {
+		if a.Error != nil {
+			errs = append(errs, a.Error)
+		}
+	}
+
+	return actions, errors.Join(errs...)
+}
+
+// syncChangeReason compares the source and destination files.
+// It returns the reason to transfer the file, or an empty string if the files are equal.
+func (fm *FileManager) syncChangeReason(ctx context.Context, src, dst *syncEntry, compare SyncCompare) (string, error) {
+	if compare&SyncCompareSize != 0 && src.size != dst.size {
+		return "size changed", nil
+	}
+
+	if compare&SyncCompareChecksum != 0 {
+		srcSum, err := fm.syncChecksum(ctx, src)
+		if err != nil {
+			return "", err
+		}
+		dstSum, err := fm.syncChecksum(ctx, dst)
+		if err != nil {
+			return "", err
+		}
+		// a checksum is unknown for multipart files not uploaded by Sync, fall back to the other comparisons then
+		if srcSum != "" && dstSum != "" && srcSum != dstSum {
+			return "checksum changed", nil
+		}
+	}
+
+	if compare&SyncCompareModTime != 0 {
+		srcTime, err := fm.syncModTime(ctx, src)
+		if err != nil {
+			return "", err
+		}
+		dstTime, err := fm.syncModTime(ctx, dst)
+		if err != nil {
+			return "", err
+		}
+		// S3 stores the modification time with a second precision
+		if !srcTime.Truncate(time.Second).Equal(dstTime.Truncate(time.Second)) {
+			return "modification time changed", nil
+		}
+	}
+
+	return "", nil
+}
+
+// syncChecksum returns the MD5 checksum of the file, computing or loading it if needed.
+func (fm *FileManager) syncChecksum(ctx context.Context, e *syncEntry) (string, error) {
+	if e.checksum != "" {
+		return e.checksum, nil
+	}
+	if e.local != "" {
+		file, err := os.Open(e.local)
+		if err != nil {
+			return "", err
+		}
+		defer func(file *os.File) {
+			if err := file.Close(); err != nil {
+				slog.ErrorContext(ctx, "failed to close file", "error", err)
+			}
+		}(file)
+		e.checksum, err = md5Checksum(file)
+		return e.checksum, err
+	}
+	if err := fm.loadSyncMetadata(ctx, e); err != nil {
+		return "", err
+	}
+	return e.checksum, nil
+}
+
+// syncModTime returns the modification time of the file, loading it if needed.
+func (fm *FileManager) syncModTime(ctx context.Context, e *syncEntry) (time.Time, error) {
+	if e.local != "" {
+		return e.modTime, nil
+	}
+	if err := fm.loadSyncMetadata(ctx, e); err != nil {
+		return time.Time{}, err
+	}
+	return e.modTime, nil
+}
+
+// loadSyncMetadata loads the checksum and the modification time stored in the metadata of a remote file.
+// The last modification time of the object is used if the file was not uploaded by Sync.
+func (fm *FileManager) loadSyncMetadata(ctx context.Context, e *syncEntry) error {
+	if e.loaded {
+		return nil
+	}
+	info, err := fm.Stat(ctx, e.key)
+	if err != nil {
+		return err
+	}
+	if sum := info.Metadata[syncChecksumMetadataKey]; sum != "" {
+		e.checksum = sum
+	}
+	if t, err := time.Parse(time.RFC3339Nano, info.Metadata[syncModTimeMetadataKey]); err == nil {
+		e.modTime = t
+	}
+	e.loaded = true
+	return nil
+}
+
+// uploadSyncFile uploads a local file with the options, storing its checksum and modification time in the object metadata.
+func (fm *FileManager) uploadSyncFile(ctx context.Context, e *syncEntry, key string, opts []UploadOption) error {
+	checksum, err := fm.syncChecksum(ctx, e)
+	if err != nil {
+		return errors.Join(ErrFailedToUploadFile, err)
+	}
+
+	file, err := os.Open(e.local)
+	if err != nil {
+		return errors.Join(ErrFailedToUploadFile, err)
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close file", "error", err)
+		}
+	}(file)
+
+	contentType, err := detectFileContentType(e.local, file)
+	if err != nil {
+		return errors.Join(ErrFailedToUploadFile, err)
+	}
+	opts = append(opts[:len(opts):len(opts)], WithObjectMetadata(map[string]string{
+		syncChecksumMetadataKey: checksum,
+		syncModTimeMetadataKey:  e.modTime.UTC().Format(time.RFC3339Nano),
+	}), WithTrustedContent())
+
+	if e.size > fm.partSize {
+		_, err = fm.UploadStream(ctx, file, key, contentType, opts...)
+	} else {
+		_, err = fm.Upload(ctx, file, key, contentType, opts...)
+	}
+	return err
+}
+
+// downloadSyncFile downloads a remote file to the local path, keeping its modification time.
+// The file is written to a temporary file first, so an interrupted download doesn't corrupt the existing file.
+func (fm *FileManager) downloadSyncFile(ctx context.Context, e *syncEntry, name string) error {
+	if err := fm.loadSyncMetadata(ctx, e); err != nil {
+		return errors.Join(ErrFailedToGetFile, err)
+	}
+
+	r, err := fm.Open(ctx, e.key)
+	if err != nil {
+		return errors.Join(ErrFailedToGetFile, err)
+	}
+	defer func(r io.Closer) {
+		if err := r.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close file", "key", e.key, "error", err)
+		}
+	}(r)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return errors.Join(ErrFailedToSync, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return errors.Join(ErrFailedToSync, err)
+	}
+	defer func(name string) {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.ErrorContext(ctx, "failed to remove temporary file", "error", err)
+		}
+	}(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return errors.Join(ErrFailedToGetFile, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Join(ErrFailedToSync, err)
+	}
+	if err := os.Chtimes(tmp.Name(), e.modTime, e.modTime); err != nil {
+		return errors.Join(ErrFailedToSync, err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return errors.Join(ErrFailedToSync, err)
+	}
+
+	return nil
+}
+
+// listLocalSyncFiles returns the regular files of the local directory by their slash-separated relative paths.
+// A missing directory has no files, unless it must exist.
+func listLocalSyncFiles(dir string, mustExist bool) (map[string]*syncEntry, error) {
+	files := make(map[string]*syncEntry)
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) && !mustExist {
+		return files, nil
+	}
+
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = &syncEntry{size: info.Size(), modTime: info.ModTime(), local: name}
+		return nil
+	})
+
+	return files, err
+}
+
+// listRemoteSyncFiles returns the files under the prefix in the S3 bucket by their paths relative to the prefix.
+// Files with paths which can't be mapped to a local file, e.g. "a/../../b" or "/a", are skipped,
+// so downloaded files can't be written outside the local directory.
+func (fm *FileManager) listRemoteSyncFiles(ctx context.Context, prefix string) (map[string]*syncEntry, error) {
+	if prefix != "" {
+		prefix += "/"
+	}
+	objects, err := fm.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*syncEntry, len(objects))
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "/") {
+			continue // directory marker
+		}
+		if fm.isInternalKey(obj.Key) {
+			continue // never downloaded or deleted, e.g. quarantined files
+		}
+		p := strings.TrimPrefix(obj.Key, prefix)
+		if !fs.ValidPath(p) || !filepath.IsLocal(filepath.FromSlash(p)) {
+			slog.WarnContext(ctx, "skipped file with invalid sync path", "key", obj.Key)
+			continue
+		}
+		e := &syncEntry{size: obj.Size, modTime: obj.LastModified, key: obj.Key}
+		// the ETag of a file uploaded in a single part is the MD5 checksum of its content
+		if etag := strings.Trim(obj.ETag, `"`); len(etag) == hex.EncodedLen(md5.Size) {
+			e.checksum = etag
+		}
+		files[p] = e
+	}
+
+	return files, nil
+}
+
+// sortedSyncPaths returns the sorted paths of the files.
+func sortedSyncPaths(files map[string]*syncEntry) []string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// formatSyncAction formats the action as an output line.
+// Deleted files are remote files when uploading and local files when downloading.
+func formatSyncAction(a SyncAction, localDir string, download, dryRun bool) string {
+	local := filepath.Join(localDir, filepath.FromSlash(a.Path))
+	remote := SyncRemotePrefix + a.Key
+
+	var line string
+	switch {
+	case a.Op == SyncOpUpload:
+		line = fmt.Sprintf("upload: %s to %s", local, remote)
+	case a.Op == SyncOpDownload:
+		line = fmt.Sprintf("download: %s to %s", remote, local)
+	case download:
+		line = fmt.Sprintf("delete: %s", local)
+	default:
+		line = fmt.Sprintf("delete: %s", remote)
+	}
+
+	if dryRun {
+		return "(dryrun) " + line
+	}
+	return line
+}
+
+// md5Checksum returns the hex-encoded MD5 checksum of the content.
+func md5Checksum(r io.Reader) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
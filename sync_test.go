@@ -0,0 +1,207 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestSync(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	src := t.TempDir()
+	writeFile := func(dir, name, content string) {
+		t.Helper()
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	writeFile(src, "index.html", "<html></html>")
+	writeFile(src, "css/style.css", "body{}")
+	writeFile(src, "js/app.js.map", "{}")
+
+	t.Run("invalid paths", func(t *testing.T) {
+		_, err := fm.Sync(ctx, src, t.TempDir(), filemanager.SyncOptions{})
+		require.ErrorIs(t, err, filemanager.ErrInvalidSyncPath)
+
+		_, err = fm.Sync(ctx, "s3://a", "s3://b", filemanager.SyncOptions{})
+		require.ErrorIs(t, err, filemanager.ErrInvalidSyncPath)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		var out bytes.Buffer
+		actions, err := fm.Sync(ctx, src, "s3://site", filemanager.SyncOptions{
+			DryRun:  true,
+			Exclude: []string{"*.map"},
+			Output:  &out,
+		})
+		require.NoError(t, err)
+		require.Len(t, actions, 2)
+		require.Equal(t, "(dryrun) upload: "+filepath.Join(src, "css", "style.css")+" to s3://site/css/style.css\n"+
+			"(dryrun) upload: "+filepath.Join(src, "index.html")+" to s3://site/index.html\n", out.String())
+		require.Nil(t, s3Client.object("site/index.html"))
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		actions, err := fm.Sync(ctx, src, "s3://site", filemanager.SyncOptions{Exclude: []string{"*.map"}})
+		require.NoError(t, err)
+		require.Len(t, actions, 2)
+		for _, a := range actions {
+			require.Equal(t, filemanager.SyncOpUpload, a.Op)
+			require.Equal(t, "new", a.Reason)
+		}
+
+		obj := s3Client.object("site/index.html")
+		require.NotNil(t, obj)
+		require.Equal(t, "text/html; charset=utf-8", obj.contentType)
+
+		info, err := fm.Stat(ctx, "site/index.html")
+		require.NoError(t, err)
+		require.Equal(t, "c83301425b2ad1d496473a5ff3d9ecca", info.Metadata["md5"])
+		require.NotEmpty(t, info.Metadata["mtime"])
+		require.Nil(t, s3Client.object("site/js/app.js.map"))
+	})
+
+	t.Run("unchanged files are skipped", func(t *testing.T) {
+		actions, err := fm.Sync(ctx, src, "s3://site", filemanager.SyncOptions{Exclude: []string{"*.map"}})
+		require.NoError(t, err)
+		require.Empty(t, actions)
+	})
+
+	t.Run("changed and extraneous files", func(t *testing.T) {
+		writeFile(src, "index.html", "<html>v2</html>")
+		writeFile(src, "css/style.css", "body{x}")
+		require.NoError(t, os.Remove(filepath.Join(src, "js", "app.js.map")))
+		_, err := fm.Upload(ctx, bytes.NewReader([]byte("old")), "site/old.txt", "text/plain")
+		require.NoError(t, err)
+
+		actions, err := fm.Sync(ctx, src, "s3://site", filemanager.SyncOptions{Delete: true})
+		require.NoError(t, err)
+		require.Equal(t, []filemanager.SyncAction{
+			{Op: filemanager.SyncOpUpload, Path: "css/style.css", Key: "site/css/style.css", Size: 7, Reason: "size changed"},
+			{Op: filemanager.SyncOpUpload, Path: "index.html", Key: "site/index.html", Size: 15, Reason: "size changed"},
+			{Op: filemanager.SyncOpDelete, Path: "old.txt", Key: "site/old.txt", Reason: "extraneous"},
+		}, actions)
+		require.Equal(t, "body{x}", string(s3Client.object("site/css/style.css").data))
+		require.Nil(t, s3Client.object("site/old.txt"))
+	})
+
+	t.Run("checksum changed", func(t *testing.T) {
+		writeFile(src, "index.html", "<html>v3</html>")
+
+		actions, err := fm.Sync(ctx, src, "s3://site", filemanager.SyncOptions{})
+		require.NoError(t, err)
+		require.Len(t, actions, 1)
+		require.Equal(t, "checksum changed", actions[0].Reason)
+		require.Equal(t, "<html>v3</html>", string(s3Client.object("site/index.html").data))
+	})
+
+	t.Run("download", func(t *testing.T) {
+		dst := t.TempDir()
+		writeFile(dst, "stale.txt", "stale")
+
+		actions, err := fm.Sync(ctx, "s3://site", dst, filemanager.SyncOptions{Delete: true})
+		require.NoError(t, err)
+		require.Len(t, actions, 3)
+		require.Equal(t, filemanager.SyncOpDownload, actions[0].Op)
+		require.Equal(t, filemanager.SyncOpDelete, actions[2].Op)
+
+		data, err := os.ReadFile(filepath.Join(dst, "index.html"))
+		require.NoError(t, err)
+		require.Equal(t, "<html>v3</html>", string(data))
+		require.NoFileExists(t, filepath.Join(dst, "stale.txt"))
+
+		// the modification time of the source file is kept
+		srcInfo, err := os.Stat(filepath.Join(src, "index.html"))
+		require.NoError(t, err)
+		dstInfo, err := os.Stat(filepath.Join(dst, "index.html"))
+		require.NoError(t, err)
+		require.True(t, srcInfo.ModTime().Equal(dstInfo.ModTime()))
+
+		// nothing to do on the second run, even when comparing modification times
+		actions, err = fm.Sync(ctx, "s3://site", dst, filemanager.SyncOptions{
+			Compare: filemanager.SyncCompareSize | filemanager.SyncCompareModTime,
+		})
+		require.NoError(t, err)
+		require.Empty(t, actions)
+	})
+
+	t.Run("download keys outside of the directory", func(t *testing.T) {
+		for _, key := range []string{"evil/../../escape.txt", "evil//abs.txt", "evil/ok.txt"} {
+			_, err := fm.Upload(ctx, bytes.NewReader([]byte("evil")), key, "text/plain", filemanager.WithTrustedContent())
+			require.NoError(t, err)
+		}
+
+		dst := filepath.Join(t.TempDir(), "a", "b")
+		actions, err := fm.Sync(ctx, "s3://evil", dst, filemanager.SyncOptions{})
+		require.NoError(t, err)
+		require.Len(t, actions, 1)
+		require.Equal(t, "ok.txt", actions[0].Path)
+		require.FileExists(t, filepath.Join(dst, "ok.txt"))
+		require.NoFileExists(t, filepath.Join(dst, "..", "..", "escape.txt"))
+	})
+
+	t.Run("modification time changed", func(t *testing.T) {
+		future := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(src, "index.html"), future, future))
+
+		actions, err := fm.Sync(ctx, src, "s3://site", filemanager.SyncOptions{})
+		require.NoError(t, err)
+		require.Empty(t, actions)
+
+		actions, err = fm.Sync(ctx, src, "s3://site", filemanager.SyncOptions{Compare: filemanager.SyncCompareModTime})
+		require.NoError(t, err)
+		require.Len(t, actions, 1)
+		require.Equal(t, "modification time changed", actions[0].Reason)
+	})
+
+	t.Run("upload options", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(dir, "report.txt", "private")
+
+		_, err := fm.Sync(ctx, dir, "s3://reports", filemanager.SyncOptions{
+			UploadOptions: []filemanager.UploadOption{filemanager.WithObjectACL("private")},
+		})
+		require.NoError(t, err)
+		obj := s3Client.object("reports/report.txt")
+		require.NotNil(t, obj)
+		require.Equal(t, "private", obj.acl)
+		require.NotEmpty(t, obj.metadata["md5"])
+	})
+
+	t.Run("internal files are skipped", func(t *testing.T) {
+		// files the file manager keeps for itself are never downloaded or deleted from the bucket root
+		_, err := fm.Upload(ctx, bytes.NewReader([]byte("pending")), "quarantine/upload.txt", "text/plain")
+		require.NoError(t, err)
+		_, err = fm.Upload(ctx, bytes.NewReader([]byte("{}")), ".tus/upload.info", "application/json")
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		writeFile(dir, "index.html", "<html></html>")
+		actions, err := fm.Sync(ctx, dir, "s3://", filemanager.SyncOptions{Delete: true, DryRun: true})
+		require.NoError(t, err)
+		for _, a := range actions {
+			require.NotEqual(t, "quarantine/upload.txt", a.Key)
+			require.NotEqual(t, ".tus/upload.info", a.Key)
+		}
+
+		_, err = fm.Sync(ctx, "s3://", dir, filemanager.SyncOptions{})
+		require.NoError(t, err)
+		require.NoFileExists(t, filepath.Join(dir, "quarantine", "upload.txt"))
+		require.NoFileExists(t, filepath.Join(dir, ".tus", "upload.info"))
+	})
+}
@@ -0,0 +1,738 @@
+package filemanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// TusVersion - supported version of the tus protocol
+	TusVersion = "1.0.0"
+	// TusExtensions - supported extensions of the tus protocol
+	TusExtensions = "creation,termination,expiration"
+	// TusFileURLHeader - response header with the URL of the uploaded file, set once the upload is completed
+	TusFileURLHeader = "Upload-File-URL"
+	// DefaultTusExpiration - default time after which incomplete tus uploads expire
+	DefaultTusExpiration = 24 * time.Hour
+
+	// tusStatePrefix - prefix of the tus upload state objects
+	tusStatePrefix = ".tus"
+	// tusOffsetContentType - content type of PATCH requests
+	tusOffsetContentType = "application/offset+octet-stream"
+)
+
+type (
+	// TusHandler is an http.Handler implementing the tus resumable upload protocol 1.0.0
+	// with the creation, termination and expiration extensions.
+	// See https://tus.io/protocols/resumable-upload for the protocol specification.
+	//
+	// Uploaded chunks are stored as parts of an S3 multipart upload in the FileManager's bucket.
+	// Chunks smaller than the part size are kept in a temporary object until enough data is received.
+	// The upload state is stored in the bucket as well, so the handler is stateless,
+	// but concurrent requests to the same upload are only rejected within a single process.
+	//
+	// If a Scanner is configured, the file is uploaded to the private quarantine prefix and scanned
+	// once the upload is completed, see FileManager.ScanQuarantined. Infected and rejected files are removed
+	// and the last request fails with 422 Unprocessable Entity. If the file can't be scanned,
+	// it stays in the quarantine until FileManager.ScanQuarantine publishes it.
+	TusHandler struct {
+		fm         *FileManager
+		urlPath    string
+		maxSize    int64
+		expiration time.Duration
+		onComplete func(ctx context.Context, upload TusUpload)
+
+		mu    sync.Mutex
+		locks map[string]struct{}
+	}
+
+	// TusUpload represents the state of a tus upload.
+	TusUpload struct {
+		// ID is the upload ID used in the upload URL.
+		ID string `json:"id"`
+		// Key is the object key of the uploaded file.
+		Key string `json:"key"`
+		// QuarantineKey is the private key the file is uploaded to until it's scanned, if a scanner is configured.
+		QuarantineKey string `json:"quarantine_key,omitempty"`
+		// UploadID is the ID of the S3 multipart upload.
+		UploadID string `json:"upload_id"`
+		// Size is the total size of the file in bytes.
+		Size int64 `json:"size"`
+		// ContentType is the content type of the file.
+		ContentType string `json:"content_type"`
+		// Metadata is the metadata sent by the client in the Upload-Metadata header.
+		Metadata map[string]string `json:"metadata,omitempty"`
+		// CreatedAt is the time the upload was created.
+		CreatedAt time.Time `json:"created_at"`
+		// ExpiresAt is the time after which an incomplete upload expires.
+		ExpiresAt time.Time `json:"expires_at"`
+		// URL is the URL of the uploaded file, set once the upload is completed.
+		URL string `json:"url,omitempty"`
+	}
+
+	// TusOption represents a tus handler option function.
+	TusOption func(*TusHandler)
+)
+
+// WithTusMaxSize sets the max size of a file uploaded through the tus handler.
+// Defaults to the FileManager's max file size.
+func WithTusMaxSize(maxSize int64) TusOption {
+	return func(h *TusHandler) {
+		if maxSize > 0 {
+			h.maxSize = maxSize
+		}
+	}
+}
+
+// WithTusExpiration sets the time after which incomplete uploads expire.
+func WithTusExpiration(expiration time.Duration) TusOption {
+	return func(h *TusHandler) {
+		if expiration > 0 {
+			h.expiration = expiration
+		}
+	}
+}
+
+// WithTusCompleteHook sets a function called after an upload is completed.
+func WithTusCompleteHook(fn func(ctx context.Context, upload TusUpload)) TusOption {
+	return func(h *TusHandler) {
+		h.onComplete = fn
+	}
+}
+
+// NewTusHandler creates a new tus handler backed by the file manager.
+// The urlPath is the URL path the handler is mounted at, e.g. "/files/".
+// It's used to build upload URLs and to extract the upload ID from the request path.
+func NewTusHandler(fm *FileManager, urlPath string, opts ...TusOption) *TusHandler {
+	h := &TusHandler{
+		fm:         fm,
+		urlPath:    "/" + strings.Trim(urlPath, "/"),
+		maxSize:    fm.maxFileSize,
+		expiration: DefaultTusExpiration,
+		locks:      make(map[string]struct{}),
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	return h
+}
+
+// Completed returns true if the upload is completed.
+func (u TusUpload) Completed() bool {
+	return u.URL != ""
+}
+
+// storageKey returns the key the file is uploaded to.
+func (u TusUpload) storageKey() string {
+	if u.QuarantineKey != "" {
+		return u.QuarantineKey
+	}
+	return u.Key
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", TusVersion)
+
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+		method = override
+	}
+
+	if method != http.MethodOptions && r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.urlPath), "/")
+	switch {
+	case method == http.MethodOptions:
+		h.options(w)
+	case id == "" && method == http.MethodPost:
+		h.create(w, r)
+	case id != "" && !strings.Contains(id, "/") && method == http.MethodHead:
+		h.head(w, r, id)
+	case id != "" && !strings.Contains(id, "/") && method == http.MethodPatch:
+		h.patch(w, r, id)
+	case id != "" && !strings.Contains(id, "/") && method == http.MethodDelete:
+		h.terminate(w, r, id)
+	case id == "":
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// RemoveExpired removes the state and the uploaded parts of expired incomplete uploads.
+// It returns the number of removed uploads.
+func (h *TusHandler) RemoveExpired(ctx context.Context) (int, error) {
+	uploads, err := h.fm.tusUploads(ctx)
+	if err != nil {
+		return 0, errors.Join(ErrFailedToRemoveFiles, err)
+	}
+
+	removed := 0
+	for _, upload := range uploads {
+		if upload.Completed() || time.Now().Before(upload.ExpiresAt) {
+			continue
+		}
+		if err := h.removeUpload(ctx, upload); err != nil {
+			return removed, errors.Join(ErrFailedToRemoveFiles, err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// options handles OPTIONS requests.
+func (h *TusHandler) options(w http.ResponseWriter) {
+	w.Header().Set("Tus-Version", TusVersion)
+	w.Header().Set("Tus-Extension", TusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// create handles POST requests of the creation extension.
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, "invalid Upload-Length header", http.StatusBadRequest)
+		return
+	}
+	if size > h.maxSize {
+		http.Error(w, "upload is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata header", http.StatusBadRequest)
+		return
+	}
+
+	id, err := randomID()
+	if err != nil {
+		h.serverError(w, r, err)
+		return
+	}
+
+	filename := metadata["filename"]
+	contentType := metadata["filetype"]
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	contentType, opts, err := h.fm.streamedContent(contentType, nil)
+	if err != nil {
+		http.Error(w, "content type is not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+	o := newUploadOptions(opts)
+
+	upload := TusUpload{
+		ID:          id,
+		Key:         h.fm.objectKey(id + strings.ToLower(path.Ext(filename))),
+		Size:        size,
+		ContentType: contentType,
+		Metadata:    metadata,
+		CreatedAt:   time.Now().UTC(),
+		ExpiresAt:   time.Now().UTC().Add(h.expiration),
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		ACL:                aws.String(o.acl),
+		ContentDisposition: stringOrNil(o.contentDisposition),
+		ContentType:        aws.String(upload.ContentType),
+		Bucket:             aws.String(h.fm.bucket),
+		Key:                aws.String(upload.Key),
+	}
+	if h.fm.scanner != nil {
+		// the file is private until it's scanned and promoted with the intended ACL
+		upload.QuarantineKey = h.fm.quarantineKey(upload.Key)
+		input.ACL = aws.String(s3.ObjectCannedACLPrivate)
+		input.Key = aws.String(upload.QuarantineKey)
+		input.Metadata = map[string]*string{quarantineACLMetadata: aws.String(o.acl)}
+	}
+	resp, err := h.fm.s3.CreateMultipartUploadWithContext(r.Context(), input)
+	if err != nil {
+		h.serverError(w, r, err)
+		return
+	}
+	upload.UploadID = aws.StringValue(resp.UploadId)
+
+	if err := h.saveUpload(r.Context(), upload); err != nil {
+		h.fm.abortMultipartUpload(r.Context(), upload.storageKey(), upload.UploadID)
+		h.serverError(w, r, err)
+		return
+	}
+
+	// there is nothing to wait for if the file is empty
+	if upload.Size == 0 {
+		if upload, err = h.complete(r.Context(), upload); err != nil {
+			h.completeError(w, r, err)
+			return
+		}
+		w.Header().Set(TusFileURLHeader, upload.URL)
+	} else {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	}
+
+	w.Header().Set("Location", path.Join(h.urlPath, id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head handles HEAD requests, returning the current offset of the upload.
+func (h *TusHandler) head(w http.ResponseWriter, r *http.Request, id string) {
+	upload, ok := h.getUpload(w, r, id)
+	if !ok {
+		return
+	}
+
+	offset := upload.Size
+	if !upload.Completed() {
+		var err error
+		if offset, _, _, err = h.offset(r.Context(), upload); err != nil {
+			h.serverError(w, r, err)
+			return
+		}
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	} else {
+		w.Header().Set(TusFileURLHeader, upload.URL)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	if len(upload.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatTusMetadata(upload.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch handles PATCH requests, appending the request body to the upload.
+func (h *TusHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != tusOffsetContentType {
+		http.Error(w, "invalid Content-Type header", http.StatusUnsupportedMediaType)
+		return
+	}
+	clientOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || clientOffset < 0 {
+		http.Error(w, "invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+
+	if !h.lock(id) {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer h.unlock(id)
+
+	upload, ok := h.getUpload(w, r, id)
+	if !ok {
+		return
+	}
+	if upload.Completed() {
+		if clientOffset != upload.Size {
+			http.Error(w, "offset mismatch", http.StatusConflict)
+			return
+		}
+		w.Header().Set(TusFileURLHeader, upload.URL)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Size, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	offset, parts, pending, err := h.offset(r.Context(), upload)
+	if err != nil {
+		h.serverError(w, r, err)
+		return
+	}
+	if clientOffset != offset {
+		http.Error(w, "offset mismatch", http.StatusConflict)
+		return
+	}
+
+	offset, err = h.writeChunk(r.Context(), upload, r.Body, parts, pending)
+	if err != nil {
+		h.serverError(w, r, err)
+		return
+	}
+
+	if offset == upload.Size {
+		if upload, err = h.complete(r.Context(), upload); err != nil {
+			h.completeError(w, r, err)
+			return
+		}
+		w.Header().Set(TusFileURLHeader, upload.URL)
+	} else {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate handles DELETE requests of the termination extension.
+func (h *TusHandler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.lock(id) {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer h.unlock(id)
+
+	upload, ok := h.getUpload(w, r, id)
+	if !ok {
+		return
+	}
+	if err := h.removeUpload(r.Context(), upload); err != nil {
+		h.serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getUpload loads the upload state and writes an error response if the upload can't be used.
+func (h *TusHandler) getUpload(w http.ResponseWriter, r *http.Request, id string) (TusUpload, bool) {
+	upload, err := h.loadUpload(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+		} else {
+			h.serverError(w, r, err)
+		}
+		return TusUpload{}, false
+	}
+	if !upload.Completed() && time.Now().After(upload.ExpiresAt) {
+		http.Error(w, "upload is expired", http.StatusGone)
+		return TusUpload{}, false
+	}
+	return upload, true
+}
+
+// offset returns the number of bytes received for the upload,
+// the size of the uploaded parts and the size of the pending chunk, which is not uploaded as a part yet.
+func (h *TusHandler) offset(ctx context.Context, upload TusUpload) (offset int64, parts []*s3.Part, pending int64, err error) {
+	parts, err = h.listParts(ctx, upload)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	for _, part := range parts {
+		offset += aws.Int64Value(part.Size)
+	}
+
+	resp, err := h.fm.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(h.fm.bucket),
+		Key:    aws.String(h.pendingKey(upload.ID)),
+	})
+	if err := handleS3Error(err); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return offset, parts, 0, nil
+		}
+		return 0, nil, 0, err
+	}
+	pending = aws.Int64Value(resp.ContentLength)
+
+	return offset + pending, parts, pending, nil
+}
+
+// listParts returns all uploaded parts of the multipart upload.
+func (h *TusHandler) listParts(ctx context.Context, upload TusUpload) ([]*s3.Part, error) {
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(h.fm.bucket),
+		Key:      aws.String(upload.storageKey()),
+		UploadId: aws.String(upload.UploadID),
+	}
+
+	var parts []*s3.Part
+	for {
+		resp, err := h.fm.s3.ListPartsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, resp.Parts...)
+		if !aws.BoolValue(resp.IsTruncated) {
+			break
+		}
+		input.PartNumberMarker = resp.NextPartNumberMarker
+	}
+
+	return parts, nil
+}
+
+// writeChunk uploads the request body as parts of the multipart upload.
+// The pending chunk is prepended to the body, and the data which doesn't fill a whole part
+// is stored as a new pending chunk, unless it's the end of the file.
+// Data received before a read error is stored as well, so the client can resume from there.
+// It returns the new offset of the upload.
+func (h *TusHandler) writeChunk(ctx context.Context, upload TusUpload, body io.Reader, parts []*s3.Part, pending int64) (int64, error) {
+	var offset int64
+	for _, part := range parts {
+		offset += aws.Int64Value(part.Size)
+	}
+
+	r := io.LimitReader(body, upload.Size-offset-pending)
+	if pending > 0 {
+		data, err := h.fm.getObject(ctx, h.pendingKey(upload.ID))
+		if err != nil {
+			return 0, err
+		}
+		r = io.MultiReader(bytes.NewReader(data), r)
+	}
+
+	partNumber := int64(len(parts)) + 1
+	for {
+		buf := make([]byte, h.fm.partSize)
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			slog.WarnContext(ctx, "tus upload interrupted", "id", upload.ID, "error", readErr)
+		}
+
+		switch {
+		case n == len(buf) || (n > 0 && offset+int64(n) == upload.Size):
+			// upload a whole part or the last part of the file
+			if _, err := h.fm.uploadPart(ctx, upload.storageKey(), upload.UploadID, partNumber, buf[:n]); err != nil {
+				return 0, err
+			}
+			partNumber++
+			if pending > 0 {
+				if err := h.fm.remove(ctx, h.pendingKey(upload.ID)); err != nil {
+					return 0, err
+				}
+				pending = 0
+			}
+		case n > 0:
+			// keep the rest of the data until the next request
+			if err := h.fm.putPrivateObject(ctx, h.pendingKey(upload.ID), buf[:n], tusOffsetContentType); err != nil {
+				return 0, err
+			}
+			pending = 0
+		}
+		offset += int64(n)
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	return offset, nil
+}
+
+// complete completes the multipart upload and marks the upload as completed.
+func (h *TusHandler) complete(ctx context.Context, upload TusUpload) (TusUpload, error) {
+	if upload.Size == 0 {
+		// S3 requires at least one part, so empty files are uploaded as an empty part
+		if _, err := h.fm.uploadPart(ctx, upload.storageKey(), upload.UploadID, 1, nil); err != nil {
+			return upload, err
+		}
+	}
+
+	parts, err := h.listParts(ctx, upload)
+	if err != nil {
+		return upload, err
+	}
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+	}
+
+	if _, err := h.fm.s3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(h.fm.bucket),
+		Key:             aws.String(upload.storageKey()),
+		UploadId:        aws.String(upload.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	}); err != nil {
+		return upload, errors.Join(ErrFailedToUploadFile, err)
+	}
+
+	if upload.QuarantineKey != "" {
+		result, err := h.fm.ScanQuarantined(ctx, upload.Key)
+		switch {
+		case result != nil && result.Status == ScanPending:
+			// the file is published once ScanQuarantine scans it
+			slog.ErrorContext(ctx, "failed to scan tus upload", "key", upload.Key, "error", err)
+		case err != nil:
+			return upload, err
+		case result.Status == ScanInfected:
+			if err := h.fm.remove(ctx, h.stateKey(upload.ID)); err != nil {
+				return upload, err
+			}
+			return upload, &InfectedError{Signature: result.Signature}
+		case result.Status == ScanRejected:
+			if err := h.fm.remove(ctx, h.stateKey(upload.ID)); err != nil {
+				return upload, err
+			}
+			return upload, ErrRejectedContent
+		}
+	}
+
+	upload.URL = h.fm.fileAbsolutePath(upload.Key)
+	if err := h.saveUpload(ctx, upload); err != nil {
+		return upload, err
+	}
+	if h.onComplete != nil {
+		h.onComplete(ctx, upload)
+	}
+
+	return upload, nil
+}
+
+// removeUpload aborts the multipart upload, if it's not completed, and removes the upload state.
+func (h *TusHandler) removeUpload(ctx context.Context, upload TusUpload) error {
+	if !upload.Completed() {
+		_, err := h.fm.s3.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(h.fm.bucket),
+			Key:      aws.String(upload.storageKey()),
+			UploadId: aws.String(upload.UploadID),
+		})
+		if err := handleS3Error(err); err != nil && !errors.Is(err, ErrNotFound) {
+			return errors.Join(ErrFailedToRemoveFile, err)
+		}
+	}
+	if err := h.fm.remove(ctx, h.pendingKey(upload.ID)); err != nil {
+		return err
+	}
+	return h.fm.remove(ctx, h.stateKey(upload.ID))
+}
+
+// loadUpload loads the upload state from the bucket.
+func (h *TusHandler) loadUpload(ctx context.Context, id string) (TusUpload, error) {
+	return h.fm.loadTusUpload(ctx, h.stateKey(id))
+}
+
+// saveUpload stores the upload state in the bucket.
+func (h *TusHandler) saveUpload(ctx context.Context, upload TusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return h.fm.putPrivateObject(ctx, h.stateKey(upload.ID), data, "application/json")
+}
+
+// tusUploads returns the states of all tus uploads stored in the bucket, see TusHandler.
+func (fm *FileManager) tusUploads(ctx context.Context) ([]TusUpload, error) {
+	objects, err := fm.List(ctx, fm.objectKey(tusStatePrefix)+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []TusUpload
+	for _, obj := range objects {
+		if path.Ext(obj.Key) != ".info" {
+			continue
+		}
+		upload, err := fm.loadTusUpload(ctx, obj.Key)
+		if errors.Is(err, ErrNotFound) {
+			continue // removed since listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// loadTusUpload loads the state of a tus upload stored under the key.
+func (fm *FileManager) loadTusUpload(ctx context.Context, key string) (TusUpload, error) {
+	data, err := fm.getObject(ctx, key)
+	if err != nil {
+		return TusUpload{}, err
+	}
+	var upload TusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return TusUpload{}, errors.Join(ErrUnexpected, err)
+	}
+	return upload, nil
+}
+
+// stateKey returns the object key of the upload state.
+func (h *TusHandler) stateKey(id string) string {
+	return h.fm.objectKey(path.Join(tusStatePrefix, id+".info"))
+}
+
+// pendingKey returns the object key of the pending chunk of the upload.
+func (h *TusHandler) pendingKey(id string) string {
+	return h.fm.objectKey(path.Join(tusStatePrefix, id+".part"))
+}
+
+// lock marks the upload as being modified by a request.
+// It returns false if the upload is already locked.
+func (h *TusHandler) lock(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.locks[id]; ok {
+		return false
+	}
+	h.locks[id] = struct{}{}
+	return true
+}
+
+// unlock releases the upload lock.
+func (h *TusHandler) unlock(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.locks, id)
+}
+
+// completeError writes the error response of a failed upload completion.
+func (h *TusHandler) completeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrInfected) || errors.Is(err, ErrRejectedContent) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	h.serverError(w, r, err)
+}
+
+// serverError logs the error and writes an internal server error response.
+func (h *TusHandler) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "tus request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// parseTusMetadata parses the Upload-Metadata header.
+// The header consists of comma-separated key-value pairs, where the value is base64 encoded.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+// formatTusMetadata formats the metadata as the Upload-Metadata header.
+func formatTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,219 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestTusHandler(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+		filemanager.WithPartSize(filemanager.MinPartSize),
+		filemanager.WithMaxFileSize(32<<20),
+	)
+	require.NoError(t, err)
+
+	var completed filemanager.TusUpload
+	h := filemanager.NewTusHandler(fm, "/files/", filemanager.WithTusCompleteHook(
+		func(_ context.Context, upload filemanager.TusUpload) { completed = upload },
+	))
+
+	do := func(method, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		req.Header.Set("Tus-Resumable", filemanager.TusVersion)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	patch := func(location string, offset int, chunk []byte) *httptest.ResponseRecorder {
+		return do(http.MethodPatch, location, chunk, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		})
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), (filemanager.MinPartSize+20)/10)
+
+	t.Run("options", func(t *testing.T) {
+		rec := do(http.MethodOptions, "/files/", nil, nil)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, filemanager.TusExtensions, rec.Header().Get("Tus-Extension"))
+		require.Equal(t, strconv.Itoa(32<<20), rec.Header().Get("Tus-Max-Size"))
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/files/", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		rec := do(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": strconv.Itoa(33 << 20)})
+		require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("resumable upload", func(t *testing.T) {
+		rec := do(http.MethodPost, "/files/", nil, map[string]string{
+			"Upload-Length":   strconv.Itoa(len(content)),
+			"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("video.mp4")),
+		})
+		require.Equal(t, http.StatusCreated, rec.Code)
+		location := rec.Header().Get("Location")
+		require.NotEmpty(t, location)
+		require.NotEmpty(t, rec.Header().Get("Upload-Expires"))
+
+		// the first chunk is smaller than a part, so it's kept pending
+		first := 3 << 20
+		rec = patch(location, 0, content[:first])
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, strconv.Itoa(first), rec.Header().Get("Upload-Offset"))
+
+		// wrong offset
+		rec = patch(location, 10, content[first:])
+		require.Equal(t, http.StatusConflict, rec.Code)
+
+		// the client resumes from the offset reported by HEAD
+		rec = do(http.MethodHead, location, nil, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, strconv.Itoa(first), rec.Header().Get("Upload-Offset"))
+		require.Equal(t, strconv.Itoa(len(content)), rec.Header().Get("Upload-Length"))
+
+		second := len(content) - 10
+		rec = patch(location, first, content[first:second])
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, strconv.Itoa(second), rec.Header().Get("Upload-Offset"))
+		require.Empty(t, rec.Header().Get(filemanager.TusFileURLHeader))
+
+		rec = patch(location, second, content[second:])
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, strconv.Itoa(len(content)), rec.Header().Get("Upload-Offset"))
+
+		url := rec.Header().Get(filemanager.TusFileURLHeader)
+		require.Equal(t, "https://cdn.example.com/uploads/"+completed.Key, url)
+		require.Equal(t, url, completed.URL)
+		require.Regexp(t, `^[0-9a-f]{32}\.mp4$`, completed.Key)
+		require.Equal(t, "video/mp4", completed.ContentType)
+
+		obj := s3Client.object(completed.Key)
+		require.NotNil(t, obj)
+		require.Equal(t, content, obj.data)
+
+		// completed uploads report the full offset
+		rec = do(http.MethodHead, location, nil, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, strconv.Itoa(len(content)), rec.Header().Get("Upload-Offset"))
+		require.Equal(t, url, rec.Header().Get(filemanager.TusFileURLHeader))
+	})
+
+	t.Run("termination", func(t *testing.T) {
+		rec := do(http.MethodPost, "/files/", nil, map[string]string{"Upload-Length": "100"})
+		require.Equal(t, http.StatusCreated, rec.Code)
+		location := rec.Header().Get("Location")
+
+		rec = patch(location, 0, []byte("partial"))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = do(http.MethodDelete, location, nil, nil)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+
+		rec = do(http.MethodHead, location, nil, nil)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		uploads, err := s3Client.ListMultipartUploadsWithContext(context.Background(), &s3.ListMultipartUploadsInput{})
+		require.NoError(t, err)
+		require.Empty(t, uploads.Uploads)
+	})
+}
+
+func TestTusHandler_Scan(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fakeClamd(t, l)
+
+	upload := func(h *filemanager.TusHandler, content string) (*httptest.ResponseRecorder, string) {
+		req := httptest.NewRequest(http.MethodPost, "/files/", nil)
+		req.Header.Set("Tus-Resumable", filemanager.TusVersion)
+		req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+		req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("file.txt")))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		location := rec.Header().Get("Location")
+
+		req = httptest.NewRequest(http.MethodPatch, location, strings.NewReader(content))
+		req.Header.Set("Tus-Resumable", filemanager.TusVersion)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", "0")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec, location
+	}
+	newFileManager := func(s3Client *memoryS3Client, scanner filemanager.Scanner) *filemanager.FileManager {
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(s3Client),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+			filemanager.WithScanner(scanner),
+		)
+		require.NoError(t, err)
+		return fm
+	}
+
+	s3Client := newMemoryS3Client()
+	h := filemanager.NewTusHandler(newFileManager(s3Client, filemanager.ClamAVScanner{Address: l.Addr().String()}), "/files/")
+
+	t.Run("clean", func(t *testing.T) {
+		rec, _ := upload(h, "hello")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		key := strings.TrimPrefix(rec.Header().Get(filemanager.TusFileURLHeader), "https://cdn.example.com/uploads/")
+		obj := s3Client.object(key)
+		require.NotNil(t, obj)
+		require.Equal(t, []byte("hello"), obj.data)
+		require.Equal(t, defaultACL, obj.acl)
+		require.Nil(t, s3Client.object("quarantine/"+key))
+	})
+
+	t.Run("infected", func(t *testing.T) {
+		rec, location := upload(h, eicar)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		key := strings.TrimPrefix(location, "/files/") + ".txt"
+		require.Nil(t, s3Client.object(key))
+		require.Nil(t, s3Client.object("quarantine/"+key))
+
+		req := httptest.NewRequest(http.MethodHead, location, nil)
+		req.Header.Set("Tus-Resumable", filemanager.TusVersion)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("scan failure", func(t *testing.T) {
+		// the file stays private until it's scanned
+		rec, _ := upload(filemanager.NewTusHandler(newFileManager(s3Client, failingScanner{}), "/files/"), "hello")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		key := strings.TrimPrefix(rec.Header().Get(filemanager.TusFileURLHeader), "https://cdn.example.com/uploads/")
+		require.Nil(t, s3Client.object(key))
+		obj := s3Client.object("quarantine/" + key)
+		require.NotNil(t, obj)
+		require.Equal(t, "private", obj.acl)
+	})
+}
@@ -0,0 +1,180 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type (
+	// UploadDirOptions represents the options of a directory upload.
+	UploadDirOptions struct {
+		// Concurrency is the max number of files uploaded in parallel.
+		// Defaults to the FileManager's upload concurrency.
+		Concurrency int
+
+		// Include is a list of glob patterns of the files to upload, see path.Match for the syntax.
+		// A pattern is matched against the path relative to the uploaded directory and against the file name,
+		// e.g. "*.css" matches all CSS files and "assets/*" matches the files in the assets directory.
+		// If empty, all files are uploaded.
+		Include []string
+
+		// Exclude is a list of glob patterns of the files to skip, see Include for the syntax.
+		Exclude []string
+
+		// OnProgress is called after each file is uploaded or failed to upload.
+		// It's called from multiple goroutines, but never concurrently.
+		OnProgress func(progress UploadProgress)
+	}
+
+	// UploadProgress represents the progress of a directory upload.
+	UploadProgress struct {
+		// File is the result of the last uploaded file.
+		File UploadResult
+		// Uploaded is the number of processed files, including failed ones.
+		Uploaded int
+		// Total is the total number of files to upload.
+		Total int
+		// UploadedBytes is the size of the processed files in bytes.
+		UploadedBytes int64
+		// TotalBytes is the total size of the files to upload in bytes.
+		TotalBytes int64
+	}
+)
+
+// UploadDir uploads all files of the file system to the S3 bucket under the prefix, e.g. a generated static site
+// from os.DirFS or assets from an embed.FS. The directory structure is kept in the object keys.
+// Files are uploaded in parallel, limited by the concurrency option.
+// The content type is detected by the file extension, or by the file content if the extension is unknown.
+//
+// It returns the results of all uploaded files, ordered by path,
+// and an error joining the errors of all failed uploads.
+func (fm *FileManager) UploadDir(ctx context.Context, fsys fs.FS, prefix string, opts UploadDirOptions) ([]UploadResult, error) {
+	// collect files to upload
+	var (
+		files      []UploadResult
+		totalBytes int64
+	)
+	if err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !matchUploadDirPatterns(p, opts.Include, opts.Exclude) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, UploadResult{
+			OriginalName: p,
+			Key:          path.Join(prefix, p),
+			Size:         info.Size(),
+		})
+		totalBytes += info.Size()
+		return nil
+	}); err != nil {
+		return nil, errors.Join(ErrFailedToUploadFile, err)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = fm.concurrency
+	}
+
+	var (
+		mu       sync.Mutex
+		progress = UploadProgress{Total: len(files), TotalBytes: totalBytes}
+	)
+
+	// upload files in parallel
+	eg := errgroup.Group{}
+	eg.SetLimit(concurrency)
+	for i := range files {
+		eg.Go(func() error {
+			res := &files[i]
+			res.ContentType, res.URL, res.Error = fm.uploadFSFile(ctx, fsys, res.OriginalName, res.Key, WithTrustedContent())
+
+			if opts.OnProgress != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				progress.File = *res
+				progress.Uploaded++
+				progress.UploadedBytes += res.Size
+				opts.OnProgress(progress)
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait() // errors are reported per file
+
+	var errs []error
+	for _, res := range files {
+		if res.Error != nil {
+			errs = append(errs, res.Error)
+		}
+	}
+
+	return files, errors.Join(errs...)
+}
+
+// uploadFSFile uploads a single file of the file system to the S3 bucket.
+// It returns the detected content type and the URL of the uploaded file.
+func (fm *FileManager) uploadFSFile(ctx context.Context, fsys fs.FS, name, key string, opts ...UploadOption) (string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", errors.Join(ErrFailedToUploadFile, err)
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", "", errors.Join(ErrFailedToUploadFile, err)
+	}
+	defer func(file fs.File) {
+		if err := file.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close file", "error", err)
+		}
+	}(file)
+
+	// files of os.DirFS and embed.FS are seekable, other file systems may need a streaming upload
+	if rs, ok := file.(io.ReadSeeker); ok {
+		contentType, err := detectFileContentType(name, rs)
+		if err != nil {
+			return "", "", errors.Join(ErrFailedToUploadFile, err)
+		}
+		url, err := fm.Upload(ctx, rs, key, contentType, opts...)
+		return contentType, url, err
+	}
+
+	contentType, r, err := detectStreamContentType(name, file)
+	if err != nil {
+		return "", "", errors.Join(ErrFailedToUploadFile, err)
+	}
+	url, err := fm.UploadStream(ctx, r, key, contentType, opts...)
+	return contentType, url, err
+}
+
+// matchUploadDirPatterns checks if the file path matches the include patterns and doesn't match the exclude patterns.
+func matchUploadDirPatterns(p string, include, exclude []string) bool {
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(p)); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(include) > 0 && !match(include) {
+		return false
+	}
+	return !match(exclude)
+}
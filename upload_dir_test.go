@@ -0,0 +1,61 @@
+package filemanager_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestUploadDir(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"index.html":         {Data: []byte("<html></html>")},
+		"css/style.css":      {Data: []byte("body{}")},
+		"js/app.js":          {Data: []byte("console.log(1)")},
+		"js/app.js.map":      {Data: []byte("{}")},
+		"images/logo":        {Data: []byte("\x89PNG\r\n\x1a\n")},
+		"drafts/draft.html":  {Data: []byte("draft")},
+		"drafts/images/a.js": {Data: []byte("draft")},
+	}
+
+	var progress []filemanager.UploadProgress
+	results, err := fm.UploadDir(context.Background(), fsys, "site", filemanager.UploadDirOptions{
+		Concurrency: 2,
+		Exclude:     []string{"*.map", "drafts/*", "drafts/*/*"},
+		OnProgress: func(p filemanager.UploadProgress) {
+			progress = append(progress, p)
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	require.Len(t, progress, 4)
+	require.Equal(t, 4, progress[3].Uploaded)
+	require.Equal(t, 4, progress[3].Total)
+	require.Equal(t, progress[3].TotalBytes, progress[3].UploadedBytes)
+
+	expected := map[string]string{
+		"site/index.html":    "text/html; charset=utf-8",
+		"site/css/style.css": "text/css; charset=utf-8",
+		"site/js/app.js":     "text/javascript; charset=utf-8",
+		"site/images/logo":   "image/png",
+	}
+	for _, res := range results {
+		require.NoError(t, res.Error)
+		require.Equal(t, "https://cdn.example.com/uploads/"+res.Key, res.URL)
+		require.Equal(t, expected[res.Key], res.ContentType, res.Key)
+		require.Equal(t, expected[res.Key], s3Client.object(res.Key).contentType)
+	}
+	require.Nil(t, s3Client.object("site/js/app.js.map"))
+	require.Nil(t, s3Client.object("site/drafts/draft.html"))
+}
@@ -0,0 +1,435 @@
+package filemanager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+type (
+	// UploadHandler is an http.Handler that uploads files from a multipart form
+	// and responds with JSON describing the uploaded files.
+	//
+	// If a single file is uploaded, the response is a JSON object with the url, key, size, type and name
+	// of the file, and the URLs of the generated image variants, if any. If several files are uploaded, the response is a JSON array of such objects.
+	// Errors are returned as a JSON object with the error code and message, and the matching status code:
+	// 400 for a missing field, 401/403 for a failed authorization, 413 for a too large file,
+	// 415 for an unsupported content type and 422 for a failed custom validation.
+	// Quarantined files are reported with 202 Accepted and their scan status, see WithUploadQuarantine.
+	//
+	// Files are uploaded one by one, after all of them are validated. If a file fails to upload,
+	// the files uploaded before it are removed along with their image variants. Quarantined files
+	// can't be removed reliably while they're scanned, so they remain and are published once they're clean.
+	UploadHandler struct {
+		fm             *FileManager
+		fieldNames     []string
+		maxSize        int64
+		maxRequestSize int64
+		allowedTypes   []string
+		keyFunc        func(r *http.Request, header *multipart.FileHeader) (string, error)
+		validate       func(r *http.Request, header *multipart.FileHeader) error
+		authorize      func(r *http.Request) error
+		quarantine     bool
+	}
+
+	// UploadHandlerOption represents an upload handler option function.
+	UploadHandlerOption func(*UploadHandler)
+
+	// uploadResponse represents an uploaded file in the upload handler response.
+	uploadResponse struct {
+		URL  string `json:"url"`
+		Key  string `json:"key"`
+		Size int64  `json:"size"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+		// Variants contains the URLs of the generated image variants, if any.
+		Variants map[string]string `json:"variants,omitempty"`
+		// Image contains the attributes of the uploaded image, if the image info is enabled.
+		Image *ImageInfo `json:"image,omitempty"`
+		// Placeholder contains the placeholders of the uploaded image, if the placeholders are enabled.
+		Placeholder *ImagePlaceholder `json:"placeholder,omitempty"`
+		// Hash contains the perceptual hashes of the uploaded image, if the image hashes are enabled.
+		Hash *ImageHash `json:"hash,omitempty"`
+		// Status is the scan status of a quarantined file, see WithUploadQuarantine.
+		Status ScanStatus `json:"status,omitempty"`
+	}
+
+	// uploadErrorResponse represents an error in the upload handler response.
+	uploadErrorResponse struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+			Field   string `json:"field,omitempty"`
+		} `json:"error"`
+	}
+)
+
+// WithUploadFieldNames sets the names of the form fields containing files.
+// Defaults to "file".
+func WithUploadFieldNames(names ...string) UploadHandlerOption {
+	return func(h *UploadHandler) {
+		if len(names) > 0 {
+			h.fieldNames = names
+		}
+	}
+}
+
+// WithUploadMaxSize sets the max size of a single uploaded file.
+// Defaults to the FileManager's max file size.
+func WithUploadMaxSize(maxSize int64) UploadHandlerOption {
+	return func(h *UploadHandler) {
+		if maxSize > 0 {
+			h.maxSize = maxSize
+		}
+	}
+}
+
+// WithUploadMaxRequestSize sets the max size of the whole request body.
+// Defaults to the max file size plus 1MB for the rest of the form.
+func WithUploadMaxRequestSize(maxSize int64) UploadHandlerOption {
+	return func(h *UploadHandler) {
+		if maxSize > 0 {
+			h.maxRequestSize = maxSize
+		}
+	}
+}
+
+// WithUploadAllowedTypes sets the allowed content types, e.g. "image/*".
+// By default, any content type is allowed.
+func WithUploadAllowedTypes(types ...string) UploadHandlerOption {
+	return func(h *UploadHandler) {
+		h.allowedTypes = types
+	}
+}
+
+// WithUploadKeyFunc sets the function generating an object key for an uploaded file.
+// By default, a random name with the file extension is used, so clients can't overwrite other files.
+func WithUploadKeyFunc(fn func(r *http.Request, header *multipart.FileHeader) (string, error)) UploadHandlerOption {
+	return func(h *UploadHandler) {
+		if fn != nil {
+			h.keyFunc = fn
+		}
+	}
+}
+
+// WithUploadValidator sets a custom validation function called for every file before it's uploaded.
+// Errors wrapping ErrFileTooLarge or ErrUnsupportedContentType are reported with the matching status code,
+// other errors are reported as 422 Unprocessable Entity.
+func WithUploadValidator(fn func(r *http.Request, header *multipart.FileHeader) error) UploadHandlerOption {
+	return func(h *UploadHandler) {
+		h.validate = fn
+	}
+}
+
+// WithUploadQuarantine sets whether uploaded files are quarantined until they're scanned, see FileManager.Quarantine.
+// The handler responds with 202 Accepted and the pending status of the files, instead of waiting for the scan.
+// The FileManager must be configured with a scanner.
+func WithUploadQuarantine(quarantine bool) UploadHandlerOption {
+	return func(h *UploadHandler) {
+		h.quarantine = quarantine
+	}
+}
+
+// WithUploadAuthorizer sets a function called before the request body is read.
+// If it returns an error, the request is rejected with 401 Unauthorized if the error wraps ErrUnauthorized,
+// or with 403 Forbidden otherwise.
+func WithUploadAuthorizer(fn func(r *http.Request) error) UploadHandlerOption {
+	return func(h *UploadHandler) {
+		h.authorize = fn
+	}
+}
+
+// NewUploadHandler creates a new upload handler backed by the file manager.
+func NewUploadHandler(fm *FileManager, opts ...UploadHandlerOption) *UploadHandler {
+	h := &UploadHandler{
+		fm:         fm,
+		fieldNames: []string{"file"},
+		maxSize:    fm.maxFileSize,
+		keyFunc: func(r *http.Request, header *multipart.FileHeader) (string, error) {
+			return randomFilename(r, header.Filename)
+		},
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	if h.maxRequestSize == 0 {
+		h.maxRequestSize = h.maxSize + 1<<20
+	}
+	return h
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		h.writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", http.StatusText(http.StatusMethodNotAllowed), "")
+		return
+	}
+
+	if h.authorize != nil {
+		if err := h.authorize(r); err != nil {
+			if errors.Is(err, ErrUnauthorized) {
+				h.writeError(w, http.StatusUnauthorized, "unauthorized", err.Error(), "")
+			} else {
+				h.writeError(w, http.StatusForbidden, "forbidden", err.Error(), "")
+			}
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxRequestSize)
+	if err := r.ParseMultipartForm(h.maxSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.writeError(w, http.StatusRequestEntityTooLarge, "file_too_large", ErrFileTooLarge.Error(), "")
+		} else {
+			h.writeError(w, http.StatusBadRequest, "invalid_form", "invalid multipart form", "")
+		}
+		return
+	}
+	defer func() {
+		if err := r.MultipartForm.RemoveAll(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to remove multipart form files", "error", err)
+		}
+	}()
+
+	// collect and validate all files before uploading any of them
+	type formFile struct {
+		header *multipart.FileHeader
+		field  string
+		key    string
+		ctype  string
+	}
+	var files []formFile
+	for _, field := range h.fieldNames {
+		for _, header := range r.MultipartForm.File[field] {
+			ctype, err := detectContentType(header)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, "invalid_form", "failed to read file", field)
+				return
+			}
+			if err := h.validateFile(r, header, ctype); err != nil {
+				h.validationError(w, r, err, field)
+				return
+			}
+			key, err := h.keyFunc(r, header)
+			if err != nil {
+				h.serverError(w, r, err)
+				return
+			}
+			files = append(files, formFile{header: header, field: field, key: key, ctype: ctype})
+		}
+	}
+	if len(files) == 0 {
+		h.writeError(w, http.StatusBadRequest, "missed_field", ErrMissedFormField.Error(), h.fieldNames[0])
+		return
+	}
+
+	result := make([]uploadResponse, 0, len(files))
+	for _, f := range files {
+		if h.quarantine {
+			q, err := h.fm.quarantineMultipartFile(r.Context(), f.header, f.key, f.ctype)
+			if err != nil {
+				h.uploadError(w, r, err, f.field)
+				return
+			}
+			result = append(result, uploadResponse{
+				URL:    q.URL,
+				Key:    f.key,
+				Size:   f.header.Size,
+				Type:   q.ContentType,
+				Name:   f.header.Filename,
+				Status: q.Status,
+			})
+			continue
+		}
+
+		upload, err := h.fm.uploadMultipartFile(r.Context(), f.header, f.key, f.ctype)
+		if err != nil {
+			h.removeUploaded(r.Context(), result)
+			h.uploadError(w, r, err, f.field)
+			return
+		}
+		result = append(result, uploadResponse{
+			URL:         upload.URL,
+			Key:         f.key,
+			Size:        f.header.Size,
+			Type:        upload.ContentType,
+			Name:        f.header.Filename,
+			Variants:    upload.Variants,
+			Image:       upload.Image,
+			Placeholder: upload.Placeholder,
+			Hash:        upload.Hash,
+		})
+	}
+
+	// quarantined files are published once they're scanned
+	status := http.StatusCreated
+	if h.quarantine {
+		status = http.StatusAccepted
+	}
+	if len(result) == 1 {
+		writeJSON(w, status, result[0])
+		return
+	}
+	writeJSON(w, status, result)
+}
+
+// removeUploaded removes the files uploaded before a failed upload, along with their image variants
+// and the kept watermark originals, so a failed request doesn't leave some of its files behind.
+func (h *UploadHandler) removeUploaded(ctx context.Context, uploaded []uploadResponse) {
+	ctx = context.WithoutCancel(ctx)
+	for _, upload := range uploaded {
+		keys := []string{upload.Key}
+		for _, variantURL := range upload.Variants {
+			keys = append(keys, filenameFromURL(h.fm.fileAbsolutePath(""), variantURL))
+		}
+		if h.fm.watermark != nil {
+			if key := h.fm.watermark.originalKey(upload.Key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			if err := h.fm.remove(ctx, key); err != nil {
+				slog.ErrorContext(ctx, "failed to remove uploaded file", "key", key, "error", err)
+			}
+		}
+	}
+}
+
+// validateFile checks the file size, the content type and runs the custom validator.
+func (h *UploadHandler) validateFile(r *http.Request, header *multipart.FileHeader, contentType string) error {
+	if header.Size > h.maxSize {
+		return ErrFileTooLarge
+	}
+	if !matchContentType(contentType, h.allowedTypes) {
+		return ErrUnsupportedContentType
+	}
+	if h.fm.contentSafety.Action(contentType) == ContentReject {
+		return ErrUnsafeContentType
+	}
+	if h.validate != nil {
+		return h.validate(r, header)
+	}
+	return nil
+}
+
+// validationError writes an error response for a failed file validation.
+func (h *UploadHandler) validationError(w http.ResponseWriter, r *http.Request, err error, field string) {
+	switch {
+	case errors.Is(err, ErrFileTooLarge):
+		h.writeError(w, http.StatusRequestEntityTooLarge, "file_too_large", err.Error(), field)
+	case errors.Is(err, ErrUnsupportedContentType):
+		h.writeError(w, http.StatusUnsupportedMediaType, "unsupported_content_type", err.Error(), field)
+	case errors.Is(err, ErrUnsafeContentType):
+		h.writeError(w, http.StatusUnsupportedMediaType, "unsafe_content_type", err.Error(), field)
+	case errors.Is(err, ErrInfected):
+		h.writeError(w, http.StatusUnprocessableEntity, "infected_file", err.Error(), field)
+	default:
+		h.writeError(w, http.StatusUnprocessableEntity, "invalid_file", err.Error(), field)
+	}
+}
+
+// uploadError writes an error response for a failed upload.
+// Errors caused by the file content are reported as validation errors.
+func (h *UploadHandler) uploadError(w http.ResponseWriter, r *http.Request, err error, field string) {
+	var limitErr *ImageLimitError
+	if errors.As(err, &limitErr) {
+		h.validationError(w, r, limitErr, field)
+		return
+	}
+	if errors.Is(err, ErrInvalidSVG) {
+		h.validationError(w, r, ErrInvalidSVG, field)
+		return
+	}
+	var infectedErr *InfectedError
+	if errors.As(err, &infectedErr) {
+		h.validationError(w, r, infectedErr, field)
+		return
+	}
+	h.serverError(w, r, err)
+}
+
+// serverError logs the error and writes an internal server error response.
+func (h *UploadHandler) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "upload request failed", "error", err)
+	h.writeError(w, http.StatusInternalServerError, "internal_error", http.StatusText(http.StatusInternalServerError), "")
+}
+
+// writeError writes a JSON error response.
+func (h *UploadHandler) writeError(w http.ResponseWriter, status int, code, message, field string) {
+	var resp uploadErrorResponse
+	resp.Error.Code = code
+	resp.Error.Message = message
+	resp.Error.Field = field
+	writeJSON(w, status, resp)
+}
+
+// genericContentTypes are the content types http.DetectContentType returns for files
+// it can't tell apart, e.g. JSON, CSV and SVG files or office documents.
+// XML documents are told apart from other files, see detectContentType.
+var genericContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"text/plain":               true,
+	"application/zip":          true,
+}
+
+// detectContentType returns the content type of the uploaded file, detected from the file content.
+// The content type sent by the client is used only if the detected type is generic, e.g. text/plain,
+// or the same, so a file can't be validated and stored as a type it's not, e.g. an HTML page sent as an image.
+func detectContentType(header *multipart.FileHeader) (string, error) {
+	sniffed, err := sniffContentType(header)
+	if err != nil {
+		return "", err
+	}
+
+	ctype := header.Header.Get("Content-Type")
+	if ctype == "" || ctype == "application/octet-stream" {
+		return sniffed, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return sniffed, nil
+	}
+	sniffedType, _, _ := mime.ParseMediaType(sniffed)
+	switch {
+	case sniffedType == "text/xml":
+		// an XML document may be sent as a more specific XML type, e.g. an SVG image, but not as an image or a text file
+		if !isXMLType(mediaType) {
+			return sniffed, nil
+		}
+	case !genericContentTypes[sniffedType] && mediaType != sniffedType:
+		return sniffed, nil
+	}
+	return ctype, nil
+}
+
+// isXMLType checks if the media type is an XML type, e.g. application/xml or image/svg+xml.
+func isXMLType(mediaType string) bool {
+	return mediaType == "text/xml" || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// sniffContentType detects the content type of the uploaded file from its first 512 bytes.
+func sniffContentType(header *multipart.FileHeader) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer func(file multipart.File) {
+		if err := file.Close(); err != nil {
+			slog.Error("failed to close file", "error", err)
+		}
+	}(file)
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
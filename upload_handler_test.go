@@ -0,0 +1,157 @@
+package filemanager_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestUploadHandler(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	h := filemanager.NewUploadHandler(fm,
+		filemanager.WithUploadFieldNames("avatar"),
+		filemanager.WithUploadMaxSize(1024),
+		filemanager.WithUploadAllowedTypes("image/*"),
+		filemanager.WithUploadAuthorizer(func(r *http.Request) error {
+			if r.Header.Get("Authorization") == "" {
+				return filemanager.ErrUnauthorized
+			}
+			return nil
+		}),
+	)
+
+	newRequest := func(field, filename, contentType string, content []byte) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="`+field+`"; filename="`+filename+`"`)
+		header.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(header)
+		require.NoError(t, err)
+		_, err = part.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer token")
+		return req
+	}
+
+	errorCode := func(rec *httptest.ResponseRecorder) string {
+		var resp struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Error.Code
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"missing field", newRequest("file", "a.png", "image/png", []byte("png")), http.StatusBadRequest, "missed_field"},
+		{"too large", newRequest("avatar", "a.png", "image/png", make([]byte, 2048)), http.StatusRequestEntityTooLarge, "file_too_large"},
+		{"unsupported type", newRequest("avatar", "a.html", "text/html", []byte("<html>")), http.StatusUnsupportedMediaType, "unsupported_content_type"},
+		{"disguised type", newRequest("avatar", "a.png", "image/png", []byte("<html><script>alert(1)</script>")), http.StatusUnsupportedMediaType, "unsupported_content_type"},
+		{"disguised xml", newRequest("avatar", "a.png", "image/png", []byte(`<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml"/>`)), http.StatusUnsupportedMediaType, "unsupported_content_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tt.req)
+			require.Equal(t, tt.status, rec.Code)
+			require.Equal(t, tt.code, errorCode(rec))
+		})
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		req := newRequest("avatar", "a.png", "image/png", []byte("png"))
+		req.Header.Del("Authorization")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("custom validation", func(t *testing.T) {
+		h := filemanager.NewUploadHandler(fm,
+			filemanager.WithUploadFieldNames("avatar"),
+			filemanager.WithUploadValidator(func(_ *http.Request, header *multipart.FileHeader) error {
+				return errors.New("name is not allowed")
+			}),
+		)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newRequest("avatar", "a.png", "image/png", []byte("png")))
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newRequest("avatar", "me.png", "image/png", []byte("png data")))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		key := resp["key"].(string)
+		require.Regexp(t, `^[0-9a-f]{32}\.png$`, key)
+		require.JSONEq(t, `{
+			"url": "https://cdn.example.com/uploads/`+key+`",
+			"key": "`+key+`",
+			"size": 8,
+			"type": "image/png",
+			"name": "me.png"
+		}`, rec.Body.String())
+		require.Equal(t, []byte("png data"), s3Client.object(key).data)
+	})
+
+	t.Run("failed request is removed", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, f := range []struct{ name, ctype, content string }{
+			{"a.png", "image/png", "png data"},
+			{"b.svg", "image/svg+xml", "<svg"},
+		} {
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", `form-data; name="avatar"; filename="`+f.name+`"`)
+			header.Set("Content-Type", f.ctype)
+			part, err := writer.CreatePart(header)
+			require.NoError(t, err)
+			_, err = part.Write([]byte(f.content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, writer.Close())
+
+		h := filemanager.NewUploadHandler(fm,
+			filemanager.WithUploadFieldNames("avatar"),
+			filemanager.WithUploadKeyFunc(func(_ *http.Request, header *multipart.FileHeader) (string, error) {
+				return "failed/" + header.Filename, nil
+			}),
+		)
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		require.Nil(t, s3Client.object("failed/a.png"))
+		require.Nil(t, s3Client.object("failed/b.svg"))
+	})
+}
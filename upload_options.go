@@ -0,0 +1,110 @@
+package filemanager
+
+import (
+	"maps"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type (
+	// UploadOption represents an upload option function.
+	// Upload options set additional attributes of the uploaded object.
+	UploadOption func(*uploadOptions)
+
+	// uploadOptions represents the additional attributes of an uploaded object.
+	uploadOptions struct {
+		acl                string
+		cacheControl       string
+		contentEncoding    string
+		contentDisposition string
+		metadata           map[string]string
+		trusted            bool
+		noScan             bool
+	}
+)
+
+// WithObjectACL sets the access control list of the uploaded object, e.g. "private".
+// Defaults to DefaultACL.
+func WithObjectACL(acl string) UploadOption {
+	return func(o *uploadOptions) {
+		if acl != "" {
+			o.acl = acl
+		}
+	}
+}
+
+// WithObjectCacheControl sets the Cache-Control header of the uploaded object.
+func WithObjectCacheControl(cacheControl string) UploadOption {
+	return func(o *uploadOptions) {
+		o.cacheControl = cacheControl
+	}
+}
+
+// WithObjectContentEncoding sets the Content-Encoding header of the uploaded object, e.g. "gzip".
+func WithObjectContentEncoding(contentEncoding string) UploadOption {
+	return func(o *uploadOptions) {
+		o.contentEncoding = contentEncoding
+	}
+}
+
+// WithObjectContentDisposition sets the Content-Disposition header of the uploaded object.
+// See AttachmentDisposition and InlineDisposition.
+func WithObjectContentDisposition(contentDisposition string) UploadOption {
+	return func(o *uploadOptions) {
+		o.contentDisposition = contentDisposition
+	}
+}
+
+// WithObjectMetadata adds user-defined metadata to the uploaded object.
+// Metadata keys are case-insensitive and returned in lower case by Stat.
+func WithObjectMetadata(metadata map[string]string) UploadOption {
+	return func(o *uploadOptions) {
+		if o.metadata == nil {
+			o.metadata = make(map[string]string, len(metadata))
+		}
+		maps.Copy(o.metadata, metadata)
+	}
+}
+
+// WithTrustedContent marks the uploaded file as trusted content, e.g. a static asset of the application,
+// so it's stored as is: the SVG sanitizing, the content safety policy and the malware scanner are not applied,
+// and images are not processed, e.g. sanitized, watermarked or resized to variants.
+// Files uploaded by users must never be marked as trusted.
+func WithTrustedContent() UploadOption {
+	return func(o *uploadOptions) {
+		o.trusted = true
+	}
+}
+
+// withoutScanning skips the malware scanner, e.g. for files scanned after they're uploaded.
+func withoutScanning() UploadOption {
+	return func(o *uploadOptions) {
+		o.noScan = true
+	}
+}
+
+// newUploadOptions applies the upload options.
+func newUploadOptions(opts []UploadOption) *uploadOptions {
+	o := &uploadOptions{acl: DefaultACL}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// stringOrNil returns a pointer to the string, or nil if the string is empty,
+// so empty attributes are not sent to S3.
+func stringOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// metadataOrNil converts the metadata to the S3 format, or returns nil if the metadata is empty.
+func metadataOrNil(metadata map[string]string) map[string]*string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	return aws.StringMap(metadata)
+}
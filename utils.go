@@ -1,16 +1,26 @@
 package filemanager
 
 import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
 )
 
 // filenameFromURL returns the filename from the URL.
-// The url is the URL of the file.
-func filenameFromURL(cdnURL, fileURL string) string {
-	return strings.TrimPrefix(fileURL, cdnURL)
+// The baseURL is the URL of the files, including the base path, see FileManager.fileAbsolutePath.
+func filenameFromURL(baseURL, fileURL string) string {
+	return strings.TrimPrefix(fileURL, baseURL)
 }
 
 // handleS3Error handles S3 errors.
@@ -22,9 +32,106 @@ func handleS3Error(err error) error {
 		switch aerr.Code() {
 		case "NotFound": // s3.ErrCodeNoSuchKey does not work, aws is missing this error code so a string comparison is needed.
 			return ErrNotFound
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchUpload: // returned by GetObject and multipart upload requests
+			return ErrNotFound
 		default:
 			return errors.Join(ErrUnexpected, err)
 		}
 	}
 	return err
 }
+
+// randomID generates a random hex-encoded ID.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// matchContentType checks if the content type matches one of the allowed types.
+// Allowed types may contain wildcards, e.g. "image/*".
+// If the list of allowed types is empty, any content type matches.
+func matchContentType(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if a == "*/*" || strings.EqualFold(a, mediaType) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mediaType, strings.ToLower(prefix)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON writes the value as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write JSON response", "error", err)
+	}
+}
+
+// writeJSONError writes the error message as a JSON response with the given status code.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// detectFileContentType returns the content type of the file by its extension,
+// or by its content if the extension is unknown. The reader is rewound to the start.
+func detectFileContentType(name string, r io.ReadSeeker) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// detectStreamContentType returns the content type of the file by its extension,
+// or by its content if the extension is unknown. It returns a reader that must be used instead of r,
+// since the content may be read to detect the content type.
+func detectStreamContentType(name string, r io.Reader) (string, io.Reader, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, r, nil
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	buf, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", nil, err
+	}
+	return http.DetectContentType(buf), br, nil
+}
+
+// readSeekerSize returns the number of bytes remaining to read from the current position.
+func readSeekerSize(r io.ReadSeeker) (int64, error) {
+	cur, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.Seek(cur, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - cur, nil
+}
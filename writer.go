@@ -0,0 +1,106 @@
+package filemanager
+
+import (
+	"context"
+	"io"
+	"mime"
+	"path"
+)
+
+// Writer is an io.WriteCloser that streams written data to the S3 bucket using S3 multipart upload.
+// The file is committed when Close is called.
+// The upload is aborted if the context is canceled or CloseWithError is called.
+type Writer struct {
+	ctx  context.Context
+	pw   *io.PipeWriter
+	done chan struct{}
+	url  string
+	err  error
+}
+
+// Create creates a new file in the S3 bucket and returns a Writer to write the file content.
+// The content type is detected by the file extension.
+// The data is uploaded in parts while it's written, so the file size is not limited by memory.
+// The caller must call Close to commit the file or CloseWithError to abort the upload.
+// The options are applied to the uploaded object, see UploadStream.
+func (fm *FileManager) Create(ctx context.Context, filename string, opts ...UploadOption) *Writer {
+	return fm.CreateWithContentType(ctx, filename, mime.TypeByExtension(path.Ext(filename)), opts...)
+}
+
+// CreateWithContentType creates a new file in the S3 bucket with the given content type
+// and returns a Writer to write the file content.
+// See Create for details.
+func (fm *FileManager) CreateWithContentType(ctx context.Context, filename, contentType string, opts ...UploadOption) *Writer {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	pr, pw := io.Pipe()
+	w := &Writer{
+		ctx:  ctx,
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+
+	// abort the upload if the context is canceled before the writer is closed
+	stop := context.AfterFunc(ctx, func() {
+		pw.CloseWithError(ctx.Err())
+	})
+
+	go func() {
+		defer close(w.done)
+		defer stop()
+
+		w.url, w.err = fm.UploadStream(ctx, pr, filename, contentType, opts...)
+		if w.err != nil {
+			// unblock pending writes if the upload failed
+			pr.CloseWithError(w.err)
+		}
+	}()
+
+	return w
+}
+
+// Write writes data to the file.
+// It returns an error if the upload failed or the writer is closed.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close commits the file and waits until the upload is completed.
+// It returns an error if the upload failed.
+// If the context is already canceled, the upload is aborted instead.
+func (w *Writer) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		w.pw.CloseWithError(err)
+	}
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	<-w.done
+	return w.err
+}
+
+// CloseWithError aborts the upload and waits until the uploaded parts are removed.
+// If err is nil, the upload is aborted with io.ErrClosedPipe.
+func (w *Writer) CloseWithError(err error) error {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+	if err := w.pw.CloseWithError(err); err != nil {
+		return err
+	}
+	<-w.done
+	return nil
+}
+
+// URL returns the URL of the uploaded file.
+// It's available only after the writer is successfully closed.
+func (w *Writer) URL() string {
+	select {
+	case <-w.done:
+		return w.url
+	default:
+		return ""
+	}
+}
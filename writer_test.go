@@ -0,0 +1,123 @@
+package filemanager_test
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmitrymomot/filemanager"
+)
+
+func TestCreate(t *testing.T) {
+	bucket := "test-bucket"
+	filename := "reports/report.csv.gz"
+	uploadID := "upload-id"
+
+	mockS3 := new(mockS3Client)
+	mockS3.On("CreateMultipartUploadWithContext", mock.Anything, &s3.CreateMultipartUploadInput{
+		ACL:         aws.String(defaultACL),
+		ContentType: aws.String("application/gzip"),
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(filename),
+	}, mock.Anything).Return(&s3.CreateMultipartUploadOutput{UploadId: aws.String(uploadID)}, nil)
+	mockS3.On("UploadPartWithContext", mock.Anything, mock.AnythingOfType("*s3.UploadPartInput"), mock.Anything).
+		Return(&s3.UploadPartOutput{ETag: aws.String("etag-1")}, nil).Once()
+	mockS3.On("CompleteMultipartUploadWithContext", mock.Anything, mock.AnythingOfType("*s3.CompleteMultipartUploadInput"), mock.Anything).
+		Return(&s3.CompleteMultipartUploadOutput{}, nil)
+
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(mockS3),
+		filemanager.WithBucketName(bucket),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	w := fm.Create(context.Background(), filename)
+	gz := gzip.NewWriter(w)
+	_, err = gz.Write([]byte("id,name\n1,test\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, w.Close())
+	require.Equal(t, "https://cdn.example.com/uploads/"+filename, w.URL())
+
+	mockS3.AssertExpectations(t)
+}
+
+func TestCreate_Abort(t *testing.T) {
+	uploadID := "upload-id"
+
+	newFileManager := func(t *testing.T, mockS3 *mockS3Client) *filemanager.FileManager {
+		mockS3.On("CreateMultipartUploadWithContext", mock.Anything, mock.Anything, mock.Anything).
+			Return(&s3.CreateMultipartUploadOutput{UploadId: aws.String(uploadID)}, nil)
+		mockS3.On("AbortMultipartUploadWithContext", mock.Anything, mock.Anything, mock.Anything).
+			Return(&s3.AbortMultipartUploadOutput{}, nil).Once()
+
+		fm, err := filemanager.NewWithOptions(
+			filemanager.WithS3Client(mockS3),
+			filemanager.WithBucketName("test-bucket"),
+			filemanager.WithCDNURL("https://cdn.example.com"),
+		)
+		require.NoError(t, err)
+		return fm
+	}
+
+	t.Run("close with error", func(t *testing.T) {
+		mockS3 := new(mockS3Client)
+		fm := newFileManager(t, mockS3)
+
+		w := fm.Create(context.Background(), "export.json")
+		_, err := w.Write([]byte("{}"))
+		require.NoError(t, err)
+		require.NoError(t, w.CloseWithError(errors.New("export failed")))
+		require.Empty(t, w.URL())
+
+		mockS3.AssertExpectations(t)
+		mockS3.AssertNotCalled(t, "UploadPartWithContext", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		mockS3 := new(mockS3Client)
+		fm := newFileManager(t, mockS3)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		w := fm.Create(ctx, "export.json")
+		_, err := w.Write([]byte("{}"))
+		require.NoError(t, err)
+		cancel()
+		require.ErrorIs(t, w.Close(), context.Canceled)
+
+		mockS3.AssertExpectations(t)
+	})
+}
+
+func TestCreate_Options(t *testing.T) {
+	s3Client := newMemoryS3Client()
+	fm, err := filemanager.NewWithOptions(
+		filemanager.WithS3Client(s3Client),
+		filemanager.WithBucketName("test-bucket"),
+		filemanager.WithCDNURL("https://cdn.example.com"),
+	)
+	require.NoError(t, err)
+
+	w := fm.CreateWithContentType(context.Background(), "exports/report.csv", "text/csv",
+		filemanager.WithObjectACL("private"),
+		filemanager.WithObjectContentDisposition(`attachment; filename="report.csv"`),
+		filemanager.WithObjectMetadata(map[string]string{"owner": "42"}),
+	)
+	_, err = w.Write([]byte("id,name\n1,test\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	obj := s3Client.object("exports/report.csv")
+	require.NotNil(t, obj)
+	require.Equal(t, "private", obj.acl)
+	require.Equal(t, "text/csv", obj.contentType)
+	require.Equal(t, `attachment; filename="report.csv"`, obj.contentDisposition)
+	require.Equal(t, "42", aws.StringValue(obj.metadata["owner"]))
+}